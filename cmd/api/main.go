@@ -4,20 +4,23 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/superfly/superfly/internal/auth"
 	"github.com/superfly/superfly/internal/config"
+	"github.com/superfly/superfly/internal/gitops"
 	"github.com/superfly/superfly/internal/handlers"
 	"github.com/superfly/superfly/internal/k8s"
+	"github.com/superfly/superfly/internal/logging"
 	"github.com/superfly/superfly/internal/service"
+	"github.com/superfly/superfly/internal/tracing"
+	"github.com/superfly/superfly/internal/version"
 )
 
 func main() {
@@ -28,78 +31,168 @@ func main() {
 	}
 
 	// Setup logger
-	logger := log.New(os.Stdout, "[superfly] ", log.LstdFlags|log.Lshortfile)
-	logger.Println("Starting Superfly API server...")
+	logger, err := logging.New(os.Stdout, cfg.LogLevel, cfg.Environment)
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	slog.SetDefault(logger)
+	logger.Info("Starting Superfly API server...",
+		"version", version.Version,
+		"commit", version.Commit,
+		"env", cfg.Environment,
+		"log_level", cfg.LogLevel,
+	)
+
+	// Setup tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Endpoint:    cfg.OTELExporterEndpoint,
+		ServiceName: cfg.OTELServiceName,
+		Environment: cfg.Environment,
+		SampleRatio: cfg.OTELSampleRatio,
+	})
+	if err != nil {
+		fatal(logger, "Failed to setup tracing", err)
+	}
+	if cfg.OTELExporterEndpoint != "" {
+		logger.Info("✓ Tracing enabled", "endpoint", cfg.OTELExporterEndpoint)
+	}
 
 	// Connect to database
-	dbpool, err := pgxpool.New(context.Background(), cfg.DatabaseURL)
+	poolConfig, err := pgxpool.ParseConfig(cfg.DatabaseURL)
+	if err != nil {
+		fatal(logger, "Failed to parse database URL", err)
+	}
+	poolConfig.ConnConfig.Tracer = tracing.PgxTracer{}
+
+	dbpool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
-		logger.Fatalf("Failed to connect to database: %v", err)
+		fatal(logger, "Failed to connect to database", err)
 	}
 	defer dbpool.Close()
 
 	// Test database connection
 	if err := dbpool.Ping(context.Background()); err != nil {
-		logger.Fatalf("Failed to ping database: %v", err)
+		fatal(logger, "Failed to ping database", err)
 	}
-	logger.Println("✓ Connected to database")
+	logger.Info("✓ Connected to database")
 
 	// Initialize Kubernetes client
-	k8sClient, err := k8s.NewClient(cfg.KubernetesInCluster, cfg.Kubeconfig)
+	quota, err := k8s.ParseNamespaceQuota(cfg.NamespaceCPUQuota, cfg.NamespaceMemoryQuota, cfg.NamespacePodQuota,
+		cfg.NamespaceDefaultCPU, cfg.NamespaceDefaultMemory)
+	if err != nil {
+		fatal(logger, "Invalid namespace quota", err)
+	}
+	// Without a clusters registry, the cluster the API was always configured
+	// with is the only one
+	clusters := []k8s.ClusterConfig{{
+		Name:       cfg.DefaultCluster,
+		InCluster:  cfg.KubernetesInCluster,
+		Kubeconfig: cfg.Kubeconfig,
+	}}
+	if cfg.ClustersFile != "" {
+		clusters, err = k8s.LoadClusters(cfg.ClustersFile)
+		if err != nil {
+			fatal(logger, "Failed to load clusters", err)
+		}
+	}
+	k8sPool, err := k8s.NewPool(clusters, cfg.DefaultCluster, quota, logger)
 	if err != nil {
-		logger.Fatalf("Failed to create Kubernetes client: %v", err)
+		fatal(logger, "Failed to create Kubernetes clients", err)
+	}
+	logger.Info("✓ Connected to Kubernetes clusters", "clusters", len(clusters), "default", cfg.DefaultCluster)
+
+	// Without a sizes catalog, apps set their resources themselves
+	var sizes *service.Sizes
+	if cfg.SizesFile != "" {
+		catalog, err := k8s.LoadSizes(cfg.SizesFile)
+		if err != nil {
+			fatal(logger, "Failed to load sizes", err)
+		}
+		sizes, err = service.NewSizes(catalog, cfg.DefaultSize)
+		if err != nil {
+			fatal(logger, "Invalid sizes", err)
+		}
+		logger.Info("✓ Sizes catalog loaded", "sizes", len(catalog), "default", sizes.Default())
 	}
-	logger.Println("✓ Connected to Kubernetes cluster")
 
-	// Ensure namespace exists
-	ctx := context.Background()
-	if err := k8sClient.EnsureNamespace(ctx); err != nil {
-		logger.Printf("Warning: Failed to ensure namespace: %v", err)
+	// Load API tokens; without them the API is open to everyone, as it
+	// always was, except exec and port-forward
+	var tokens *auth.Tokens
+	if cfg.TokensFile != "" {
+		tokens, err = auth.LoadTokens(cfg.TokensFile)
+		if err != nil {
+			fatal(logger, "Failed to load tokens", err)
+		}
+		logger.Info("✓ Authentication enabled")
+	} else {
+		logger.Warn("⚠ TOKENS_FILE is not set: the API is open to everyone, with admin rights. " +
+			"Exec and port-forward are refused until tokens are configured.")
+	}
+	execRole, err := auth.ParseRole(cfg.ExecRole)
+	if err != nil {
+		fatal(logger, "Invalid EXEC_ROLE", err)
+	}
+	portForwardRole, err := auth.ParseRole(cfg.PortForwardRole)
+	if err != nil {
+		fatal(logger, "Invalid PORT_FORWARD_ROLE", err)
 	}
 
 	// Initialize services
-	appService := service.NewAppService(dbpool, k8sClient)
+	eventService := service.NewEventService(dbpool, cfg.EventRetention, logger)
+	appService := service.NewAppService(dbpool, k8sPool, service.Namespaces{
+		Default:      cfg.AppsNamespace,
+		TenantPrefix: cfg.TenantNamespacePrefix,
+	}, sizes, eventService, logger)
 
-	// Initialize handlers
-	appHandlers := handlers.NewAppHandlers(appService)
-	healthHandlers := handlers.NewHealthHandlers()
+	// Ensure namespaces exist with their quotas and network policies
+	ctx := context.Background()
+	if err := appService.EnsureNamespaces(ctx); err != nil {
+		logger.Warn("Failed to ensure namespaces", "error", err)
+	}
+	idempotencyService := service.NewIdempotencyService(dbpool, cfg.IdempotencyKeyTTL, logger)
+
+	syncer := gitops.NewSyncer(gitops.Options{
+		RepoURL:  cfg.GitOpsRepoURL,
+		Branch:   cfg.GitOpsBranch,
+		Path:     cfg.GitOpsPath,
+		Dir:      cfg.GitOpsDir,
+		Interval: cfg.GitOpsInterval,
+		Prune:    cfg.GitOpsPrune,
+	}, appService, logger)
+
+	// Background workers, stopped on shutdown
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go idempotencyService.RunJanitor(backgroundCtx, time.Hour)
+	go eventService.RunListener(backgroundCtx)
+	go eventService.RunJanitor(backgroundCtx, time.Hour)
+	go appService.WatchPodEvents(backgroundCtx)
+	go syncer.Run(backgroundCtx)
+	if syncer.Enabled() {
+		logger.Info("GitOps sync enabled", "branch", cfg.GitOpsBranch, "interval", cfg.GitOpsInterval)
+	}
 
 	// Setup router
-	r := chi.NewRouter()
-
-	// Middleware
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
-
-	// CORS
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:*", "http://127.0.0.1:*"},
-		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	}))
-
-	// Health check routes
-	r.Get("/health", healthHandlers.Health)
-	r.Get("/ready", healthHandlers.Ready)
-
-	// API routes
-	r.Route("/api", func(r chi.Router) {
-		r.Route("/apps", func(r chi.Router) {
-			r.Get("/", appHandlers.ListApps)
-			r.Post("/", appHandlers.CreateApp)
-			r.Get("/{id}", appHandlers.GetApp)
-			r.Patch("/{id}", appHandlers.UpdateApp)
-			r.Delete("/{id}", appHandlers.DeleteApp)
-			r.Post("/{id}/restart", appHandlers.RestartApp)
-		})
+	apiDoc := handlers.NewOpenAPIDocument()
+	r := handlers.NewRouter(handlers.RouterOptions{
+		Logger:          logger,
+		Doc:             apiDoc,
+		Tokens:          tokens,
+		ExecRole:        execRole,
+		PortForwardRole: portForwardRole,
+		Idempotency:     idempotencyService,
+		Apps:            handlers.NewAppHandlers(appService),
+		Health:          handlers.NewHealthHandlers(dbpool, k8sPool, appService, cfg.AppsNamespace),
+		Sync:            handlers.NewSyncHandlers(syncer),
+		Clusters:        handlers.NewClusterHandlers(k8sPool),
+		Sizes:           handlers.NewSizeHandlers(sizes),
+		Events:          handlers.NewEventHandlers(eventService),
 	})
 
+	if err := apiDoc.CheckRoutes(r); err != nil {
+		fatal(logger, "Invalid API documentation", err)
+	}
+
 	// Start server
 	addr := fmt.Sprintf("%s:%s", cfg.APIHost, cfg.APIPort)
 	server := &http.Server{
@@ -109,13 +202,16 @@ func main() {
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	// Shutdown waits for requests to finish, which event streams only do
+	// when told
+	server.RegisterOnShutdown(eventService.Close)
 
 	// Start server in goroutine
 	go func() {
-		logger.Printf("🚀 Server listening on %s", addr)
-		logger.Printf("📝 API documentation: http://%s/api", addr)
+		logger.Info("🚀 Server listening", "addr", addr)
+		logger.Info("📝 API documentation", "url", fmt.Sprintf("http://%s/api/openapi.json", addr))
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("Failed to start server: %v", err)
+			fatal(logger, "Failed to start server", err)
 		}
 	}()
 
@@ -124,14 +220,24 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	logger.Println("Shutting down server...")
+	logger.Info("Shutting down server...")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		logger.Fatalf("Server forced to shutdown: %v", err)
+		fatal(logger, "Server forced to shutdown", err)
 	}
 
-	logger.Println("Server stopped gracefully")
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Warn("Failed to flush traces", "error", err)
+	}
+
+	logger.Info("Server stopped gracefully")
+}
+
+// fatal logs err at error level and exits
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }
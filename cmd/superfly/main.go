@@ -0,0 +1,137 @@
+// Command superfly is the command-line client of the superfly API.
+//
+// Usage:
+//
+//	superfly ssh [flags] <app> [-- command [args...]]
+//	superfly port-forward [flags] <app> [local:]remote
+//
+// The API is reached at SUPERFLY_API_URL, http://localhost:8080 by default,
+// with the bearer token in SUPERFLY_TOKEN, if set.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/superfly/superfly/internal/version"
+)
+
+const defaultAPIURL = "http://localhost:8080"
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "ssh":
+		err = runSSH(os.Args[2:])
+	case "port-forward":
+		err = runPortForward(os.Args[2:])
+	case "version":
+		fmt.Println(version.Version)
+	case "help", "-h", "--help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "superfly: unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	var exit exitCode
+	if errors.As(err, &exit) {
+		os.Exit(int(exit))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "superfly:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage: superfly <command> [arguments]
+
+Commands:
+  ssh           Open a shell, or run a command, in a container of an app
+  port-forward  Forward a local port to a port of a pod of an app
+  version       Print the version
+
+Environment:
+  SUPERFLY_API_URL  URL of the API (default `+defaultAPIURL+`)
+  SUPERFLY_TOKEN    Bearer token to call the API with
+`)
+}
+
+// exitCode is the exit code of a remote command, which superfly exits with
+type exitCode int
+
+func (e exitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(e))
+}
+
+// client calls the API
+type client struct {
+	baseURL *url.URL
+	token   string
+}
+
+func newClient() (*client, error) {
+	raw := os.Getenv("SUPERFLY_API_URL")
+	if raw == "" {
+		raw = defaultAPIURL
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(raw, "/"))
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") {
+		return nil, fmt.Errorf("SUPERFLY_API_URL must be an http or https URL")
+	}
+	return &client{baseURL: baseURL, token: os.Getenv("SUPERFLY_TOKEN")}, nil
+}
+
+// dial opens a WebSocket to an API path
+func (c *client) dial(ctx context.Context, path string, query url.Values) (*websocket.Conn, error) {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+
+	header := http.Header{}
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	}
+	ws, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+		return nil, apiError(resp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", c.baseURL, err)
+	}
+	return ws, nil
+}
+
+// apiError describes the error response of the API
+func apiError(resp *http.Response) error {
+	defer resp.Body.Close()
+	var body struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(data, &body); err != nil || body.Message == "" {
+		return fmt.Errorf("API responded %s", resp.Status)
+	}
+	return fmt.Errorf("%s (%s)", body.Message, body.Code)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/superfly/superfly/internal/session"
+)
+
+// runPortForward listens on a local port and forwards every connection to
+// it to a port of a pod of an app, like kubectl port-forward
+func runPortForward(args []string) error {
+	flags := flag.NewFlagSet("port-forward", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: superfly port-forward [flags] <app> [local:]remote")
+		flags.PrintDefaults()
+	}
+	pod := flags.String("pod", "", "pod to forward to (default a random running pod)")
+	address := flags.String("address", "127.0.0.1", "local address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return exitCode(2)
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitCode(2)
+	}
+	app := flags.Arg(0)
+	local, remote, err := parsePorts(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(*address, strconv.Itoa(local)))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	query := url.Values{}
+	if *pod != "" {
+		query.Set("pod", *pod)
+	}
+	query.Set("port", strconv.Itoa(remote))
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ws, err := c.dial(context.Background(), "/api/apps/"+url.PathEscape(app)+"/port-forward", query)
+	if err != nil {
+		return err
+	}
+	conn := session.NewConn(ws)
+	defer conn.Close()
+
+	fmt.Fprintf(os.Stderr, "Forwarding from %s to port %d of %s\n", listener.Addr(), remote, app)
+	return forwardConnections(listener, conn)
+}
+
+// forwardConnections forwards the connections the listener accepts over a
+// port-forward session until the session ends
+func forwardConnections(listener net.Listener, conn *session.Conn) error {
+	forwards := &localConns{conns: make(map[uint32]*localConn)}
+	defer forwards.closeAll()
+	go forwards.accept(listener, conn)
+
+	for {
+		channel, payload, err := conn.Receive()
+		if err != nil {
+			return fmt.Errorf("session ended unexpectedly: %w", err)
+		}
+
+		switch channel {
+		case session.ForwardData:
+			id, data, err := session.ParseForward(payload)
+			if err != nil {
+				continue
+			}
+			forwards.write(id, data)
+		case session.ForwardClose:
+			id, reason, err := session.ParseForward(payload)
+			if err != nil {
+				continue
+			}
+			forwards.close(id)
+			if len(reason) > 0 {
+				fmt.Fprintf(os.Stderr, "connection %d failed: %s\n", id, reason)
+			}
+		case session.ChannelStatus:
+			var status session.Status
+			if err := json.Unmarshal(payload, &status); err != nil {
+				return fmt.Errorf("invalid session status: %w", err)
+			}
+			if status.Error != "" {
+				return fmt.Errorf("%s (%s)", status.Error, status.Code)
+			}
+			return nil
+		}
+	}
+}
+
+// parsePorts parses [local:]remote, where the local port defaults to the
+// remote one
+func parsePorts(arg string) (local, remote int, err error) {
+	localArg, remoteArg, found := strings.Cut(arg, ":")
+	if !found {
+		remoteArg = localArg
+	}
+	if remote, err = strconv.Atoi(remoteArg); err != nil || remote < 1 || remote > 65535 {
+		return 0, 0, fmt.Errorf("invalid remote port %q", remoteArg)
+	}
+	// Port 0 picks a free one
+	if local, err = strconv.Atoi(localArg); err != nil || local < 0 || local > 65535 {
+		return 0, 0, fmt.Errorf("invalid local port %q", localArg)
+	}
+	return local, remote, nil
+}
+
+// forwardBuffer is how many messages from the pod may wait to be written
+// to a local connection before it is closed as too slow
+const forwardBuffer = 16
+
+// localConns are the local connections forwarded over a session, by the
+// ID the session knows them by
+type localConns struct {
+	mu     sync.Mutex
+	conns  map[uint32]*localConn
+	nextID uint32
+}
+
+// localConn is a local connection forwarded over a session
+type localConn struct {
+	conn net.Conn
+	// data is the pod's data for the connection, closed once the pod
+	// closed its side of it
+	data chan []byte
+}
+
+// accept forwards connections to the listener until it is closed
+func (f *localConns) accept(listener net.Listener, conn *session.Conn) {
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		id := f.nextID
+		f.nextID++
+		c := &localConn{conn: local, data: make(chan []byte, forwardBuffer)}
+		f.conns[id] = c
+		f.mu.Unlock()
+
+		go c.writeLocal()
+		if err := conn.SendForward(session.ForwardOpen, id, nil); err != nil {
+			f.close(id)
+			continue
+		}
+		go func() {
+			// The pod sees the end of what the local connection sent, and
+			// the connection stays open until the pod closes its side
+			io.Copy(conn.ForwardWriter(id), local)
+			conn.SendForward(session.ForwardClose, id, nil)
+		}()
+	}
+}
+
+// write queues the pod's data for a connection. Waiting for a connection
+// that doesn't keep up would hold up every other one, so it is closed
+// instead, which closes it on the pod too.
+func (f *localConns) write(id uint32, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.conns[id]
+	if !ok {
+		return
+	}
+	select {
+	case c.data <- data:
+	default:
+		fmt.Fprintf(os.Stderr, "connection %d closed: it didn't keep up with the data from the pod\n", id)
+		c.conn.Close()
+		close(c.data)
+		delete(f.conns, id)
+	}
+}
+
+// close closes a connection the pod closed, once the data the pod sent
+// before is written
+func (f *localConns) close(id uint32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.conns[id]; ok {
+		close(c.data)
+		delete(f.conns, id)
+	}
+}
+
+func (f *localConns) closeAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, c := range f.conns {
+		c.conn.Close()
+		close(c.data)
+		delete(f.conns, id)
+	}
+}
+
+// writeLocal writes the pod's data to the local connection until the pod
+// closes its side of it, then closes the connection
+func (c *localConn) writeLocal() {
+	defer c.conn.Close()
+	var err error
+	for data := range c.data {
+		// Data after a failed write is dropped
+		if err != nil {
+			continue
+		}
+		if _, err = c.conn.Write(data); err != nil {
+			c.conn.Close()
+		}
+	}
+}
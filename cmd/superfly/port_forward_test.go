@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/superfly/superfly/internal/session"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		arg        string
+		wantLocal  int
+		wantRemote int
+		wantErr    string
+	}{
+		{arg: "8080", wantLocal: 8080, wantRemote: 8080},
+		{arg: "9000:80", wantLocal: 9000, wantRemote: 80},
+		{arg: "0:5432", wantLocal: 0, wantRemote: 5432},
+		{arg: "", wantErr: `invalid remote port ""`},
+		{arg: "http", wantErr: `invalid remote port "http"`},
+		{arg: "8080:0", wantErr: `invalid remote port "0"`},
+		{arg: "70000", wantErr: `invalid remote port "70000"`},
+		{arg: ":80", wantErr: `invalid local port ""`},
+		{arg: "-1:80", wantErr: `invalid local port "-1"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			local, remote, err := parsePorts(tt.arg)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if local != tt.wantLocal || remote != tt.wantRemote {
+				t.Errorf("got %d:%d, want %d:%d", local, remote, tt.wantLocal, tt.wantRemote)
+			}
+		})
+	}
+}
+
+func TestForwardConnections(t *testing.T) {
+	listener, api, done := startForwarding(t)
+
+	// Connections get IDs in the order they are accepted
+	first := dialLocal(t, listener)
+	receiveForward(t, api, session.ForwardOpen, 0)
+	second := dialLocal(t, listener)
+	receiveForward(t, api, session.ForwardOpen, 1)
+
+	if _, err := first.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if data := receiveForward(t, api, session.ForwardData, 0); string(data) != "ping" {
+		t.Errorf("the pod got %q, want ping", data)
+	}
+
+	// Data goes to the connection of its ID, and data of unknown IDs is
+	// dropped
+	api.SendForward(session.ForwardData, 7, []byte("nobody"))
+	api.SendForward(session.ForwardData, 1, []byte("pong"))
+	assertRead(t, second, "pong")
+
+	// A connection the pod closes gets the data sent before, then ends,
+	// while the others stay open
+	api.SendForward(session.ForwardData, 0, []byte("bye"))
+	api.SendForward(session.ForwardClose, 0, nil)
+	assertRead(t, first, "bye")
+	assertClosed(t, first)
+	api.SendForward(session.ForwardData, 1, []byte("still open"))
+	assertRead(t, second, "still open")
+
+	// The session ends with its status, closing every connection
+	api.SendJSON(session.ChannelStatus, session.Status{})
+	if err := <-done; err != nil {
+		t.Errorf("got error %v, want none", err)
+	}
+	assertClosed(t, second)
+}
+
+func TestForwardConnectionsStatusError(t *testing.T) {
+	_, api, done := startForwarding(t)
+
+	api.SendJSON(session.ChannelStatus, session.Status{Error: "lost connection to the pod", Code: "internal_error"})
+	if err := <-done; err == nil || err.Error() != "lost connection to the pod (internal_error)" {
+		t.Errorf("got error %v, want the session's error", err)
+	}
+}
+
+func TestForwardConnectionsClosesSlowConnections(t *testing.T) {
+	listener, api, done := startForwarding(t)
+
+	slow := dialLocal(t, listener)
+	defer slow.Close()
+	receiveForward(t, api, session.ForwardOpen, 0)
+
+	// The local connection never reads, so once the socket buffers and
+	// forwardBuffer are full it is closed, which the pod is told
+	chunk := bytes.Repeat([]byte("x"), 1<<20)
+	for i := 0; i < 64; i++ {
+		if err := api.SendForward(session.ForwardData, 0, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receiveForward(t, api, session.ForwardClose, 0)
+
+	api.SendJSON(session.ChannelStatus, session.Status{})
+	if err := <-done; err != nil {
+		t.Errorf("got error %v, want none", err)
+	}
+}
+
+// startForwarding runs forwardConnections against a fake API, and returns
+// the local listener, the API's side of the session and the error
+// forwardConnections returns
+func startForwarding(t *testing.T) (net.Listener, *session.Conn, <-chan error) {
+	t.Helper()
+
+	apiConns := make(chan *session.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		apiConns <- session.NewConn(ws)
+	}))
+	t.Cleanup(server.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := session.NewConn(ws)
+	t.Cleanup(func() { conn.Close() })
+	api := <-apiConns
+	t.Cleanup(func() { api.Close() })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	done := make(chan error, 1)
+	go func() { done <- forwardConnections(listener, conn) }()
+	return listener, api, done
+}
+
+func dialLocal(t *testing.T, listener net.Listener) net.Conn {
+	t.Helper()
+	local, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.SetDeadline(time.Now().Add(10 * time.Second))
+	t.Cleanup(func() { local.Close() })
+	return local
+}
+
+// receiveForward returns the payload of the next message the API gets,
+// which must be on channel for connection id
+func receiveForward(t *testing.T, api *session.Conn, channel byte, id uint32) []byte {
+	t.Helper()
+	gotChannel, payload, err := api.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotID, data, err := session.ParseForward(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotChannel != channel || gotID != id {
+		t.Fatalf("got message on channel %d for connection %d, want channel %d for connection %d", gotChannel, gotID, channel, id)
+	}
+	return data
+}
+
+func assertRead(t *testing.T, local net.Conn, want string) {
+	t.Helper()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(local, got); err != nil {
+		t.Fatalf("reading %q: %v", want, err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func assertClosed(t *testing.T, local net.Conn) {
+	t.Helper()
+	n, err := local.Read(make([]byte, 1))
+	if n != 0 || !errors.Is(err, io.EOF) {
+		t.Errorf("got %d bytes and error %v, want the connection closed", n, err)
+	}
+}
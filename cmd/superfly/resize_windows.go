@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// resizePollInterval is how often the terminal's size is sent on Windows,
+// which doesn't signal changes
+const resizePollInterval = 250 * time.Millisecond
+
+// watchResize calls resized periodically, until ctx is done
+func watchResize(ctx context.Context, resized func()) {
+	ticker := time.NewTicker(resizePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			resized()
+		}
+	}
+}
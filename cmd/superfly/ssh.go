@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/superfly/superfly/internal/session"
+	"golang.org/x/term"
+)
+
+// runSSH opens a shell, or runs a command, in a container of an app. Like
+// ssh, a terminal is allocated when there is no command and stdin is one.
+func runSSH(args []string) error {
+	flags := flag.NewFlagSet("ssh", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: superfly ssh [flags] <app> [-- command [args...]]")
+		flags.PrintDefaults()
+	}
+	pod := flags.String("pod", "", "pod to connect to (default a random running pod)")
+	container := flags.String("container", "", "container to connect to, for pods with several")
+	forceTTY := flags.Bool("t", false, "allocate a terminal even for a command")
+	if err := flags.Parse(args); err != nil {
+		return exitCode(2)
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return exitCode(2)
+	}
+	app, command := flags.Arg(0), flags.Args()[1:]
+	if len(command) > 0 && command[0] == "--" {
+		command = command[1:]
+	}
+
+	stdinFd := int(os.Stdin.Fd())
+	tty := term.IsTerminal(stdinFd) && (len(command) == 0 || *forceTTY)
+
+	query := url.Values{}
+	if *pod != "" {
+		query.Set("pod", *pod)
+	}
+	if *container != "" {
+		query.Set("container", *container)
+	}
+	query["command"] = command
+	query.Set("tty", strconv.FormatBool(tty))
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ws, err := c.dial(ctx, "/api/apps/"+url.PathEscape(app)+"/exec", query)
+	if err != nil {
+		return err
+	}
+	conn := session.NewConn(ws)
+	defer conn.Close()
+
+	if tty {
+		state, err := term.MakeRaw(stdinFd)
+		if err != nil {
+			return fmt.Errorf("failed to set up terminal: %w", err)
+		}
+		defer term.Restore(stdinFd, state)
+
+		var size session.Resize
+		sendSize := func() {
+			width, height, err := term.GetSize(stdinFd)
+			if err != nil {
+				return
+			}
+			if resize := (session.Resize{Width: uint16(width), Height: uint16(height)}); resize != size {
+				size = resize
+				conn.SendJSON(session.ChannelResize, resize)
+			}
+		}
+		sendSize()
+		go watchResize(ctx, sendSize)
+	}
+
+	go func() {
+		if _, err := io.Copy(conn.Writer(session.ChannelStdin), os.Stdin); err == nil {
+			// An empty message closes the command's stdin
+			conn.Send(session.ChannelStdin, nil)
+		}
+	}()
+
+	for {
+		channel, payload, err := conn.Receive()
+		if err != nil {
+			return fmt.Errorf("session ended unexpectedly: %w", err)
+		}
+
+		switch channel {
+		case session.ChannelStdout:
+			os.Stdout.Write(payload)
+		case session.ChannelStderr:
+			os.Stderr.Write(payload)
+		case session.ChannelStatus:
+			var status session.Status
+			if err := json.Unmarshal(payload, &status); err != nil {
+				return fmt.Errorf("invalid session status: %w", err)
+			}
+			if status.Error != "" {
+				return fmt.Errorf("%s (%s)", status.Error, status.Code)
+			}
+			if status.ExitCode != 0 {
+				return exitCode(status.ExitCode)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE apps
+    ADD COLUMN owner VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
+
+-- Indexes for list filters
+CREATE INDEX idx_apps_owner ON apps(owner);
+CREATE INDEX idx_apps_labels ON apps USING GIN (labels jsonb_path_ops);
+CREATE INDEX idx_apps_created_at_id ON apps(created_at, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_apps_created_at_id;
+DROP INDEX IF EXISTS idx_apps_labels;
+DROP INDEX IF EXISTS idx_apps_owner;
+ALTER TABLE apps
+    DROP COLUMN labels,
+    DROP COLUMN owner;
+-- +goose StatementEnd
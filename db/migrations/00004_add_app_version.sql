@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Incremented on every update; exposed to clients as the app's ETag
+ALTER TABLE apps
+    ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE apps
+    DROP COLUMN version;
+-- +goose StatementEnd
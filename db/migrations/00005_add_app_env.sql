@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Container environment: a JSON array of {name, value} or
+-- {name, secret: {name, key}} objects
+ALTER TABLE apps
+    ADD COLUMN env JSONB NOT NULL DEFAULT '[]';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE apps
+    DROP COLUMN env;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Probe overrides: a JSON object with optional liveness, readiness and
+-- startup probes. Probes left out use the defaults on health_check_path.
+ALTER TABLE apps
+    ADD COLUMN health_checks JSONB NOT NULL DEFAULT '{}';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE apps
+    DROP COLUMN health_checks;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Named container ports: a JSON array of {name, port, protocol, ...}
+-- objects. An empty array exposes the single http port in the port column.
+ALTER TABLE apps
+    ADD COLUMN ports JSONB NOT NULL DEFAULT '[]',
+    ADD COLUMN service_type TEXT NOT NULL DEFAULT 'cluster_ip';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE apps
+    DROP COLUMN service_type,
+    DROP COLUMN ports;
+-- +goose StatementEnd
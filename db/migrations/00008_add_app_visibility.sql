@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- visibility is public, internal or none. aliases are extra Service names
+-- for the app, unique across slugs and aliases; allow_from lists the slugs
+-- of apps allowed to call an internal app.
+ALTER TABLE apps
+    ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public',
+    ADD COLUMN aliases TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN allow_from TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_apps_aliases ON apps USING GIN (aliases);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_apps_aliases;
+ALTER TABLE apps
+    DROP COLUMN allow_from,
+    DROP COLUMN aliases,
+    DROP COLUMN visibility;
+-- +goose StatementEnd
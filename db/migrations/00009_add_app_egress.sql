@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- egress is allow_all, deny_internet or allowlist; egress_cidrs are the
+-- destinations an allowlist permits besides other apps.
+ALTER TABLE apps
+    ADD COLUMN egress TEXT NOT NULL DEFAULT 'allow_all',
+    ADD COLUMN egress_cidrs TEXT[] NOT NULL DEFAULT '{}';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE apps
+    DROP COLUMN egress_cidrs,
+    DROP COLUMN egress;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- namespace is the Kubernetes namespace the app is deployed to. Apps that
+-- existed before namespaces were per tenant stay in superfly-apps, where
+-- their resources already are; new apps always set it.
+ALTER TABLE apps ADD COLUMN namespace TEXT NOT NULL DEFAULT 'superfly-apps';
+ALTER TABLE apps ALTER COLUMN namespace DROP DEFAULT;
+
+CREATE INDEX idx_apps_namespace ON apps(namespace);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_apps_namespace;
+ALTER TABLE apps DROP COLUMN namespace;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- cluster names the entry of the clusters registry the app is deployed to.
+-- Apps that existed before there were several clusters are in the one the
+-- API was configured with, which the registry calls "default" unless
+-- CLUSTERS_FILE names it otherwise.
+ALTER TABLE apps ADD COLUMN cluster TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE apps ALTER COLUMN cluster DROP DEFAULT;
+
+CREATE INDEX idx_apps_cluster ON apps(cluster);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_apps_cluster;
+ALTER TABLE apps DROP COLUMN cluster;
+-- +goose StatementEnd
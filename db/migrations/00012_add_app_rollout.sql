@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- status_reason says why the last deploy failed, and is empty otherwise.
+-- auto_rollback rolls the Deployment back to its previous revision when a
+-- rollout fails.
+ALTER TABLE apps ADD COLUMN status_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE apps ADD COLUMN auto_rollback BOOLEAN NOT NULL DEFAULT false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE apps DROP COLUMN auto_rollback;
+ALTER TABLE apps DROP COLUMN status_reason;
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS app_events (
+    -- Orders the events; streams resume after the last ID a client saw
+    id BIGSERIAL PRIMARY KEY,
+    app_id UUID NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+
+    -- status, deploy or pod, with data as described in API.md
+    type VARCHAR(50) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+
+    -- Identifies an event seen in Kubernetes, so the watch of every API
+    -- replica records it once; NULL for events superfly raises itself
+    source_key TEXT UNIQUE,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_app_events_app_id ON app_events(app_id, id);
+CREATE INDEX idx_app_events_created_at ON app_events(created_at);
+
+-- Tells every API replica about new events, so each can push them to the
+-- streams of its clients
+CREATE OR REPLACE FUNCTION notify_app_event() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('app_events', NEW.id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER app_events_notify
+    AFTER INSERT ON app_events
+    FOR EACH ROW EXECUTE FUNCTION notify_app_event();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS app_events;
+DROP FUNCTION IF EXISTS notify_app_event();
+-- +goose StatementEnd
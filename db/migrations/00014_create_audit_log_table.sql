@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+
+    -- No foreign key: entries outlive their app, which the slug then
+    -- still names
+    app_id UUID NOT NULL,
+    app_slug VARCHAR(63) NOT NULL,
+
+    -- Name of the token of the caller, or anonymous without tokens
+    actor VARCHAR(255) NOT NULL,
+    -- What was done, such as exec.start, with its details in data
+    action VARCHAR(50) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_audit_log_app_id ON audit_log(app_id, id);
+CREATE INDEX idx_audit_log_actor ON audit_log(actor, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_log;
+-- +goose StatementEnd
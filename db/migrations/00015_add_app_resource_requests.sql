@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Requests left empty are half their limits. Ephemeral storage is neither
+-- reserved nor capped unless set.
+ALTER TABLE apps ADD COLUMN cpu_request VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE apps ADD COLUMN memory_request VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE apps ADD COLUMN ephemeral_storage_request VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE apps ADD COLUMN ephemeral_storage_limit VARCHAR(10) NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE apps DROP COLUMN ephemeral_storage_limit;
+ALTER TABLE apps DROP COLUMN ephemeral_storage_request;
+ALTER TABLE apps DROP COLUMN memory_request;
+ALTER TABLE apps DROP COLUMN cpu_request;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The size of the catalog the app runs as. Empty means the app's own
+-- resources apply.
+ALTER TABLE apps ADD COLUMN size VARCHAR(63) NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE apps DROP COLUMN size;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Keyset pagination compares (sort column, id), so each sort option of the
+-- app listing needs an index on that pair; created_at has one already.
+CREATE INDEX idx_apps_updated_at_id ON apps(updated_at, id);
+CREATE INDEX idx_apps_name_id ON apps(name, id);
+CREATE INDEX idx_apps_slug_id ON apps(slug, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_apps_slug_id;
+DROP INDEX IF EXISTS idx_apps_name_id;
+DROP INDEX IF EXISTS idx_apps_updated_at_id;
+-- +goose StatementEnd
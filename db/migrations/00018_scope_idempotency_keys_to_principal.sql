@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Keys are chosen by clients, so each caller gets their own: another caller
+-- reusing a key must not be handed the stored response. Keys stored before
+-- callers had names belong to no one.
+ALTER TABLE idempotency_keys ADD COLUMN principal VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (principal, key);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM idempotency_keys WHERE principal <> '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (key);
+ALTER TABLE idempotency_keys DROP COLUMN principal;
+-- +goose StatementEnd
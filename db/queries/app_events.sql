@@ -0,0 +1,24 @@
+-- name: CreateAppEvent :one
+-- Records an event. An event with the source_key of one already recorded is
+-- dropped, and no row is returned.
+INSERT INTO app_events (app_id, type, data, source_key)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (source_key) DO NOTHING
+RETURNING *;
+
+-- name: GetAppEvent :one
+SELECT * FROM app_events
+WHERE id = $1 LIMIT 1;
+
+-- name: ListAppEventsAfter :many
+-- Events after an ID, oldest first, of one app or of every app when app_id
+-- is NULL
+SELECT * FROM app_events
+WHERE id > sqlc.arg('after')
+  AND (sqlc.narg('app_id')::uuid IS NULL OR app_id = sqlc.narg('app_id')::uuid)
+ORDER BY id
+LIMIT sqlc.arg('page_size');
+
+-- name: DeleteAppEventsBefore :execrows
+DELETE FROM app_events
+WHERE created_at < $1;
@@ -9,9 +9,29 @@ INSERT INTO apps (
     memory_limit,
     domain,
     health_check_path,
-    status
+    status,
+    owner,
+    labels,
+    env,
+    health_checks,
+    ports,
+    service_type,
+    visibility,
+    aliases,
+    allow_from,
+    egress,
+    egress_cidrs,
+    namespace,
+    cluster,
+    auto_rollback,
+    cpu_request,
+    memory_request,
+    ephemeral_storage_request,
+    ephemeral_storage_limit,
+    size
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24,
+    $25, $26, $27, $28, $29
 )
 RETURNING *;
 
@@ -23,19 +43,147 @@ WHERE id = $1 LIMIT 1;
 SELECT * FROM apps
 WHERE slug = $1 LIMIT 1;
 
--- name: ListApps :many
+-- name: ListAppsByCreatedAtAsc :many
+-- The ListAppsBy* queries page through apps with keyset pagination over
+-- (sort column, id): a page starts strictly after the cursor. There is one
+-- query per sort column and direction so that each compares native-typed
+-- row values an index on (column, id) can serve. Queries on the same kind of
+-- column take identical parameters, so their params convert into each other.
 SELECT * FROM apps
-ORDER BY created_at DESC;
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text)
+  AND (sqlc.narg('search')::text IS NULL
+       OR name ILIKE '%' || sqlc.narg('search')::text || '%'
+       OR slug ILIKE '%' || sqlc.narg('search')::text || '%')
+  AND (sqlc.narg('domain')::text IS NULL OR domain = sqlc.narg('domain')::text)
+  AND (sqlc.narg('image')::text IS NULL OR image LIKE sqlc.narg('image')::text || '%')
+  AND (sqlc.narg('owner')::text IS NULL OR owner = sqlc.narg('owner')::text)
+  AND (sqlc.narg('labels')::jsonb IS NULL OR labels @> sqlc.narg('labels')::jsonb)
+  AND (sqlc.narg('cursor_time')::timestamptz IS NULL
+       OR (created_at, id) > (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListAppsByCreatedAtDesc :many
+SELECT * FROM apps
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text)
+  AND (sqlc.narg('search')::text IS NULL
+       OR name ILIKE '%' || sqlc.narg('search')::text || '%'
+       OR slug ILIKE '%' || sqlc.narg('search')::text || '%')
+  AND (sqlc.narg('domain')::text IS NULL OR domain = sqlc.narg('domain')::text)
+  AND (sqlc.narg('image')::text IS NULL OR image LIKE sqlc.narg('image')::text || '%')
+  AND (sqlc.narg('owner')::text IS NULL OR owner = sqlc.narg('owner')::text)
+  AND (sqlc.narg('labels')::jsonb IS NULL OR labels @> sqlc.narg('labels')::jsonb)
+  AND (sqlc.narg('cursor_time')::timestamptz IS NULL
+       OR (created_at, id) < (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListAppsByUpdatedAtAsc :many
+SELECT * FROM apps
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text)
+  AND (sqlc.narg('search')::text IS NULL
+       OR name ILIKE '%' || sqlc.narg('search')::text || '%'
+       OR slug ILIKE '%' || sqlc.narg('search')::text || '%')
+  AND (sqlc.narg('domain')::text IS NULL OR domain = sqlc.narg('domain')::text)
+  AND (sqlc.narg('image')::text IS NULL OR image LIKE sqlc.narg('image')::text || '%')
+  AND (sqlc.narg('owner')::text IS NULL OR owner = sqlc.narg('owner')::text)
+  AND (sqlc.narg('labels')::jsonb IS NULL OR labels @> sqlc.narg('labels')::jsonb)
+  AND (sqlc.narg('cursor_time')::timestamptz IS NULL
+       OR (updated_at, id) > (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')::uuid))
+ORDER BY updated_at ASC, id ASC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListAppsByUpdatedAtDesc :many
+SELECT * FROM apps
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text)
+  AND (sqlc.narg('search')::text IS NULL
+       OR name ILIKE '%' || sqlc.narg('search')::text || '%'
+       OR slug ILIKE '%' || sqlc.narg('search')::text || '%')
+  AND (sqlc.narg('domain')::text IS NULL OR domain = sqlc.narg('domain')::text)
+  AND (sqlc.narg('image')::text IS NULL OR image LIKE sqlc.narg('image')::text || '%')
+  AND (sqlc.narg('owner')::text IS NULL OR owner = sqlc.narg('owner')::text)
+  AND (sqlc.narg('labels')::jsonb IS NULL OR labels @> sqlc.narg('labels')::jsonb)
+  AND (sqlc.narg('cursor_time')::timestamptz IS NULL
+       OR (updated_at, id) < (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')::uuid))
+ORDER BY updated_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListAppsByNameAsc :many
+SELECT * FROM apps
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text)
+  AND (sqlc.narg('search')::text IS NULL
+       OR name ILIKE '%' || sqlc.narg('search')::text || '%'
+       OR slug ILIKE '%' || sqlc.narg('search')::text || '%')
+  AND (sqlc.narg('domain')::text IS NULL OR domain = sqlc.narg('domain')::text)
+  AND (sqlc.narg('image')::text IS NULL OR image LIKE sqlc.narg('image')::text || '%')
+  AND (sqlc.narg('owner')::text IS NULL OR owner = sqlc.narg('owner')::text)
+  AND (sqlc.narg('labels')::jsonb IS NULL OR labels @> sqlc.narg('labels')::jsonb)
+  AND (sqlc.narg('cursor_key')::text IS NULL
+       OR (name, id) > (sqlc.narg('cursor_key')::text, sqlc.narg('cursor_id')::uuid))
+ORDER BY name ASC, id ASC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListAppsByNameDesc :many
+SELECT * FROM apps
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text)
+  AND (sqlc.narg('search')::text IS NULL
+       OR name ILIKE '%' || sqlc.narg('search')::text || '%'
+       OR slug ILIKE '%' || sqlc.narg('search')::text || '%')
+  AND (sqlc.narg('domain')::text IS NULL OR domain = sqlc.narg('domain')::text)
+  AND (sqlc.narg('image')::text IS NULL OR image LIKE sqlc.narg('image')::text || '%')
+  AND (sqlc.narg('owner')::text IS NULL OR owner = sqlc.narg('owner')::text)
+  AND (sqlc.narg('labels')::jsonb IS NULL OR labels @> sqlc.narg('labels')::jsonb)
+  AND (sqlc.narg('cursor_key')::text IS NULL
+       OR (name, id) < (sqlc.narg('cursor_key')::text, sqlc.narg('cursor_id')::uuid))
+ORDER BY name DESC, id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListAppsBySlugAsc :many
+SELECT * FROM apps
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text)
+  AND (sqlc.narg('search')::text IS NULL
+       OR name ILIKE '%' || sqlc.narg('search')::text || '%'
+       OR slug ILIKE '%' || sqlc.narg('search')::text || '%')
+  AND (sqlc.narg('domain')::text IS NULL OR domain = sqlc.narg('domain')::text)
+  AND (sqlc.narg('image')::text IS NULL OR image LIKE sqlc.narg('image')::text || '%')
+  AND (sqlc.narg('owner')::text IS NULL OR owner = sqlc.narg('owner')::text)
+  AND (sqlc.narg('labels')::jsonb IS NULL OR labels @> sqlc.narg('labels')::jsonb)
+  AND (sqlc.narg('cursor_key')::text IS NULL
+       OR (slug, id) > (sqlc.narg('cursor_key')::text, sqlc.narg('cursor_id')::uuid))
+ORDER BY slug ASC, id ASC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListAppsBySlugDesc :many
+SELECT * FROM apps
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text)
+  AND (sqlc.narg('search')::text IS NULL
+       OR name ILIKE '%' || sqlc.narg('search')::text || '%'
+       OR slug ILIKE '%' || sqlc.narg('search')::text || '%')
+  AND (sqlc.narg('domain')::text IS NULL OR domain = sqlc.narg('domain')::text)
+  AND (sqlc.narg('image')::text IS NULL OR image LIKE sqlc.narg('image')::text || '%')
+  AND (sqlc.narg('owner')::text IS NULL OR owner = sqlc.narg('owner')::text)
+  AND (sqlc.narg('labels')::jsonb IS NULL OR labels @> sqlc.narg('labels')::jsonb)
+  AND (sqlc.narg('cursor_key')::text IS NULL
+       OR (slug, id) < (sqlc.narg('cursor_key')::text, sqlc.narg('cursor_id')::uuid))
+ORDER BY slug DESC, id DESC
+LIMIT sqlc.arg('page_size');
 
 -- name: UpdateAppStatus :one
+-- status_reason says why a deploy failed, and is cleared by other statuses.
+-- The status is the server's to change, so it leaves the version callers
+-- send back in If-Match alone.
 UPDATE apps
 SET status = $2,
+    status_reason = $3,
     updated_at = NOW(),
     last_deployed_at = CASE WHEN $2 = 'running' THEN NOW() ELSE last_deployed_at END
 WHERE id = $1
 RETURNING *;
 
 -- name: UpdateApp :one
+-- Only applies when the app is still at the version the caller read, so
+-- concurrent edits cannot silently overwrite each other. Returns no rows
+-- otherwise.
 UPDATE apps
 SET name = COALESCE($2, name),
     image = COALESCE($3, image),
@@ -45,8 +193,64 @@ SET name = COALESCE($2, name),
     memory_limit = COALESCE($7, memory_limit),
     domain = COALESCE($8, domain),
     health_check_path = COALESCE($9, health_check_path),
+    owner = COALESCE($10, owner),
+    labels = COALESCE($11, labels),
+    env = COALESCE($12, env),
+    health_checks = COALESCE($13, health_checks),
+    ports = COALESCE($14, ports),
+    service_type = COALESCE($15, service_type),
+    visibility = COALESCE($16, visibility),
+    aliases = COALESCE($17, aliases),
+    allow_from = COALESCE($18, allow_from),
+    egress = COALESCE($19, egress),
+    egress_cidrs = COALESCE($20, egress_cidrs),
+    namespace = COALESCE($21, namespace),
+    cluster = COALESCE($22, cluster),
+    auto_rollback = COALESCE($23, auto_rollback),
+    cpu_request = COALESCE($24, cpu_request),
+    memory_request = COALESCE($25, memory_request),
+    ephemeral_storage_request = COALESCE($26, ephemeral_storage_request),
+    ephemeral_storage_limit = COALESCE($27, ephemeral_storage_limit),
+    size = COALESCE($28, size),
+    version = version + 1,
     updated_at = NOW()
-WHERE id = $1
+WHERE id = $1 AND version = $29
+RETURNING *;
+
+-- name: ReplaceApp :one
+-- Sets every configurable field at once, as applying a manifest does. Like
+-- UpdateApp it only applies at the expected version.
+UPDATE apps
+SET name = $2,
+    image = $3,
+    port = $4,
+    replicas = $5,
+    cpu_limit = $6,
+    memory_limit = $7,
+    domain = $8,
+    health_check_path = $9,
+    owner = $10,
+    labels = $11,
+    env = $12,
+    health_checks = $13,
+    ports = $14,
+    service_type = $15,
+    visibility = $16,
+    aliases = $17,
+    allow_from = $18,
+    egress = $19,
+    egress_cidrs = $20,
+    namespace = $21,
+    cluster = $22,
+    auto_rollback = $23,
+    cpu_request = $24,
+    memory_request = $25,
+    ephemeral_storage_request = $26,
+    ephemeral_storage_limit = $27,
+    size = $28,
+    version = version + 1,
+    updated_at = NOW()
+WHERE id = $1 AND version = $29
 RETURNING *;
 
 -- name: DeleteApp :exec
@@ -54,7 +258,18 @@ DELETE FROM apps
 WHERE id = $1;
 
 -- name: CheckSlugExists :one
-SELECT EXISTS(SELECT 1 FROM apps WHERE slug = $1);
+-- Slugs and aliases share the Service namespace, so a slug is also taken
+-- when another app uses it as an alias
+SELECT EXISTS(SELECT 1 FROM apps WHERE slug = $1 OR $1 = ANY(aliases));
+
+-- name: CheckAliasesTaken :one
+-- Reports whether any of the names is the slug or an alias of an app other
+-- than the given one
+SELECT EXISTS(
+    SELECT 1 FROM apps
+    WHERE id <> sqlc.arg('id')
+      AND (slug = ANY(sqlc.arg('names')::text[]) OR aliases && sqlc.arg('names')::text[])
+);
 
 -- name: CheckDomainExists :one
 SELECT EXISTS(SELECT 1 FROM apps WHERE domain = $1);
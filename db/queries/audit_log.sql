@@ -0,0 +1,3 @@
+-- name: CreateAuditLogEntry :exec
+INSERT INTO audit_log (app_id, app_slug, actor, action, data)
+VALUES ($1, $2, $3, $4, $5);
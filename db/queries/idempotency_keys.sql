@@ -0,0 +1,36 @@
+-- name: ClaimIdempotencyKey :execrows
+-- Claims a caller's key for a new request. An existing row is only taken over
+-- once it has expired, or when it is an abandoned in-progress claim (older
+-- than $5) for the same request. Returns 0 rows when the key is already taken.
+INSERT INTO idempotency_keys (principal, key, request_hash, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (principal, key) DO UPDATE
+SET request_hash = EXCLUDED.request_hash,
+    status_code = NULL,
+    response_headers = NULL,
+    response_body = NULL,
+    created_at = NOW(),
+    expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at < NOW()
+   OR (idempotency_keys.status_code IS NULL
+       AND idempotency_keys.created_at < $5
+       AND idempotency_keys.request_hash = EXCLUDED.request_hash);
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys
+WHERE principal = $1 AND key = $2 LIMIT 1;
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET status_code = $3,
+    response_headers = $4,
+    response_body = $5
+WHERE principal = $1 AND key = $2;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE principal = $1 AND key = $2;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at < NOW();
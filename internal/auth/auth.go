@@ -0,0 +1,154 @@
+// Package auth identifies the callers of the API by their bearer tokens and
+// the roles those tokens grant.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Role is what a caller may do. Each role may do everything the roles
+// before it may.
+type Role string
+
+const (
+	// RoleViewer may read apps, but not change them
+	RoleViewer Role = "viewer"
+	// RoleDeveloper may also change apps and open shells in their containers
+	RoleDeveloper Role = "developer"
+	// RoleAdmin may do everything
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer:    1,
+	RoleDeveloper: 2,
+	RoleAdmin:     3,
+}
+
+// ParseRole parses the name of a role
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown role %q, must be viewer, developer or admin", name)
+	}
+	return role, nil
+}
+
+// Allows reports whether the role may do what required may
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// Principal is the caller of a request
+type Principal struct {
+	Name string
+	Role Role
+}
+
+// Anonymous is the caller of every request when no tokens are configured,
+// which may do everything as the API always could
+var Anonymous = Principal{Name: "anonymous", Role: RoleAdmin}
+
+// System is the caller of what superfly does on its own, such as a GitOps
+// sync
+var System = Principal{Name: "system", Role: RoleAdmin}
+
+// TokenConfig is an entry of the tokens file
+type TokenConfig struct {
+	// Name identifies the caller in logs and the audit log
+	Name string `toml:"name"`
+	// TokenSHA256 is the hex SHA-256 of the token, so the file doesn't
+	// hold the tokens themselves
+	TokenSHA256 string `toml:"token_sha256"`
+	Role        string `toml:"role"`
+}
+
+// Tokens maps the hashes of bearer tokens to their callers
+type Tokens struct {
+	principals map[string]Principal
+}
+
+// LoadTokens reads a tokens file, a TOML file of [[token]] tables:
+//
+//	[[token]]
+//	name = "alice"
+//	token_sha256 = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+//	role = "developer"
+func LoadTokens(path string) (*Tokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %w", err)
+	}
+
+	var file struct {
+		Tokens []TokenConfig `toml:"token"`
+	}
+	meta, err := toml.Decode(string(data), &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tokens file: %w", err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, fmt.Errorf("unknown keys in tokens file: %s", strings.Join(keys, ", "))
+	}
+	return NewTokens(file.Tokens)
+}
+
+// NewTokens checks the entries of a tokens file
+func NewTokens(configs []TokenConfig) (*Tokens, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no tokens configured")
+	}
+
+	tokens := &Tokens{principals: make(map[string]Principal, len(configs))}
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, fmt.Errorf("token without a name")
+		}
+		hash := strings.ToLower(config.TokenSHA256)
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("token %s: token_sha256 must be a hex SHA-256", config.Name)
+		}
+		if _, ok := tokens.principals[hash]; ok {
+			return nil, fmt.Errorf("token %s: duplicate token", config.Name)
+		}
+		role, err := ParseRole(config.Role)
+		if err != nil {
+			return nil, fmt.Errorf("token %s: %w", config.Name, err)
+		}
+		tokens.principals[hash] = Principal{Name: config.Name, Role: role}
+	}
+	return tokens, nil
+}
+
+// Authenticate returns the caller a token belongs to
+func (t *Tokens) Authenticate(token string) (Principal, bool) {
+	hash := sha256.Sum256([]byte(token))
+	principal, ok := t.principals[hex.EncodeToString(hash[:])]
+	return principal, ok
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the caller of a request
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the caller of a request, or System outside of one
+func FromContext(ctx context.Context) Principal {
+	if principal, ok := ctx.Value(principalKey{}).(Principal); ok {
+		return principal
+	}
+	return System
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestParseRole(t *testing.T) {
+	for _, name := range []string{"viewer", "developer", "admin"} {
+		if role, err := ParseRole(name); err != nil || string(role) != name {
+			t.Errorf("ParseRole(%q) = %q, %v", name, role, err)
+		}
+	}
+	for _, name := range []string{"", "Admin", "owner"} {
+		if _, err := ParseRole(name); err == nil {
+			t.Errorf("ParseRole(%q) succeeded", name)
+		}
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		want     bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleDeveloper, false},
+		{RoleViewer, RoleAdmin, false},
+		{RoleDeveloper, RoleViewer, true},
+		{RoleDeveloper, RoleDeveloper, true},
+		{RoleDeveloper, RoleAdmin, false},
+		{RoleAdmin, RoleDeveloper, true},
+		{RoleAdmin, RoleAdmin, true},
+		{"", RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Allows(tt.required); got != tt.want {
+			t.Errorf("%q.Allows(%q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestNewTokens(t *testing.T) {
+	alice := TokenConfig{Name: "alice", TokenSHA256: hashToken("alice-token"), Role: "developer"}
+
+	tests := []struct {
+		name    string
+		configs []TokenConfig
+		wantErr string
+	}{
+		{name: "valid", configs: []TokenConfig{alice, {Name: "bob", TokenSHA256: strings.ToUpper(hashToken("bob-token")), Role: "viewer"}}},
+		{name: "empty", wantErr: "no tokens configured"},
+		{name: "without a name", configs: []TokenConfig{{TokenSHA256: hashToken("x"), Role: "admin"}}, wantErr: "token without a name"},
+		{name: "not hex", configs: []TokenConfig{{Name: "carol", TokenSHA256: "secret", Role: "admin"}}, wantErr: "token carol: token_sha256"},
+		{name: "wrong length", configs: []TokenConfig{{Name: "carol", TokenSHA256: "abcd", Role: "admin"}}, wantErr: "token carol: token_sha256"},
+		{name: "duplicate", configs: []TokenConfig{alice, {Name: "mallory", TokenSHA256: alice.TokenSHA256, Role: "admin"}}, wantErr: "token mallory: duplicate token"},
+		{name: "unknown role", configs: []TokenConfig{{Name: "carol", TokenSHA256: hashToken("x"), Role: "root"}}, wantErr: `token carol: unknown role "root"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := NewTokens(tt.configs)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tokens == nil {
+					t.Fatal("got no tokens")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tokens, err := NewTokens([]TokenConfig{
+		{Name: "alice", TokenSHA256: hashToken("alice-token"), Role: "developer"},
+		{Name: "bob", TokenSHA256: strings.ToUpper(hashToken("bob-token")), Role: "viewer"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		token string
+		want  Principal
+		ok    bool
+	}{
+		{token: "alice-token", want: Principal{Name: "alice", Role: RoleDeveloper}, ok: true},
+		{token: "bob-token", want: Principal{Name: "bob", Role: RoleViewer}, ok: true},
+		{token: "Alice-token"},
+		{token: hashToken("alice-token")},
+		{token: ""},
+	}
+	for _, tt := range tests {
+		got, ok := tokens.Authenticate(tt.token)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Authenticate(%q) = %+v, %v, want %+v, %v", tt.token, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "valid", content: "[[token]]\nname = \"alice\"\ntoken_sha256 = \"" + hashToken("alice-token") + "\"\nrole = \"admin\"\n"},
+		{name: "unknown key", content: "[[token]]\nname = \"alice\"\ntoken = \"alice-token\"\nrole = \"admin\"\n", wantErr: "unknown keys in tokens file: token.token"},
+		{name: "invalid", content: "[[token]\n", wantErr: "failed to parse tokens file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.toml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadTokens(path)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != System {
+		t.Errorf("got %+v outside of a request, want System", got)
+	}
+	alice := Principal{Name: "alice", Role: RoleDeveloper}
+	if got := FromContext(WithPrincipal(context.Background(), alice)); got != alice {
+		t.Errorf("got %+v, want %+v", got, alice)
+	}
+}
@@ -3,7 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,16 +18,64 @@ type Config struct {
 	APIPort string
 	APIHost string
 
-	// Kubernetes
+	// Kubernetes: KubernetesInCluster and Kubeconfig configure the only
+	// cluster, named DefaultCluster, unless ClustersFile lists several
 	KubernetesInCluster bool
 	Kubeconfig          string
+	ClustersFile        string
+	DefaultCluster      string
+
+	// Namespaces: apps without an owner are deployed to AppsNamespace, and
+	// those with one to TenantNamespacePrefix followed by the owner
+	AppsNamespace         string
+	TenantNamespacePrefix string
+
+	// Quota of each apps namespace; empty or zero values are unlimited
+	NamespaceCPUQuota      string
+	NamespaceMemoryQuota   string
+	NamespacePodQuota      int64
+	NamespaceDefaultCPU    string
+	NamespaceDefaultMemory string
+
+	// Machine sizes apps pick from, read from SizesFile; DefaultSize is the
+	// size of apps that pick none, the first of the file when empty.
+	// Without SizesFile anyone may set the resources of apps.
+	SizesFile   string
+	DefaultSize string
 
 	// Registry
 	RegistryURL string
 
+	// Authentication: callers need a token from TokensFile unless it is
+	// empty, the role ExecRole to open shells in containers and the role
+	// PortForwardRole to forward ports of pods
+	TokensFile      string
+	ExecRole        string
+	PortForwardRole string
+
+	// How long responses to requests with an Idempotency-Key are kept
+	IdempotencyKeyTTL time.Duration
+
+	// How long the events of apps are kept for streams to resume from
+	EventRetention time.Duration
+
+	// GitOps sync, disabled when GitOpsRepoURL is empty. Pruning deletes
+	// apps, so it is off unless GitOpsPrune is set.
+	GitOpsRepoURL  string
+	GitOpsBranch   string
+	GitOpsPath     string
+	GitOpsDir      string
+	GitOpsInterval time.Duration
+	GitOpsPrune    bool
+
 	// Environment
 	Environment string
 	LogLevel    string
+
+	// Tracing (OTLP/HTTP); tracing is disabled when the endpoint is empty
+	OTELExporterEndpoint string
+	OTELServiceName      string
+	OTELSampleRatio      float64
 }
 
 func Load() (*Config, error) {
@@ -38,9 +88,37 @@ func Load() (*Config, error) {
 		APIHost:             getEnv("API_HOST", "0.0.0.0"),
 		KubernetesInCluster: getEnvBool("KUBERNETES_IN_CLUSTER", false),
 		Kubeconfig:          getEnv("KUBECONFIG", ""),
-		RegistryURL:         getEnv("REGISTRY_URL", "registry.superfly-system.svc.cluster.local:5000"),
-		Environment:         getEnv("ENV", "development"),
-		LogLevel:            getEnv("LOG_LEVEL", "info"),
+		ClustersFile:        getEnv("CLUSTERS_FILE", ""),
+		DefaultCluster:      getEnv("DEFAULT_CLUSTER", "default"),
+
+		AppsNamespace:          getEnv("APPS_NAMESPACE", "superfly-apps"),
+		TenantNamespacePrefix:  getEnv("TENANT_NAMESPACE_PREFIX", "superfly-"),
+		NamespaceCPUQuota:      getEnv("NAMESPACE_CPU_QUOTA", ""),
+		NamespaceMemoryQuota:   getEnv("NAMESPACE_MEMORY_QUOTA", ""),
+		NamespacePodQuota:      getEnvInt("NAMESPACE_POD_QUOTA", 0),
+		NamespaceDefaultCPU:    getEnv("NAMESPACE_DEFAULT_CPU", "500m"),
+		NamespaceDefaultMemory: getEnv("NAMESPACE_DEFAULT_MEMORY", "256Mi"),
+		SizesFile:              getEnv("SIZES_FILE", ""),
+		DefaultSize:            getEnv("DEFAULT_SIZE", ""),
+
+		RegistryURL:       getEnv("REGISTRY_URL", "registry.superfly-system.svc.cluster.local:5000"),
+		TokensFile:        getEnv("TOKENS_FILE", ""),
+		ExecRole:          getEnv("EXEC_ROLE", "developer"),
+		PortForwardRole:   getEnv("PORT_FORWARD_ROLE", "developer"),
+		IdempotencyKeyTTL: getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		EventRetention:    getEnvDuration("EVENT_RETENTION", 7*24*time.Hour),
+		GitOpsRepoURL:     getEnv("GITOPS_REPO_URL", ""),
+		GitOpsBranch:      getEnv("GITOPS_BRANCH", "main"),
+		GitOpsPath:        getEnv("GITOPS_PATH", "."),
+		GitOpsDir:         getEnv("GITOPS_DIR", filepath.Join(os.TempDir(), "superfly-gitops")),
+		GitOpsInterval:    getEnvDuration("GITOPS_INTERVAL", time.Minute),
+		GitOpsPrune:       getEnvBool("GITOPS_PRUNE", false),
+		Environment:       getEnv("ENV", "development"),
+		LogLevel:          getEnv("LOG_LEVEL", "info"),
+
+		OTELExporterEndpoint: getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		OTELServiceName:      getEnv("OTEL_SERVICE_NAME", "superfly-api"),
+		OTELSampleRatio:      getEnvFloat("OTEL_TRACES_SAMPLER_ARG", 1.0),
 	}
 
 	// Validate required fields
@@ -68,3 +146,36 @@ func getEnvBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		intVal, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return defaultValue
+		}
+		return intVal
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		floatVal, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return defaultValue
+		}
+		return floatVal
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return defaultValue
+		}
+		return duration
+	}
+	return defaultValue
+}
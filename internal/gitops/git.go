@@ -0,0 +1,80 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// checkout is a shallow working copy of one branch of a repository, kept up
+// to date with the git CLI. Any URL git understands works, including a path
+// to a local bare repository.
+type checkout struct {
+	url    string
+	branch string
+	dir    string
+}
+
+// update clones the repository on first use and afterwards fetches the
+// branch and resets the working copy to it, discarding anything else in the
+// directory. It returns the checked out commit.
+func (c *checkout) update(ctx context.Context) (string, error) {
+	if _, err := os.Stat(filepath.Join(c.dir, ".git")); os.IsNotExist(err) {
+		// Clear out whatever an interrupted clone left behind
+		if err := os.RemoveAll(c.dir); err != nil {
+			return "", fmt.Errorf("failed to clear checkout directory: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(c.dir), 0o755); err != nil {
+			return "", fmt.Errorf("failed to create checkout directory: %w", err)
+		}
+		if _, err := git(ctx, "", "clone", "--quiet", "--depth", "1", "--branch", c.branch, "--", c.url, c.dir); err != nil {
+			return "", err
+		}
+	} else {
+		if _, err := git(ctx, c.dir, "remote", "set-url", "origin", c.url); err != nil {
+			return "", err
+		}
+		if _, err := git(ctx, c.dir, "fetch", "--quiet", "--depth", "1", "origin", c.branch); err != nil {
+			return "", err
+		}
+		if _, err := git(ctx, c.dir, "reset", "--quiet", "--hard", "FETCH_HEAD"); err != nil {
+			return "", err
+		}
+		if _, err := git(ctx, c.dir, "clean", "--quiet", "-ffdx"); err != nil {
+			return "", err
+		}
+	}
+
+	return git(ctx, c.dir, "rev-parse", "HEAD")
+}
+
+// git runs a git command in dir and returns its trimmed stdout
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	// Never wait for credentials on a terminal that isn't there
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// git may repeat the repository URL, with its credentials, in its
+		// messages, which end up in the sync status
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, redactURLs(strings.TrimSpace(stderr.String())))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// urlPattern matches the URLs in git's messages, which quotes them
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s'"]+`)
+
+// redactURLs hides the passwords of the URLs in a message
+func redactURLs(message string) string {
+	return urlPattern.ReplaceAllStringFunc(message, redactURL)
+}
@@ -0,0 +1,416 @@
+// Package gitops keeps apps in sync with the superfly.toml manifests in a git
+// repository.
+package gitops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superfly/superfly/internal/manifest"
+	"github.com/superfly/superfly/internal/service"
+)
+
+const (
+	// ManagedLabel marks apps applied by sync. Only apps carrying it are
+	// pruned when their manifest disappears from the repository.
+	ManagedLabel = "superfly.dev/managed-by"
+	managedValue = "gitops"
+
+	// syncTimeout bounds a single sync, including the git fetch
+	syncTimeout = 5 * time.Minute
+	// defaultInterval is used when Options.Interval is not positive
+	defaultInterval = time.Minute
+
+	manifestFile   = "superfly.toml"
+	manifestSuffix = ".superfly.toml"
+	prunePageSize  = 100
+)
+
+// Sync states
+const (
+	StateDisabled  = "disabled"
+	StatePending   = "pending"
+	StateSyncing   = "syncing"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+// ActionPrune is the action reported for apps deleted because their
+// manifest was removed
+const ActionPrune = "prune"
+
+// Options configure a Syncer. Sync is disabled when RepoURL is empty.
+type Options struct {
+	RepoURL  string
+	Branch   string
+	Path     string // directory within the repository to read manifests from
+	Dir      string // where the working copy is kept
+	Interval time.Duration
+	Prune    bool
+}
+
+// Apps is the part of service.AppService sync drives
+type Apps interface {
+	Apply(ctx context.Context, m *manifest.Manifest, dryRun bool) (*service.ApplyResult, error)
+	ListApps(ctx context.Context, input service.ListAppsInput) (*service.ListAppsResult, error)
+	DeleteApp(ctx context.Context, id uuid.UUID) error
+}
+
+// AppStatus is the outcome of syncing one manifest or pruning one app
+type AppStatus struct {
+	Path   string `json:"path,omitempty"`
+	Slug   string `json:"slug,omitempty"`
+	Action string `json:"action,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Status reports the last sync. State is failed when the repository could
+// not be read or any app failed to sync; the per-app errors are in Apps.
+type Status struct {
+	State         string      `json:"state"`
+	Repository    string      `json:"repository,omitempty"`
+	Branch        string      `json:"branch,omitempty"`
+	Commit        string      `json:"commit,omitempty"`
+	LastSyncAt    *time.Time  `json:"last_sync_at"`
+	LastSuccessAt *time.Time  `json:"last_success_at"`
+	Error         string      `json:"error,omitempty"`
+	Apps          []AppStatus `json:"apps"`
+}
+
+// Syncer periodically makes apps match the manifests in a git repository:
+// manifests are applied like POST /api/apply, and with Prune, synced apps
+// whose manifest was removed are deleted.
+type Syncer struct {
+	opts     Options
+	apps     Apps
+	logger   *slog.Logger
+	checkout *checkout
+	trigger  chan struct{}
+
+	mu     sync.Mutex
+	status Status
+}
+
+func NewSyncer(opts Options, apps Apps, logger *slog.Logger) *Syncer {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+
+	s := &Syncer{
+		opts:     opts,
+		apps:     apps,
+		logger:   logger.With("component", "gitops"),
+		checkout: &checkout{url: opts.RepoURL, branch: opts.Branch, dir: opts.Dir},
+		trigger:  make(chan struct{}, 1),
+		status:   Status{State: StateDisabled, Apps: []AppStatus{}},
+	}
+	if s.Enabled() {
+		s.status.State = StatePending
+		s.status.Repository = redactURL(opts.RepoURL)
+		s.status.Branch = opts.Branch
+	}
+	return s
+}
+
+// Enabled reports whether a repository is configured
+func (s *Syncer) Enabled() bool {
+	return s.opts.RepoURL != ""
+}
+
+// Run syncs immediately, then every interval and whenever triggered, until
+// ctx is cancelled. It returns at once when sync is disabled.
+func (s *Syncer) Run(ctx context.Context) {
+	if !s.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.sync(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.trigger:
+		}
+	}
+}
+
+// Trigger asks Run to sync as soon as the current sync, if any, finishes.
+// It returns false when sync is disabled.
+func (s *Syncer) Trigger() bool {
+	if !s.Enabled() {
+		return false
+	}
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+		// A sync is already queued
+	}
+	return true
+}
+
+// Status returns the status of the last sync
+func (s *Syncer) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	status.Apps = append([]AppStatus{}, s.status.Apps...)
+	return status
+}
+
+func (s *Syncer) sync(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+
+	s.mu.Lock()
+	s.status.State = StateSyncing
+	s.mu.Unlock()
+
+	started := time.Now()
+	commit, err := s.checkout.update(ctx)
+	if err != nil {
+		s.logger.Error("failed to fetch repository", "error", err)
+		s.finish(started, "", err, nil)
+		return
+	}
+
+	results, manifests := s.load()
+
+	// A manifest that failed to parse may belong to a synced app, so pruning
+	// could delete an app that is still in the repository
+	complete := true
+	for _, result := range results {
+		if result.Error != "" {
+			complete = false
+		}
+	}
+
+	keep := make(map[string]bool, len(manifests))
+	for _, m := range manifests {
+		keep[m.manifest.Slug] = true
+
+		result := &results[m.index]
+		applied, err := s.apps.Apply(ctx, m.manifest, false)
+		if err != nil {
+			result.Error = describe(err)
+			continue
+		}
+		result.Action = applied.Plan.Action
+	}
+
+	if s.opts.Prune {
+		if complete {
+			results = append(results, s.prune(ctx, keep)...)
+		} else {
+			s.logger.Warn("skipping prune because some manifests could not be read")
+		}
+	}
+
+	s.finish(started, commit, nil, results)
+}
+
+// loadedManifest is a parsed manifest and the index of its result
+type loadedManifest struct {
+	manifest *manifest.Manifest
+	index    int
+}
+
+// load parses every manifest in the working copy. It returns a result per
+// manifest file, with read and parse errors filled in, and the manifests that
+// parsed.
+func (s *Syncer) load() ([]AppStatus, []loadedManifest) {
+	results := []AppStatus{}
+	var manifests []loadedManifest
+	seen := make(map[string]string)
+
+	// Symlinks in the repository are resolved, so paths are compared with
+	// the resolved working copy
+	base, err := filepath.EvalSymlinks(s.opts.Dir)
+	if err != nil {
+		return append(results, AppStatus{Path: s.opts.Path, Error: "failed to read manifests: " + err.Error()}), nil
+	}
+	root, err := resolveWithin(base, filepath.Join(base, s.opts.Path))
+	if err != nil {
+		return append(results, AppStatus{Path: s.opts.Path, Error: "failed to read manifests: " + err.Error()}), nil
+	}
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Name() != manifestFile && !strings.HasSuffix(entry.Name(), manifestSuffix) {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(base, path)
+		result := AppStatus{Path: filepath.ToSlash(rel)}
+
+		data, err := readWithin(base, path)
+		if err != nil {
+			result.Error = "failed to read manifest: " + err.Error()
+			results = append(results, result)
+			return nil
+		}
+		m, err := manifest.Parse(data)
+		switch {
+		case err != nil:
+			result.Error = "invalid manifest: " + err.Error()
+		case seen[m.Slug] != "":
+			result.Slug = m.Slug
+			result.Error = fmt.Sprintf("slug %q is also defined in %s", m.Slug, seen[m.Slug])
+		default:
+			result.Slug = m.Slug
+			seen[m.Slug] = result.Path
+
+			if m.Labels == nil {
+				m.Labels = make(map[string]string)
+			}
+			m.Labels[ManagedLabel] = managedValue
+			manifests = append(manifests, loadedManifest{manifest: m, index: len(results)})
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		results = append(results, AppStatus{Path: s.opts.Path, Error: "failed to read manifests: " + err.Error()})
+	}
+
+	return results, manifests
+}
+
+// readWithin reads a file of the working copy at base. A manifest may be a
+// symlink within the repository, but not to a file elsewhere on the server.
+func readWithin(base, path string) ([]byte, error) {
+	resolved, err := resolveWithin(base, path)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(resolved)
+}
+
+// resolveWithin resolves the symlinks of path, which must stay within base
+func resolveWithin(base, path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved != base && !strings.HasPrefix(resolved, base+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s resolves outside the repository", filepath.Base(path))
+	}
+	return resolved, nil
+}
+
+// prune deletes synced apps whose slug is not in keep
+func (s *Syncer) prune(ctx context.Context, keep map[string]bool) []AppStatus {
+	var results []AppStatus
+	input := service.ListAppsInput{
+		Labels: map[string]string{ManagedLabel: managedValue},
+		Sort:   service.SortSlug,
+		Limit:  prunePageSize,
+	}
+	for {
+		page, err := s.apps.ListApps(ctx, input)
+		if err != nil {
+			return append(results, AppStatus{Action: ActionPrune, Error: "failed to list synced apps: " + err.Error()})
+		}
+
+		for _, app := range page.Apps {
+			if keep[app.Slug] {
+				continue
+			}
+			result := AppStatus{Slug: app.Slug, Action: ActionPrune}
+			if err := s.apps.DeleteApp(ctx, app.ID); err != nil {
+				result.Error = describe(err)
+			}
+			results = append(results, result)
+		}
+
+		if page.NextCursor == "" {
+			return results
+		}
+		input.Cursor = page.NextCursor
+	}
+}
+
+// finish records the outcome of a sync
+func (s *Syncer) finish(started time.Time, commit string, err error, results []AppStatus) {
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.LastSyncAt = &started
+	switch {
+	case err != nil:
+		s.status.State = StateFailed
+		s.status.Error = err.Error()
+		// Keep the previous commit and app results; nothing was applied
+		return
+	case failed > 0:
+		s.status.State = StateFailed
+		s.status.Error = fmt.Sprintf("%d of %d apps failed to sync", failed, len(results))
+	default:
+		s.status.State = StateSucceeded
+		s.status.Error = ""
+		s.status.LastSuccessAt = &started
+	}
+	s.status.Commit = commit
+	s.status.Apps = results
+
+	s.logger.Info("sync finished",
+		"commit", commit,
+		"apps", len(results),
+		"failed", failed,
+		"duration", time.Since(started),
+	)
+}
+
+// describe renders an error for the status, spelling out which fields of a
+// manifest failed validation
+func describe(err error) string {
+	var svcErr *service.Error
+	if !errors.As(err, &svcErr) || len(svcErr.Fields) == 0 {
+		return err.Error()
+	}
+
+	problems := make([]string, len(svcErr.Fields))
+	for i, field := range svcErr.Fields {
+		problems[i] = field.Field + " " + field.Message
+	}
+	return "validation failed: " + strings.Join(problems, "; ")
+}
+
+// redactURL hides any password in a repository URL
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/superfly/superfly/internal/db"
+)
+
+type appContextKey struct{}
+
+// AppCtx loads the app named by the {id} URL parameter, which may be its
+// UUID or its slug, and stores it on the request context for the handlers
+// below it. Unknown apps get a 404 before any handler runs.
+func (h *AppHandlers) AppCtx(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		app, err := h.appService.ResolveApp(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondServiceError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), appContextKey{}, app)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// appFromContext returns the app loaded by AppCtx
+func appFromContext(ctx context.Context) *db.App {
+	return ctx.Value(appContextKey{}).(*db.App)
+}
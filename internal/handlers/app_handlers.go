@@ -3,9 +3,10 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/google/uuid"
+	"github.com/superfly/superfly/internal/db"
 	"github.com/superfly/superfly/internal/service"
 )
 
@@ -21,140 +22,297 @@ func NewAppHandlers(appService *service.AppService) *AppHandlers {
 
 // CreateAppRequest represents the request body for creating an app
 type CreateAppRequest struct {
-	Name            string `json:"name"`
-	Slug            string `json:"slug,omitempty"`
-	Image           string `json:"image"`
-	Port            int32  `json:"port,omitempty"`
-	Replicas        int32  `json:"replicas,omitempty"`
-	CPULimit        string `json:"cpu_limit,omitempty"`
-	MemoryLimit     string `json:"memory_limit,omitempty"`
-	Domain          string `json:"domain,omitempty"`
-	HealthCheckPath string `json:"health_check_path,omitempty"`
+	Name            string               `json:"name" openapi:"minLength=1,maxLength=255"`
+	Slug            string               `json:"slug,omitempty" openapi:"maxLength=63,pattern=^([a-z0-9]([-a-z0-9]*[a-z0-9])?)?$"`
+	Image           string               `json:"image" openapi:"minLength=1"`
+	Port            int32                `json:"port,omitempty" openapi:"minimum=1,maximum=65535"`
+	Replicas        int32                `json:"replicas,omitempty" openapi:"minimum=0"`
+	CPULimit        string               `json:"cpu_limit,omitempty" openapi:"maxLength=10"`
+	MemoryLimit     string               `json:"memory_limit,omitempty" openapi:"maxLength=10"`
+	Domain          string               `json:"domain,omitempty" openapi:"maxLength=255"`
+	HealthCheckPath string               `json:"health_check_path,omitempty" openapi:"maxLength=255,pattern=^/"`
+	Owner           string               `json:"owner,omitempty" openapi:"maxLength=255"`
+	Labels          map[string]string    `json:"labels,omitempty"`
+	Env             []service.EnvVar     `json:"env,omitempty"`
+	HealthChecks    service.HealthChecks `json:"health_checks,omitempty"`
+	Ports           []service.Port       `json:"ports,omitempty"`
+	ServiceType     string               `json:"service_type,omitempty" openapi:"enum=cluster_ip|node_port|load_balancer"`
+	Visibility      string               `json:"visibility,omitempty" openapi:"enum=public|internal|none"`
+	Aliases         []string             `json:"aliases,omitempty"`
+	AllowFrom       []string             `json:"allow_from,omitempty"`
+	Egress          string               `json:"egress,omitempty" openapi:"enum=allow_all|deny_internet|allowlist"`
+	EgressCIDRs     []string             `json:"egress_cidrs,omitempty"`
+	Namespace       string               `json:"namespace,omitempty" openapi:"maxLength=63"`
+	Cluster         string               `json:"cluster,omitempty" openapi:"maxLength=63"`
+	AutoRollback    bool                 `json:"auto_rollback,omitempty"`
+
+	// Requests left out are half their limits
+	CPURequest              string `json:"cpu_request,omitempty" openapi:"maxLength=10"`
+	MemoryRequest           string `json:"memory_request,omitempty" openapi:"maxLength=10"`
+	EphemeralStorageRequest string `json:"ephemeral_storage_request,omitempty" openapi:"maxLength=10"`
+	EphemeralStorageLimit   string `json:"ephemeral_storage_limit,omitempty" openapi:"maxLength=10"`
+
+	// Size is a size of GET /api/sizes, instead of the resources above
+	Size string `json:"size,omitempty" openapi:"maxLength=63"`
 }
 
 // UpdateAppRequest represents the request body for updating an app
 type UpdateAppRequest struct {
-	Name            *string `json:"name,omitempty"`
-	Image           *string `json:"image,omitempty"`
-	Port            *int32  `json:"port,omitempty"`
-	Replicas        *int32  `json:"replicas,omitempty"`
-	CPULimit        *string `json:"cpu_limit,omitempty"`
-	MemoryLimit     *string `json:"memory_limit,omitempty"`
-	Domain          *string `json:"domain,omitempty"`
-	HealthCheckPath *string `json:"health_check_path,omitempty"`
+	Name            *string               `json:"name,omitempty" openapi:"minLength=1,maxLength=255"`
+	Image           *string               `json:"image,omitempty" openapi:"minLength=1"`
+	Port            *int32                `json:"port,omitempty" openapi:"minimum=1,maximum=65535"`
+	Replicas        *int32                `json:"replicas,omitempty" openapi:"minimum=0"`
+	CPULimit        *string               `json:"cpu_limit,omitempty" openapi:"maxLength=10"`
+	MemoryLimit     *string               `json:"memory_limit,omitempty" openapi:"maxLength=10"`
+	Domain          *string               `json:"domain,omitempty" openapi:"maxLength=255"`
+	HealthCheckPath *string               `json:"health_check_path,omitempty" openapi:"maxLength=255,pattern=^/"`
+	Owner           *string               `json:"owner,omitempty" openapi:"maxLength=255"`
+	Labels          map[string]string     `json:"labels,omitempty"`
+	Env             []service.EnvVar      `json:"env,omitempty"`
+	HealthChecks    *service.HealthChecks `json:"health_checks,omitempty"`
+	Ports           []service.Port        `json:"ports,omitempty"`
+	ServiceType     *string               `json:"service_type,omitempty" openapi:"enum=cluster_ip|node_port|load_balancer"`
+	Visibility      *string               `json:"visibility,omitempty" openapi:"enum=public|internal|none"`
+	Aliases         []string              `json:"aliases,omitempty"`
+	AllowFrom       []string              `json:"allow_from,omitempty"`
+	Egress          *string               `json:"egress,omitempty" openapi:"enum=allow_all|deny_internet|allowlist"`
+	EgressCIDRs     []string              `json:"egress_cidrs,omitempty"`
+	Namespace       *string               `json:"namespace,omitempty" openapi:"maxLength=63"`
+	Cluster         *string               `json:"cluster,omitempty" openapi:"maxLength=63"`
+	AutoRollback    *bool                 `json:"auto_rollback,omitempty"`
+
+	// An empty request is half its limit
+	CPURequest              *string `json:"cpu_request,omitempty" openapi:"maxLength=10"`
+	MemoryRequest           *string `json:"memory_request,omitempty" openapi:"maxLength=10"`
+	EphemeralStorageRequest *string `json:"ephemeral_storage_request,omitempty" openapi:"maxLength=10"`
+	EphemeralStorageLimit   *string `json:"ephemeral_storage_limit,omitempty" openapi:"maxLength=10"`
+
+	// An empty size switches the app back to the resources above
+	Size *string `json:"size,omitempty" openapi:"maxLength=63"`
+}
+
+// AppResponse is an app as the API returns it. InternalDNS is null when the
+// app's visibility is none.
+type AppResponse struct {
+	db.App
+	InternalDNS *service.InternalDNS `json:"internal_dns"`
+}
+
+func newAppResponse(app *db.App) *AppResponse {
+	return &AppResponse{App: *app, InternalDNS: service.AppInternalDNS(app)}
+}
+
+// ListAppsResponse is one page of apps. NextCursor is null on the last page.
+type ListAppsResponse struct {
+	Apps       []AppResponse `json:"apps"`
+	NextCursor *string       `json:"next_cursor"`
 }
 
 // CreateApp handles POST /api/apps
 func (h *AppHandlers) CreateApp(w http.ResponseWriter, r *http.Request) {
 	var req CreateAppRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+		respondError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
 		return
 	}
 
 	// Validate required fields
 	if req.Name == "" {
-		respondError(w, http.StatusBadRequest, "name is required")
+		respondError(w, http.StatusBadRequest, service.CodeValidationFailed, "name is required")
 		return
 	}
 	if req.Image == "" {
-		respondError(w, http.StatusBadRequest, "image is required")
+		respondError(w, http.StatusBadRequest, service.CodeValidationFailed, "image is required")
 		return
 	}
 
 	// Create app
 	app, err := h.appService.CreateApp(r.Context(), service.CreateAppInput{
-		Name:            req.Name,
-		Slug:            req.Slug,
-		Image:           req.Image,
-		Port:            req.Port,
-		Replicas:        req.Replicas,
-		CPULimit:        req.CPULimit,
-		MemoryLimit:     req.MemoryLimit,
-		Domain:          req.Domain,
-		HealthCheckPath: req.HealthCheckPath,
+		Name:                    req.Name,
+		Slug:                    req.Slug,
+		Image:                   req.Image,
+		Port:                    req.Port,
+		Replicas:                req.Replicas,
+		CPULimit:                req.CPULimit,
+		MemoryLimit:             req.MemoryLimit,
+		Domain:                  req.Domain,
+		HealthCheckPath:         req.HealthCheckPath,
+		Owner:                   req.Owner,
+		Labels:                  req.Labels,
+		Env:                     req.Env,
+		HealthChecks:            req.HealthChecks,
+		Ports:                   req.Ports,
+		ServiceType:             req.ServiceType,
+		Visibility:              req.Visibility,
+		Aliases:                 req.Aliases,
+		AllowFrom:               req.AllowFrom,
+		Egress:                  req.Egress,
+		EgressCIDRs:             req.EgressCIDRs,
+		Namespace:               req.Namespace,
+		Cluster:                 req.Cluster,
+		AutoRollback:            req.AutoRollback,
+		CPURequest:              req.CPURequest,
+		MemoryRequest:           req.MemoryRequest,
+		EphemeralStorageRequest: req.EphemeralStorageRequest,
+		EphemeralStorageLimit:   req.EphemeralStorageLimit,
+		Size:                    req.Size,
 	})
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondServiceError(w, r, err)
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, app)
+	w.Header().Set("ETag", appETag(app))
+	respondJSON(w, http.StatusCreated, newAppResponse(app))
 }
 
 // ListApps handles GET /api/apps
 func (h *AppHandlers) ListApps(w http.ResponseWriter, r *http.Request) {
-	apps, err := h.appService.ListApps(r.Context())
+	query := r.URL.Query()
+	var fields []service.FieldError
+
+	input := service.ListAppsInput{
+		Status: query.Get("status"),
+		Search: query.Get("q"),
+		Domain: query.Get("domain"),
+		Image:  query.Get("image"),
+		Owner:  query.Get("owner"),
+		Sort:   query.Get("sort"),
+		Cursor: query.Get("cursor"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 32)
+		if err != nil {
+			fields = append(fields, service.FieldError{Field: "limit", Message: "must be an integer"})
+		}
+		input.Limit = int32(n)
+	}
+
+	// Timestamps sort newest first and names alphabetically unless overridden
+	switch order := query.Get("order"); order {
+	case "":
+		input.Descending = input.Sort == "" || input.Sort == service.SortCreatedAt || input.Sort == service.SortUpdatedAt
+	case "asc", "desc":
+		input.Descending = order == "desc"
+	default:
+		fields = append(fields, service.FieldError{Field: "order", Message: "must be asc or desc"})
+	}
+
+	for _, label := range query["label"] {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			fields = append(fields, service.FieldError{Field: "label", Message: "must be in key=value form"})
+			continue
+		}
+		if input.Labels == nil {
+			input.Labels = make(map[string]string)
+		}
+		input.Labels[key] = value
+	}
+
+	if len(fields) > 0 {
+		respondFieldErrors(w, fields)
+		return
+	}
+
+	result, err := h.appService.ListApps(r.Context(), input)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondServiceError(w, r, err)
 		return
 	}
 
-	respondJSON(w, http.StatusOK, apps)
+	response := ListAppsResponse{Apps: make([]AppResponse, 0, len(result.Apps))}
+	for i := range result.Apps {
+		response.Apps = append(response.Apps, *newAppResponse(&result.Apps[i]))
+	}
+	if result.NextCursor != "" {
+		response.NextCursor = &result.NextCursor
+	}
+	respondJSON(w, http.StatusOK, response)
 }
 
 // GetApp handles GET /api/apps/:id
 func (h *AppHandlers) GetApp(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "id")
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid app ID")
-		return
-	}
+	app := appFromContext(r.Context())
 
-	app, err := h.appService.GetApp(r.Context(), id)
-	if err != nil {
-		respondError(w, http.StatusNotFound, "App not found")
+	etag := appETag(app)
+	w.Header().Set("ETag", etag)
+	if noneMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	respondJSON(w, http.StatusOK, app)
+	respondJSON(w, http.StatusOK, newAppResponse(app))
 }
 
 // UpdateApp handles PATCH /api/apps/:id
 func (h *AppHandlers) UpdateApp(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "id")
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid app ID")
+	current := appFromContext(r.Context())
+
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if !ifMatch(header, appETag(current)) {
+		respondError(w, http.StatusPreconditionFailed, service.CodePreconditionFailed, "If-Match does not match the app's current ETag")
 		return
 	}
+	// The update then only applies at the version the tag was issued for,
+	// so an edit made since the app was read still fails it
+	var expectedVersions []int64
+	if header != "" && header != "*" {
+		expectedVersions = []int64{current.Version}
+	}
 
 	var req UpdateAppRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+		respondError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
 		return
 	}
 
-	app, err := h.appService.UpdateApp(r.Context(), id, service.UpdateAppInput{
-		Name:            req.Name,
-		Image:           req.Image,
-		Port:            req.Port,
-		Replicas:        req.Replicas,
-		CPULimit:        req.CPULimit,
-		MemoryLimit:     req.MemoryLimit,
-		Domain:          req.Domain,
-		HealthCheckPath: req.HealthCheckPath,
+	app, err := h.appService.UpdateApp(r.Context(), current.ID, service.UpdateAppInput{
+		Name:                    req.Name,
+		Image:                   req.Image,
+		Port:                    req.Port,
+		Replicas:                req.Replicas,
+		CPULimit:                req.CPULimit,
+		MemoryLimit:             req.MemoryLimit,
+		Domain:                  req.Domain,
+		HealthCheckPath:         req.HealthCheckPath,
+		Owner:                   req.Owner,
+		Labels:                  req.Labels,
+		Env:                     req.Env,
+		HealthChecks:            req.HealthChecks,
+		Ports:                   req.Ports,
+		ServiceType:             req.ServiceType,
+		Visibility:              req.Visibility,
+		Aliases:                 req.Aliases,
+		AllowFrom:               req.AllowFrom,
+		Egress:                  req.Egress,
+		EgressCIDRs:             req.EgressCIDRs,
+		Namespace:               req.Namespace,
+		Cluster:                 req.Cluster,
+		AutoRollback:            req.AutoRollback,
+		CPURequest:              req.CPURequest,
+		MemoryRequest:           req.MemoryRequest,
+		EphemeralStorageRequest: req.EphemeralStorageRequest,
+		EphemeralStorageLimit:   req.EphemeralStorageLimit,
+		Size:                    req.Size,
+		IfMatch:                 expectedVersions,
 	})
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondServiceError(w, r, err)
 		return
 	}
 
-	respondJSON(w, http.StatusOK, app)
+	w.Header().Set("ETag", appETag(app))
+	respondJSON(w, http.StatusOK, newAppResponse(app))
 }
 
 // DeleteApp handles DELETE /api/apps/:id
 func (h *AppHandlers) DeleteApp(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "id")
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid app ID")
-		return
-	}
+	app := appFromContext(r.Context())
 
-	if err := h.appService.DeleteApp(r.Context(), id); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	if err := h.appService.DeleteApp(r.Context(), app.ID); err != nil {
+		respondServiceError(w, r, err)
 		return
 	}
 
@@ -163,15 +321,10 @@ func (h *AppHandlers) DeleteApp(w http.ResponseWriter, r *http.Request) {
 
 // RestartApp handles POST /api/apps/:id/restart
 func (h *AppHandlers) RestartApp(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "id")
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid app ID")
-		return
-	}
+	app := appFromContext(r.Context())
 
-	if err := h.appService.RestartApp(r.Context(), id); err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	if err := h.appService.RestartApp(r.Context(), app.ID); err != nil {
+		respondServiceError(w, r, err)
 		return
 	}
 
@@ -182,16 +335,6 @@ func (h *AppHandlers) RestartApp(w http.ResponseWriter, r *http.Request) {
 
 // Helper functions
 
-type errorResponse struct {
-	Error string `json:"error"`
-}
-
-func respondError(w http.ResponseWriter, statusCode int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(errorResponse{Error: message})
-}
-
 func respondJSON(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
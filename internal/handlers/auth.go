@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/superfly/superfly/internal/auth"
+	"github.com/superfly/superfly/internal/logging"
+)
+
+// Authenticate identifies the caller of each request by the bearer token in
+// its Authorization header and stores it on the request context. Requests
+// without a known token get a 401, and viewers get a 403 for requests that
+// change something. Without tokens every caller is auth.Anonymous.
+func Authenticate(tokens *auth.Tokens) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			principal := auth.Anonymous
+			if tokens != nil {
+				token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+				if ok {
+					principal, ok = tokens.Authenticate(token)
+				}
+				if !ok {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="superfly"`)
+					respondError(w, http.StatusUnauthorized, CodeUnauthorized, "a valid bearer token is required")
+					return
+				}
+			}
+			if isMutating(r.Method) && !principal.Role.Allows(auth.RoleDeveloper) {
+				respondError(w, http.StatusForbidden, CodeForbidden, "viewers may not change apps")
+				return
+			}
+
+			ctx := auth.WithPrincipal(r.Context(), principal)
+			logger := logging.FromContext(ctx, slog.Default()).With(logging.KeyActor, principal.Name)
+			next.ServeHTTP(w, r.WithContext(logging.WithLogger(ctx, logger)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireToken responds 403 to auth.Anonymous, so routes that reach into
+// containers, such as exec and port-forward, stay closed when no tokens
+// are configured
+func RequireToken(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if auth.FromContext(r.Context()) == auth.Anonymous {
+			respondError(w, http.StatusForbidden, CodeForbidden, "requires a bearer token, but no tokens are configured")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// RequireRole responds 403 to callers whose role doesn't allow what role
+// may do
+func RequireRole(role auth.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !auth.FromContext(r.Context()).Role.Allows(role) {
+				respondError(w, http.StatusForbidden, CodeForbidden, "requires the "+string(role)+" role")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/superfly/superfly/internal/auth"
+)
+
+func TestAuthenticate(t *testing.T) {
+	hash := sha256.Sum256([]byte("viewer-token"))
+	tokens, err := auth.NewTokens([]auth.TokenConfig{
+		{Name: "vera", TokenSHA256: hex.EncodeToString(hash[:]), Role: "viewer"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		tokens        *auth.Tokens
+		method        string
+		authorization string
+		wantStatus    int
+		wantCaller    string
+	}{
+		{name: "no tokens", method: http.MethodPost, wantStatus: http.StatusOK, wantCaller: "anonymous"},
+		{name: "valid token", tokens: tokens, method: http.MethodGet, authorization: "Bearer viewer-token", wantStatus: http.StatusOK, wantCaller: "vera"},
+		{name: "missing token", tokens: tokens, method: http.MethodGet, wantStatus: http.StatusUnauthorized},
+		{name: "unknown token", tokens: tokens, method: http.MethodGet, authorization: "Bearer other", wantStatus: http.StatusUnauthorized},
+		{name: "not a bearer token", tokens: tokens, method: http.MethodGet, authorization: "viewer-token", wantStatus: http.StatusUnauthorized},
+		{name: "viewer changing an app", tokens: tokens, method: http.MethodPatch, authorization: "Bearer viewer-token", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var caller string
+			handler := Authenticate(tt.tokens)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				caller = auth.FromContext(r.Context()).Name
+			}))
+			r := httptest.NewRequest(tt.method, "/api/apps", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus || caller != tt.wantCaller {
+				t.Errorf("got status %d as %q, want %d as %q", w.Code, caller, tt.wantStatus, tt.wantCaller)
+			}
+		})
+	}
+}
+
+func TestRequireTokenAndRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		principal  auth.Principal
+		wantStatus int
+	}{
+		{name: "anonymous", principal: auth.Anonymous, wantStatus: http.StatusForbidden},
+		{name: "viewer", principal: auth.Principal{Name: "vera", Role: auth.RoleViewer}, wantStatus: http.StatusForbidden},
+		{name: "developer", principal: auth.Principal{Name: "dev", Role: auth.RoleDeveloper}, wantStatus: http.StatusOK},
+		{name: "admin", principal: auth.Principal{Name: "ada", Role: auth.RoleAdmin}, wantStatus: http.StatusOK},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := RequireToken(RequireRole(auth.RoleDeveloper)(ok))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/apps/web/exec", nil)
+			r = r.WithContext(auth.WithPrincipal(r.Context(), tt.principal))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
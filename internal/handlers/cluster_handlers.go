@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/superfly/superfly/internal/k8s"
+	"github.com/superfly/superfly/internal/service"
+)
+
+type ClusterHandlers struct {
+	clusters *k8s.Pool
+}
+
+func NewClusterHandlers(clusters *k8s.Pool) *ClusterHandlers {
+	return &ClusterHandlers{
+		clusters: clusters,
+	}
+}
+
+// ClusterResponse is a cluster of the registry with the outcome of checking
+// its API server. The check's detail is the server's version.
+type ClusterResponse struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels"`
+	InCluster bool              `json:"in_cluster"`
+	Default   bool              `json:"default"`
+	Health    DependencyCheck   `json:"health"`
+}
+
+// ListClustersResponse is the body of GET /api/clusters
+type ListClustersResponse struct {
+	Clusters []ClusterResponse `json:"clusters"`
+}
+
+// ListClusters handles GET /api/clusters. The clusters are checked
+// concurrently, so one that hangs only delays the response by the check
+// timeout.
+func (h *ClusterHandlers) ListClusters(w http.ResponseWriter, r *http.Request) {
+	configs := h.clusters.Clusters()
+	response := ListClustersResponse{Clusters: make([]ClusterResponse, len(configs))}
+
+	var wg sync.WaitGroup
+	for i, cluster := range configs {
+		wg.Add(1)
+		go func(i int, cluster k8s.ClusterConfig) {
+			defer wg.Done()
+			response.Clusters[i] = h.check(r.Context(), cluster)
+		}(i, cluster)
+	}
+	wg.Wait()
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// GetCluster handles GET /api/clusters/{name}
+func (h *ClusterHandlers) GetCluster(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	for _, cluster := range h.clusters.Clusters() {
+		if cluster.Name == name {
+			respondJSON(w, http.StatusOK, h.check(r.Context(), cluster))
+			return
+		}
+	}
+	respondError(w, http.StatusNotFound, service.CodeClusterNotFound, "cluster not found")
+}
+
+// check describes a cluster along with its health
+func (h *ClusterHandlers) check(ctx context.Context, cluster k8s.ClusterConfig) ClusterResponse {
+	client, _ := h.clusters.Get(cluster.Name)
+	labels := cluster.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return ClusterResponse{
+		Name:      cluster.Name,
+		Labels:    labels,
+		InCluster: cluster.InCluster,
+		Default:   cluster.Name == h.clusters.Default(),
+		Health:    runCheck(ctx, client.ServerVersion),
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/superfly/superfly/internal/logging"
+	"github.com/superfly/superfly/internal/openapi"
+	"github.com/superfly/superfly/internal/service"
+)
+
+// Error codes produced by the HTTP layer itself. Service errors carry their
+// own codes (see the service.Code* constants).
+const (
+	CodeInvalidRequest  = "invalid_request"
+	CodeInvalidManifest = "invalid_manifest"
+	CodeRequestTooLarge = "request_too_large"
+	CodeSyncDisabled    = "sync_disabled"
+	CodeUnauthorized    = "unauthorized"
+	CodeForbidden       = "forbidden"
+	CodeInternal        = "internal_error"
+)
+
+// unavailableRetryAfter is sent as Retry-After (seconds) on 503 responses
+const unavailableRetryAfter = "5"
+
+// errorResponse is the body of every error response. Code is stable and
+// meant for programs to branch on; Message is for humans.
+type errorResponse struct {
+	Code    string               `json:"code"`
+	Message string               `json:"message"`
+	Details []service.FieldError `json:"details,omitempty"`
+}
+
+func respondError(w http.ResponseWriter, statusCode int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(errorResponse{Code: code, Message: message})
+}
+
+// respondValidationError responds 400 with the fields that failed schema validation
+func respondValidationError(w http.ResponseWriter, fields []openapi.FieldError) {
+	details := make([]service.FieldError, len(fields))
+	for i, field := range fields {
+		details[i] = service.FieldError{Field: field.Field, Message: field.Message}
+	}
+	respondFieldErrors(w, details)
+}
+
+// respondFieldErrors responds 400 with per-field validation errors
+func respondFieldErrors(w http.ResponseWriter, fields []service.FieldError) {
+	respondJSON(w, http.StatusBadRequest, errorResponse{
+		Code:    service.CodeValidationFailed,
+		Message: "request validation failed",
+		Details: fields,
+	})
+}
+
+// respondServiceError maps an error returned by the service layer to a
+// status code and error body. Untyped errors are internal: they are logged
+// and replaced by a generic message so internals do not leak to clients.
+func respondServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	statusCode, body := serviceErrorResponse(r, err)
+	if statusCode == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", unavailableRetryAfter)
+	}
+	respondJSON(w, statusCode, body)
+}
+
+// serviceErrorResponse returns the status code and error body of an error
+// returned by the service layer, logging those that are the server's fault
+func serviceErrorResponse(r *http.Request, err error) (int, errorResponse) {
+	var svcErr *service.Error
+	if !errors.As(err, &svcErr) {
+		logging.FromContext(r.Context(), slog.Default()).Error("request failed", "error", err)
+		return http.StatusInternalServerError, errorResponse{Code: CodeInternal, Message: "internal server error"}
+	}
+
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(svcErr, service.ErrKeyReused):
+		statusCode = http.StatusUnprocessableEntity
+	case errors.Is(svcErr, service.ErrPreconditionFailed):
+		statusCode = http.StatusPreconditionFailed
+	case errors.Is(svcErr, service.ErrForbidden):
+		statusCode = http.StatusForbidden
+	case errors.Is(svcErr, service.ErrNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(svcErr, service.ErrConflict):
+		statusCode = http.StatusConflict
+	case errors.Is(svcErr, service.ErrValidation):
+		statusCode = http.StatusBadRequest
+	case errors.Is(svcErr, service.ErrUnavailable):
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	if statusCode >= http.StatusInternalServerError {
+		logging.FromContext(r.Context(), slog.Default()).Error("request failed", "error", err)
+	}
+
+	return statusCode, errorResponse{
+		Code:    svcErr.Code,
+		Message: svcErr.Message,
+		Details: svcErr.Fields,
+	}
+}
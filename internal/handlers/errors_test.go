@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/superfly/superfly/internal/service"
+)
+
+func TestRespondServiceError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantStatus     int
+		wantCode       string
+		wantMessage    string
+		wantDetails    int
+		wantRetryAfter string
+	}{
+		{
+			name:        "not found",
+			err:         &service.Error{Kind: service.ErrNotFound, Code: service.CodeAppNotFound, Message: "app not found"},
+			wantStatus:  http.StatusNotFound,
+			wantCode:    service.CodeAppNotFound,
+			wantMessage: "app not found",
+		},
+		{
+			name:        "conflict",
+			err:         &service.Error{Kind: service.ErrConflict, Code: service.CodeSlugTaken, Message: "an app with this slug already exists"},
+			wantStatus:  http.StatusConflict,
+			wantCode:    service.CodeSlugTaken,
+			wantMessage: "an app with this slug already exists",
+		},
+		{
+			name: "validation",
+			err: &service.Error{
+				Kind:    service.ErrValidation,
+				Code:    service.CodeValidationFailed,
+				Message: "validation failed",
+				Fields:  []service.FieldError{{Field: "port", Message: "must be between 1 and 65535"}, {Field: "replicas", Message: "must not be negative"}},
+			},
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    service.CodeValidationFailed,
+			wantMessage: "validation failed",
+			wantDetails: 2,
+		},
+		{
+			name: "idempotency key reused",
+			err: &service.Error{
+				Kind:    service.ErrValidation,
+				Code:    service.CodeIdempotencyKeyReused,
+				Message: "idempotency key was already used for a different request",
+				Err:     service.ErrKeyReused,
+			},
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantCode:    service.CodeIdempotencyKeyReused,
+			wantMessage: "idempotency key was already used for a different request",
+		},
+		{
+			name:        "precondition failed",
+			err:         &service.Error{Kind: service.ErrPreconditionFailed, Code: service.CodePreconditionFailed, Message: "app was modified since it was read"},
+			wantStatus:  http.StatusPreconditionFailed,
+			wantCode:    service.CodePreconditionFailed,
+			wantMessage: "app was modified since it was read",
+		},
+		{
+			name:        "forbidden",
+			err:         &service.Error{Kind: service.ErrForbidden, Code: service.CodeCustomResources, Message: "custom resources require the admin role"},
+			wantStatus:  http.StatusForbidden,
+			wantCode:    service.CodeCustomResources,
+			wantMessage: "custom resources require the admin role",
+		},
+		{
+			name:           "unavailable",
+			err:            &service.Error{Kind: service.ErrUnavailable, Code: service.CodeUnavailable, Message: "database unavailable", Err: errors.New("dial tcp: connection refused")},
+			wantStatus:     http.StatusServiceUnavailable,
+			wantCode:       service.CodeUnavailable,
+			wantMessage:    "database unavailable",
+			wantRetryAfter: unavailableRetryAfter,
+		},
+		{
+			name:        "wrapped service error",
+			err:         fmt.Errorf("get app: %w", &service.Error{Kind: service.ErrNotFound, Code: service.CodeAppNotFound, Message: "app not found"}),
+			wantStatus:  http.StatusNotFound,
+			wantCode:    service.CodeAppNotFound,
+			wantMessage: "app not found",
+		},
+		{
+			name:        "untyped error hides internals",
+			err:         errors.New("pq: relation apps does not exist"),
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    CodeInternal,
+			wantMessage: "internal server error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			respondServiceError(w, httptest.NewRequest(http.MethodGet, "/api/apps/web", nil), tt.err)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("got Retry-After %q, want %q", got, tt.wantRetryAfter)
+			}
+
+			var body errorResponse
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.wantCode || body.Message != tt.wantMessage {
+				t.Errorf("got %s %q, want %s %q", body.Code, body.Message, tt.wantCode, tt.wantMessage)
+			}
+			if len(body.Details) != tt.wantDetails {
+				t.Errorf("got %d details, want %d", len(body.Details), tt.wantDetails)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/superfly/superfly/internal/db"
+)
+
+// appETag is the strong entity tag of an app: its version, which changes
+// with every edit, and the time of its last update, which also changes when
+// a deploy moves its status on
+func appETag(app *db.App) string {
+	return `"` + strconv.FormatInt(app.Version, 10) + "-" + strconv.FormatInt(app.UpdatedAt.UnixMicro(), 10) + `"`
+}
+
+// ifMatch reports whether an If-Match header matches etag, using the
+// strong comparison RFC 9110 requires for If-Match; "*" or an absent header
+// match any version
+func ifMatch(header, etag string) bool {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		// Weak tags never equal etag, which is strong
+		if strings.TrimSpace(tag) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// noneMatch reports whether an If-None-Match header matches etag, using the
+// weak comparison RFC 9110 requires for If-None-Match
+func noneMatch(header, etag string) bool {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
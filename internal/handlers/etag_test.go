@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/superfly/superfly/internal/db"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", true},
+		{"*", true},
+		{`"4-1"`, true},
+		{` "3-1", "4-1" `, true},
+		{`"4-2"`, false},
+		{`"4"`, false},
+		{`W/"4-1"`, false},
+		{`4-1`, false},
+	}
+
+	for _, tt := range tests {
+		if got := ifMatch(tt.header, `"4-1"`); got != tt.want {
+			t.Errorf("ifMatch(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestAppETag(t *testing.T) {
+	updated := time.Date(2026, 1, 14, 10, 35, 0, 0, time.UTC)
+	app := &db.App{Version: 4, UpdatedAt: updated}
+	etag := appETag(app)
+	if etag != `"4-1768386900000000"` {
+		t.Errorf("got ETag %s, want \"4-1768386900000000\"", etag)
+	}
+	if !ifMatch(etag, etag) {
+		t.Errorf("ETag %s doesn't match itself", etag)
+	}
+
+	// A status change moves the update time, which both a cached copy and
+	// an update based on it must notice
+	deployed := &db.App{Version: 4, UpdatedAt: updated.Add(time.Second)}
+	if ifMatch(etag, appETag(deployed)) {
+		t.Errorf("status change kept ETag %s", etag)
+	}
+}
+
+func TestNoneMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"*", true},
+		{`"4-1"`, true},
+		{`W/"4-1"`, true},
+		{`"3-1", "4-1"`, true},
+		{`"4-2"`, false},
+		{`"4"`, false},
+	}
+
+	for _, tt := range tests {
+		if got := noneMatch(tt.header, `"4-1"`); got != tt.want {
+			t.Errorf("noneMatch(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/logging"
+	"github.com/superfly/superfly/internal/service"
+)
+
+// streamKeepalive is how often a quiet stream gets a comment, so proxies
+// don't close it as idle
+const streamKeepalive = 15 * time.Second
+
+// streamRetry is how long clients wait before reconnecting a closed
+// stream, in milliseconds
+const streamRetry = 3000
+
+type EventHandlers struct {
+	events *service.EventService
+}
+
+func NewEventHandlers(events *service.EventService) *EventHandlers {
+	return &EventHandlers{
+		events: events,
+	}
+}
+
+// EventResponse is an event of an app. Data depends on the type: a status
+// event has the app's status and the reason for it, a deploy event the
+// deployment ID and step, and a pod event the pod and what happened to it.
+type EventResponse struct {
+	ID        int64           `json:"id"`
+	AppID     uuid.UUID       `json:"app_id"`
+	Type      string          `json:"type" openapi:"enum=status|deploy|pod"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// StreamAppEvents handles GET /api/apps/{id}/events/stream
+func (h *EventHandlers) StreamAppEvents(w http.ResponseWriter, r *http.Request) {
+	app := appFromContext(r.Context())
+	h.stream(w, r, &app.ID)
+}
+
+// StreamEvents handles GET /api/events/stream, the events of every app
+func (h *EventHandlers) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	h.stream(w, r, nil)
+}
+
+// stream sends the events of an app, or of every app if appID is nil, as
+// Server-Sent Events. A client that reconnects with the Last-Event-ID
+// header, or the last_event_id query parameter, first gets the events it
+// missed. Events are sent at least once: those replayed from before the
+// last event seen may reach the client again. The stream ends if the
+// client falls too far behind, to resume the same way.
+func (h *EventHandlers) stream(w http.ResponseWriter, r *http.Request, appID *uuid.UUID) {
+	lastID, err := lastEventID(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, CodeInvalidRequest, "Last-Event-ID must be an event ID")
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logging.FromContext(r.Context(), slog.Default()).Error("failed to clear write deadline of event stream", "error", err)
+		respondError(w, http.StatusInternalServerError, CodeInternal, "event streams are not supported by this server")
+		return
+	}
+
+	// Subscribing before replaying the missed events means none slip
+	// through in between; those seen both ways are skipped by their ID
+	sub := h.events.Subscribe(appID)
+	defer h.events.Unsubscribe(sub)
+	sent := newSentEvents()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+
+	if lastID > 0 {
+		// The client may have missed events committed after the last one
+		// it got although their ID is lower, so events before it are
+		// replayed too, and may reach the client twice
+		err := h.events.Replay(r.Context(), appID, lastID, func(event db.AppEvent) error {
+			if !sent.add(event.ID) {
+				return nil
+			}
+			return writeEvent(w, event)
+		})
+		if err != nil {
+			// The client reconnects and resumes from the last event it got
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if !sent.add(event.ID) {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// sentEvents are the IDs of the events a stream sent within
+// service.ReplayWindow of the highest one, the only events that can come
+// again
+type sentEvents struct {
+	ids     map[int64]struct{}
+	highest int64
+}
+
+func newSentEvents() *sentEvents {
+	return &sentEvents{ids: make(map[int64]struct{})}
+}
+
+// add records an event as sent, returning false if it was already sent.
+// Events further behind than the window are taken as sent.
+func (s *sentEvents) add(id int64) bool {
+	if _, ok := s.ids[id]; ok || id <= s.highest-service.ReplayWindow {
+		return false
+	}
+	s.ids[id] = struct{}{}
+	s.highest = max(s.highest, id)
+
+	if len(s.ids) > 2*service.ReplayWindow {
+		for old := range s.ids {
+			if old <= s.highest-service.ReplayWindow {
+				delete(s.ids, old)
+			}
+		}
+	}
+	return true
+}
+
+// lastEventID returns the ID of the last event a client saw, 0 if none
+func lastEventID(r *http.Request) (int64, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// writeEvent writes an event in the text/event-stream format
+func writeEvent(w http.ResponseWriter, event db.AppEvent) error {
+	data, err := json.Marshal(EventResponse{
+		ID:        event.ID,
+		AppID:     event.AppID,
+		Type:      event.Type,
+		Data:      event.Data,
+		CreatedAt: event.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/superfly/superfly/internal/service"
+)
+
+func TestSentEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []int64
+		want []bool
+	}{
+		{name: "in order", ids: []int64{1, 2, 3}, want: []bool{true, true, true}},
+		{name: "replayed and live", ids: []int64{7, 8, 8, 9, 7}, want: []bool{true, true, false, true, false}},
+		{name: "committed late", ids: []int64{10, 12, 11, 12}, want: []bool{true, true, true, false}},
+		{
+			name: "behind the window",
+			ids:  []int64{1, 2 + service.ReplayWindow, 3, 2},
+			want: []bool{true, true, true, false},
+		},
+		{
+			name: "far behind the window",
+			ids:  []int64{5 * service.ReplayWindow, service.ReplayWindow},
+			want: []bool{true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := newSentEvents()
+			for i, id := range tt.ids {
+				if got := sent.add(id); got != tt.want[i] {
+					t.Errorf("add(%d) = %v, want %v", id, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSentEventsForgetsOldIDs(t *testing.T) {
+	sent := newSentEvents()
+	for id := int64(1); id <= 10*service.ReplayWindow; id++ {
+		sent.add(id)
+	}
+	if len(sent.ids) > 2*service.ReplayWindow+1 {
+		t.Errorf("remembers %d IDs, want at most %d", len(sent.ids), 2*service.ReplayWindow+1)
+	}
+}
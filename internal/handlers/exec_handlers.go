@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/superfly/superfly/internal/k8s"
+	"github.com/superfly/superfly/internal/service"
+	"github.com/superfly/superfly/internal/session"
+)
+
+// sessionUpgrader upgrades session requests to WebSocket. It checks that
+// the Origin header, if any, is the API's own, so other sites can't open a
+// session with a browser's credentials.
+var sessionUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 32 * 1024,
+}
+
+// Exec handles GET /api/apps/{id}/exec, which runs a command in a
+// container of the app over a WebSocket speaking the session protocol
+func (h *AppHandlers) Exec(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tty := false
+	if value := query.Get("tty"); value != "" {
+		var err error
+		if tty, err = strconv.ParseBool(value); err != nil {
+			respondError(w, http.StatusBadRequest, CodeInvalidRequest, "tty must be true or false")
+			return
+		}
+	}
+
+	app := appFromContext(r.Context())
+	exec, err := h.appService.PrepareExec(r.Context(), app, service.ExecInput{
+		Pod:       query.Get("pod"),
+		Container: query.Get("container"),
+		Command:   query["command"],
+		TTY:       tty,
+	})
+	if err != nil {
+		respondServiceError(w, r, err)
+		return
+	}
+
+	// Upgrade responds with an error itself if it fails
+	ws, err := sessionUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	conn := session.NewConn(ws)
+	defer conn.Close()
+
+	// The session ends when the client leaves
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	conn.KeepAlive(ctx)
+
+	// Closing stdin once the command exited unblocks input nobody reads
+	stdin, stdinWriter := io.Pipe()
+	defer stdin.Close()
+	resizes := make(chan *k8s.TerminalSize, 1)
+	go func() {
+		defer cancel()
+		readExecInput(conn, stdinWriter, resizes)
+	}()
+
+	exitCode, err := exec.Run(ctx, service.ExecStreams{
+		Stdin:  stdin,
+		Stdout: conn.Writer(session.ChannelStdout),
+		Stderr: conn.Writer(session.ChannelStderr),
+		Resize: func() *k8s.TerminalSize {
+			select {
+			case size := <-resizes:
+				return size
+			case <-ctx.Done():
+				return nil
+			}
+		},
+	})
+	if ctx.Err() != nil {
+		return
+	}
+
+	status := session.Status{ExitCode: exitCode}
+	if err != nil {
+		_, body := serviceErrorResponse(r, err)
+		status.Error, status.Code = body.Message, body.Code
+	}
+	conn.SendJSON(session.ChannelStatus, status)
+}
+
+// readExecInput copies the client's input to stdin and passes on its
+// terminal's size until the client leaves. Only the latest size is kept
+// for the command's terminal to pick up.
+func readExecInput(conn *session.Conn, stdin *io.PipeWriter, resizes chan *k8s.TerminalSize) {
+	for {
+		channel, payload, err := conn.Receive()
+		if err != nil {
+			stdin.CloseWithError(err)
+			return
+		}
+
+		switch channel {
+		case session.ChannelStdin:
+			if len(payload) == 0 {
+				stdin.Close()
+				continue
+			}
+			// Fails once the command stopped reading, which is up to it
+			stdin.Write(payload)
+
+		case session.ChannelResize:
+			var resize session.Resize
+			if err := json.Unmarshal(payload, &resize); err != nil {
+				continue
+			}
+			select {
+			case <-resizes:
+			default:
+			}
+			resizes <- &k8s.TerminalSize{Width: resize.Width, Height: resize.Height}
+		}
+	}
+}
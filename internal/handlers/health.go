@@ -1,36 +1,161 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/superfly/superfly/internal/k8s"
+	"github.com/superfly/superfly/internal/service"
+	"github.com/superfly/superfly/internal/version"
 )
 
-type HealthHandlers struct{}
+// readinessCheckTimeout bounds each dependency check so a hung dependency
+// reports as failed instead of hanging the probe
+const readinessCheckTimeout = 3 * time.Second
+
+type HealthHandlers struct {
+	pool       *pgxpool.Pool
+	clusters   *k8s.Pool
+	appService *service.AppService
+	namespace  string
+}
 
-func NewHealthHandlers() *HealthHandlers {
-	return &HealthHandlers{}
+// NewHealthHandlers creates the health handlers. namespace is the default
+// apps namespace the readiness check looks for in the default cluster;
+// the other clusters are reported by GET /api/clusters instead, so one
+// being down doesn't take the API out of service.
+func NewHealthHandlers(pool *pgxpool.Pool, clusters *k8s.Pool, appService *service.AppService, namespace string) *HealthHandlers {
+	return &HealthHandlers{
+		pool:       pool,
+		clusters:   clusters,
+		appService: appService,
+		namespace:  namespace,
+	}
 }
 
 type HealthResponse struct {
-	Status  string `json:"status"`
-	Version string `json:"version"`
+	Status    string `json:"status"`
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+}
+
+// DependencyCheck is the result of checking a single dependency
+type DependencyCheck struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ReadyResponse is the body of GET /ready
+type ReadyResponse struct {
+	Status string                     `json:"status"`
+	Checks map[string]DependencyCheck `json:"checks"`
+}
+
+// LiveResponse is the body of GET /livez
+type LiveResponse struct {
+	Status         string                  `json:"status"`
+	StalledDeploys []service.StalledDeploy `json:"stalled_deploys,omitempty"`
 }
 
 // Health handles GET /health
 func (h *HealthHandlers) Health(w http.ResponseWriter, r *http.Request) {
-	response := HealthResponse{
-		Status:  "ok",
-		Version: "0.1.0",
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	respondJSON(w, http.StatusOK, HealthResponse{
+		Status:    "ok",
+		Version:   version.Version,
+		Commit:    version.Commit,
+		BuildDate: version.BuildDate,
+	})
 }
 
 // Ready handles GET /ready
 func (h *HealthHandlers) Ready(w http.ResponseWriter, r *http.Request) {
-	// TODO: Add actual readiness checks (DB, K8s)
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("ready"))
+	k8sClient, _ := h.clusters.Get(h.clusters.Default())
+	checks := map[string]func(ctx context.Context) (string, error){
+		"database": func(ctx context.Context) (string, error) {
+			return "", h.pool.Ping(ctx)
+		},
+		"kubernetes": func(ctx context.Context) (string, error) {
+			return k8sClient.ServerVersion(ctx)
+		},
+		"namespace": func(ctx context.Context) (string, error) {
+			return h.namespace, k8sClient.NamespaceExists(ctx, h.namespace)
+		},
+	}
+
+	response, statusCode := checkReadiness(r.Context(), checks)
+	respondJSON(w, statusCode, response)
+}
+
+// checkReadiness runs the checks concurrently. The server is ready, with
+// status 200, only if every check passes.
+func checkReadiness(ctx context.Context, checks map[string]func(ctx context.Context) (string, error)) (ReadyResponse, int) {
+	response := ReadyResponse{
+		Status: "ready",
+		Checks: make(map[string]DependencyCheck, len(checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) (string, error)) {
+			defer wg.Done()
+			result := runCheck(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			response.Checks[name] = result
+		}(name, check)
+	}
+	wg.Wait()
+
+	statusCode := http.StatusOK
+	for _, result := range response.Checks {
+		if result.Status != "ok" {
+			response.Status = "not_ready"
+			statusCode = http.StatusServiceUnavailable
+		}
+	}
+	return response, statusCode
+}
+
+// Livez handles GET /livez. It only fails when background deploy workers are
+// deadlocked, since restarting the process is the only way to recover them;
+// dependency outages are reported by /ready instead.
+func (h *HealthHandlers) Livez(w http.ResponseWriter, r *http.Request) {
+	stalled := h.appService.StalledDeploys()
+	if len(stalled) > 0 {
+		respondJSON(w, http.StatusServiceUnavailable, LiveResponse{
+			Status:         "stalled",
+			StalledDeploys: stalled,
+		})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, LiveResponse{Status: "ok"})
+}
+
+// runCheck runs a dependency check with a timeout and measures its latency
+func runCheck(ctx context.Context, check func(ctx context.Context) (string, error)) DependencyCheck {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := check(ctx)
+	result := DependencyCheck{
+		Status:    "ok",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:    detail,
+	}
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+	return result
 }
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCheckReadiness(t *testing.T) {
+	ok := func(ctx context.Context) (string, error) { return "v1.29.0", nil }
+	failed := func(ctx context.Context) (string, error) { return "", errors.New("connection refused") }
+	hung := func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	tests := []struct {
+		name       string
+		checks     map[string]func(ctx context.Context) (string, error)
+		wantStatus string
+		wantCode   int
+		wantFailed []string
+	}{
+		{
+			name:       "all checks pass",
+			checks:     map[string]func(ctx context.Context) (string, error){"database": ok, "kubernetes": ok},
+			wantStatus: "ready",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:       "one check fails",
+			checks:     map[string]func(ctx context.Context) (string, error){"database": failed, "kubernetes": ok},
+			wantStatus: "not_ready",
+			wantCode:   http.StatusServiceUnavailable,
+			wantFailed: []string{"database"},
+		},
+		{
+			name:       "hung check times out",
+			checks:     map[string]func(ctx context.Context) (string, error){"database": ok, "kubernetes": hung},
+			wantStatus: "not_ready",
+			wantCode:   http.StatusServiceUnavailable,
+			wantFailed: []string{"kubernetes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			response, code := checkReadiness(ctx, tt.checks)
+			if response.Status != tt.wantStatus || code != tt.wantCode {
+				t.Errorf("got %s (%d), want %s (%d)", response.Status, code, tt.wantStatus, tt.wantCode)
+			}
+			if len(response.Checks) != len(tt.checks) {
+				t.Fatalf("got %d check results, want %d", len(response.Checks), len(tt.checks))
+			}
+
+			failures := 0
+			for name, result := range response.Checks {
+				if result.Status == "ok" {
+					continue
+				}
+				failures++
+				if result.Error == "" {
+					t.Errorf("failed check %s has no error", name)
+				}
+			}
+			if failures != len(tt.wantFailed) {
+				t.Errorf("got %d failed checks, want %v", failures, tt.wantFailed)
+			}
+			for _, name := range tt.wantFailed {
+				if response.Checks[name].Status != "failed" {
+					t.Errorf("check %s is %s, want failed", name, response.Checks[name].Status)
+				}
+			}
+		})
+	}
+
+	t.Run("detail of a passing check", func(t *testing.T) {
+		response, _ := checkReadiness(context.Background(), map[string]func(ctx context.Context) (string, error){"kubernetes": ok})
+		if got := response.Checks["kubernetes"].Detail; got != "v1.29.0" {
+			t.Errorf("got detail %q, want v1.29.0", got)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/superfly/superfly/internal/auth"
+	"github.com/superfly/superfly/internal/logging"
+	"github.com/superfly/superfly/internal/service"
+)
+
+const (
+	// IdempotencyKeyHeader carries the client-chosen key of a mutating request
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed from storage
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// replayedHeaders are the response headers stored alongside the body
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// Idempotency makes POST, PATCH and DELETE requests that carry an
+// Idempotency-Key header safe to retry: the first response is stored and
+// replayed for retries by the same caller with the same body, while reusing
+// the key for a different request is rejected with 422. Each caller has
+// their own keys. Server errors are not stored, so retrying after one runs
+// the request again.
+func Idempotency(idempotencyService *service.IdempotencyService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || !isMutating(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				respondError(w, http.StatusBadRequest, CodeInvalidRequest, "Idempotency-Key must be at most 255 characters")
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					respondError(w, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "request body too large")
+					return
+				}
+				respondError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			stored, err := idempotencyService.Begin(r.Context(), key, service.RequestHash(auth.FromContext(r.Context()).Name, r.Method, r.URL.Path, body))
+			if err != nil {
+				respondServiceError(w, r, err)
+				return
+			}
+			if stored != nil {
+				for name, values := range stored.Headers {
+					w.Header()[name] = values
+				}
+				w.Header().Set(IdempotentReplayedHeader, "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.Body)
+				return
+			}
+
+			var captured bytes.Buffer
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&captured)
+
+			finished := false
+			defer func() {
+				// Release the key if the handler panicked so retries are not
+				// stuck behind an in-progress claim
+				if !finished {
+					releaseIdempotencyKey(r, idempotencyService, key)
+				}
+			}()
+
+			next.ServeHTTP(ww, r)
+			finished = true
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status >= http.StatusInternalServerError {
+				releaseIdempotencyKey(r, idempotencyService, key)
+				return
+			}
+
+			headers := http.Header{}
+			for _, name := range replayedHeaders {
+				if value := w.Header().Get(name); value != "" {
+					headers.Set(name, value)
+				}
+			}
+
+			// The client may have gone away by now; storing the outcome must not
+			// depend on it still listening
+			err = idempotencyService.Complete(context.WithoutCancel(r.Context()), key, service.StoredResponse{
+				StatusCode: status,
+				Headers:    headers,
+				Body:       captured.Bytes(),
+			})
+			if err != nil {
+				logging.FromContext(r.Context(), slog.Default()).Error("failed to store idempotent response",
+					"idempotency_key", key, "error", err)
+				releaseIdempotencyKey(r, idempotencyService, key)
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func releaseIdempotencyKey(r *http.Request, idempotencyService *service.IdempotencyService, key string) {
+	err := idempotencyService.Release(context.WithoutCancel(r.Context()), key)
+	if err != nil {
+		logging.FromContext(r.Context(), slog.Default()).Warn("failed to release idempotency key",
+			"idempotency_key", key, "error", err)
+	}
+}
+
+func isMutating(method string) bool {
+	return method == http.MethodPost || method == http.MethodPatch || method == http.MethodDelete
+}
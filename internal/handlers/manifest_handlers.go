@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/superfly/superfly/internal/manifest"
+	"github.com/superfly/superfly/internal/service"
+)
+
+// manifestContentType is the media type of superfly.toml
+const manifestContentType = "application/toml"
+
+// ApplyResponse is the result of applying a manifest, with the app as the
+// API returns it
+type ApplyResponse struct {
+	service.ApplyResult
+	App *AppResponse `json:"app,omitempty"`
+}
+
+// Apply handles POST /api/apply. The body is a superfly.toml manifest; with
+// ?dry_run=true only the plan is returned.
+func (h *AppHandlers) Apply(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, CodeInvalidRequest, "dry_run must be true or false")
+			return
+		}
+		dryRun = parsed
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondError(w, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "request body too large")
+			return
+		}
+		respondError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+
+	m, err := manifest.Parse(body)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, CodeInvalidManifest, "invalid manifest: "+err.Error())
+		return
+	}
+
+	result, err := h.appService.Apply(r.Context(), m, dryRun)
+	if err != nil {
+		respondServiceError(w, r, err)
+		return
+	}
+
+	response := ApplyResponse{ApplyResult: *result}
+	statusCode := http.StatusOK
+	if result.App != nil {
+		response.App = newAppResponse(result.App)
+		w.Header().Set("ETag", appETag(result.App))
+		if result.Applied && result.Plan.Action == service.PlanCreate {
+			statusCode = http.StatusCreated
+		}
+	}
+	respondJSON(w, statusCode, response)
+}
+
+// ExportApp handles GET /api/apps/:id/manifest
+func (h *AppHandlers) ExportApp(w http.ResponseWriter, r *http.Request) {
+	app := appFromContext(r.Context())
+
+	m, err := h.appService.ExportManifest(app)
+	if err != nil {
+		respondServiceError(w, r, err)
+		return
+	}
+	data, err := manifest.Encode(m)
+	if err != nil {
+		respondServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", manifestContentType)
+	w.Header().Set("ETag", appETag(app))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/superfly/superfly/internal/gitops"
+	"github.com/superfly/superfly/internal/openapi"
+	"github.com/superfly/superfly/internal/version"
+)
+
+// maxRequestBodyBytes caps JSON request bodies read for validation
+const maxRequestBodyBytes = 1 << 20
+
+// NewOpenAPIDocument describes every API route. Request and response schemas
+// are derived from the handler types, so they cannot drift from what the
+// handlers actually decode and encode. The routes themselves are checked
+// against NewRouter at startup and in tests.
+func NewOpenAPIDocument() *openapi.Document {
+	doc := openapi.NewDocument("Superfly API", version.Version)
+
+	app := doc.Schema("App", AppResponse{})
+	appList := doc.Schema("AppList", ListAppsResponse{})
+	createApp := doc.Schema("CreateAppRequest", CreateAppRequest{})
+	updateApp := doc.Schema("UpdateAppRequest", UpdateAppRequest{})
+	applyResult := doc.Schema("ApplyResult", ApplyResponse{})
+	syncStatus := doc.Schema("SyncStatus", gitops.Status{})
+	cluster := doc.Schema("Cluster", ClusterResponse{})
+	clusterList := doc.Schema("ClusterList", ListClustersResponse{})
+	sizeList := doc.Schema("SizeList", ListSizesResponse{})
+	event := doc.Schema("Event", EventResponse{})
+	podList := doc.Schema("PodList", ListPodsResponse{})
+	kubernetesEventList := doc.Schema("KubernetesEventList", ListKubernetesEventsResponse{})
+	errorBody := doc.Schema("Error", errorResponse{})
+	message := doc.Schema("Message", struct {
+		Message string `json:"message"`
+	}{})
+	health := doc.Schema("Health", HealthResponse{})
+	ready := doc.Schema("Ready", ReadyResponse{})
+	live := doc.Schema("Live", LiveResponse{})
+
+	idParam := openapi.Parameter{
+		Name:        "id",
+		In:          "path",
+		Description: "App ID or slug",
+		Required:    true,
+		Schema:      stringSchema,
+	}
+	idempotencyKeyParam := headerParam(IdempotencyKeyHeader,
+		"Client-chosen key (at most 255 characters) that makes the request safe to retry; retries replay the first response")
+	etagHeader := map[string]openapi.Header{
+		"ETag": {Description: "Current version and last update of the app", Schema: stringSchema},
+	}
+	errorResponses := func(responses map[string]openapi.Response, codes ...int) map[string]openapi.Response {
+		for _, code := range codes {
+			responses[fmt.Sprint(code)] = openapi.Response{
+				Description: http.StatusText(code),
+				Content:     openapi.JSON(errorBody),
+			}
+		}
+		return responses
+	}
+
+	doc.Add(http.MethodGet, "/health", &openapi.Operation{
+		OperationID: "getHealth",
+		Summary:     "Report that the server is up and its build version",
+		Tags:        []string{"health"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Server is up", Content: openapi.JSON(health)},
+		},
+	})
+	doc.Add(http.MethodGet, "/ready", &openapi.Operation{
+		OperationID: "getReadiness",
+		Summary:     "Check database and Kubernetes connectivity",
+		Tags:        []string{"health"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "All dependencies are reachable", Content: openapi.JSON(ready)},
+			"503": {Description: "At least one dependency failed", Content: openapi.JSON(ready)},
+		},
+	})
+	doc.Add(http.MethodGet, "/livez", &openapi.Operation{
+		OperationID: "getLiveness",
+		Summary:     "Check that deploy workers are making progress",
+		Tags:        []string{"health"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Deploy workers are healthy", Content: openapi.JSON(live)},
+			"503": {Description: "A deploy worker is stalled", Content: openapi.JSON(live)},
+		},
+	})
+	doc.Add(http.MethodGet, "/api/openapi.json", &openapi.Operation{
+		OperationID: "getOpenAPI",
+		Summary:     "This document",
+		Tags:        []string{"meta"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "OpenAPI 3 document", Content: openapi.JSON(&openapi.Schema{Type: "object"})},
+		},
+	})
+
+	doc.Add(http.MethodGet, "/api/apps", &openapi.Operation{
+		OperationID: "listApps",
+		Summary:     "List apps, one page at a time",
+		Tags:        []string{"apps"},
+		Parameters: []openapi.Parameter{
+			queryParam("limit", "Page size, 1-200 (default 50)", &openapi.Schema{Type: "integer", Format: "int32"}),
+			queryParam("cursor", "next_cursor from the previous page", stringSchema),
+			queryParam("status", "Only apps with this status", &openapi.Schema{Type: "string", Enum: []string{"pending", "deploying", "running", "failed"}}),
+			queryParam("q", "Substring of the name or slug (case-insensitive)", stringSchema),
+			queryParam("domain", "Only the app with this domain", stringSchema),
+			queryParam("image", "Image prefix, e.g. nginx matches nginx:alpine", stringSchema),
+			queryParam("owner", "Only apps with this owner", stringSchema),
+			queryParam("label", "key=value label selector; repeat to require several", &openapi.Schema{Type: "array", Items: stringSchema}),
+			queryParam("sort", "Sort field (default created_at)", &openapi.Schema{Type: "string", Enum: []string{"created_at", "updated_at", "name", "slug"}}),
+			queryParam("order", "Sort direction (default desc for timestamps, asc for name and slug)", &openapi.Schema{Type: "string", Enum: []string{"asc", "desc"}}),
+		},
+		Responses: errorResponses(map[string]openapi.Response{
+			"200": {Description: "A page of apps", Content: openapi.JSON(appList)},
+		}, http.StatusBadRequest, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodPost, "/api/apps", &openapi.Operation{
+		OperationID: "createApp",
+		Summary:     "Create an app and deploy it to Kubernetes",
+		Tags:        []string{"apps"},
+		Parameters:  []openapi.Parameter{idempotencyKeyParam},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(createApp)},
+		Responses: errorResponses(map[string]openapi.Response{
+			"201": {Description: "App created, deploy started", Headers: etagHeader, Content: openapi.JSON(app)},
+		}, http.StatusBadRequest, http.StatusForbidden, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodGet, "/api/apps/{id}", &openapi.Operation{
+		OperationID: "getApp",
+		Summary:     "Get an app",
+		Tags:        []string{"apps"},
+		Parameters: []openapi.Parameter{
+			idParam,
+			headerParam("If-None-Match", "ETag from an earlier response; 304 if the app has not changed since"),
+		},
+		Responses: errorResponses(map[string]openapi.Response{
+			"200": {Description: "The app", Headers: etagHeader, Content: openapi.JSON(app)},
+			"304": {Description: "The app has not changed", Headers: etagHeader},
+		}, http.StatusNotFound, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodPatch, "/api/apps/{id}", &openapi.Operation{
+		OperationID: "updateApp",
+		Summary:     "Update an app, redeploying if its runtime config changed",
+		Tags:        []string{"apps"},
+		Parameters: []openapi.Parameter{
+			idParam,
+			idempotencyKeyParam,
+			headerParam("If-Match", "ETag the update is based on; 412 if the app has changed since"),
+		},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(updateApp)},
+		Responses: errorResponses(map[string]openapi.Response{
+			"200": {Description: "The updated app", Headers: etagHeader, Content: openapi.JSON(app)},
+		}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed, http.StatusUnprocessableEntity, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodDelete, "/api/apps/{id}", &openapi.Operation{
+		OperationID: "deleteApp",
+		Summary:     "Delete an app and its Kubernetes resources",
+		Tags:        []string{"apps"},
+		Parameters:  []openapi.Parameter{idParam, idempotencyKeyParam},
+		Responses: errorResponses(map[string]openapi.Response{
+			"204": {Description: "App deleted"},
+		}, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodPost, "/api/apps/{id}/restart", &openapi.Operation{
+		OperationID: "restartApp",
+		Summary:     "Trigger a rolling restart",
+		Tags:        []string{"apps"},
+		Parameters:  []openapi.Parameter{idParam, idempotencyKeyParam},
+		Responses: errorResponses(map[string]openapi.Response{
+			"200": {Description: "Restart initiated", Content: openapi.JSON(message)},
+		}, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+
+	doc.Add(http.MethodGet, "/api/apps/{id}/pods", &openapi.Operation{
+		OperationID: "listAppPods",
+		Summary:     "List an app's pods",
+		Tags:        []string{"pods"},
+		Parameters:  []openapi.Parameter{idParam},
+		Responses: errorResponses(map[string]openapi.Response{
+			"200": {Description: "The app's pods, oldest first", Content: openapi.JSON(podList)},
+		}, http.StatusNotFound, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodDelete, "/api/apps/{id}/pods/{pod}", &openapi.Operation{
+		OperationID: "deleteAppPod",
+		Summary:     "Delete a pod of an app",
+		Description: "Kubernetes replaces the pod with a new one, so this restarts a single stuck instance.",
+		Tags:        []string{"pods"},
+		Parameters: []openapi.Parameter{idParam, {
+			Name:        "pod",
+			In:          "path",
+			Description: "Pod name",
+			Required:    true,
+			Schema:      stringSchema,
+		}},
+		Responses: errorResponses(map[string]openapi.Response{
+			"204": {Description: "Pod deleted"},
+		}, http.StatusNotFound, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodGet, "/api/apps/{id}/k8s-events", &openapi.Operation{
+		OperationID: "listAppKubernetesEvents",
+		Summary:     "List the Kubernetes events of an app",
+		Description: "The 200 most recent events of the app's Deployment, ReplicaSets and pods, most recent last. " +
+			"Kubernetes keeps events for an hour by default.",
+		Tags:       []string{"pods"},
+		Parameters: []openapi.Parameter{idParam},
+		Responses: errorResponses(map[string]openapi.Response{
+			"200": {Description: "The app's Kubernetes events", Content: openapi.JSON(kubernetesEventList)},
+		}, http.StatusNotFound, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+
+	doc.Add(http.MethodGet, "/api/apps/{id}/manifest", &openapi.Operation{
+		OperationID: "exportAppManifest",
+		Summary:     "Export an app as a superfly.toml manifest",
+		Tags:        []string{"manifests"},
+		Parameters:  []openapi.Parameter{idParam},
+		Responses: errorResponses(map[string]openapi.Response{
+			"200": {Description: "The app's manifest", Headers: etagHeader, Content: manifestContent},
+		}, http.StatusNotFound, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodPost, "/api/apply", &openapi.Operation{
+		OperationID: "applyManifest",
+		Summary:     "Create or update an app from a superfly.toml manifest",
+		Description: "Diffs the manifest against the stored app and its live Kubernetes objects. " +
+			"With dry_run=true the plan is returned without changing anything; otherwise the " +
+			"app is updated in one step and redeployed if needed.",
+		Tags: []string{"manifests"},
+		Parameters: []openapi.Parameter{
+			queryParam("dry_run", "Only return the plan", &openapi.Schema{Type: "boolean"}),
+			idempotencyKeyParam,
+		},
+		RequestBody: &openapi.RequestBody{Required: true, Content: manifestContent},
+		Responses: errorResponses(map[string]openapi.Response{
+			"200": {Description: "The plan, and the app if it was applied", Content: openapi.JSON(applyResult)},
+			"201": {Description: "The app was created", Headers: etagHeader, Content: openapi.JSON(applyResult)},
+		}, http.StatusBadRequest, http.StatusForbidden, http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+
+	doc.Add(http.MethodGet, "/api/sync", &openapi.Operation{
+		OperationID: "getSyncStatus",
+		Summary:     "Report the outcome of the last GitOps sync",
+		Tags:        []string{"manifests"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Sync status, with per-app results", Content: openapi.JSON(syncStatus)},
+		},
+	})
+	doc.Add(http.MethodPost, "/api/sync", &openapi.Operation{
+		OperationID: "triggerSync",
+		Summary:     "Sync apps from the GitOps repository now",
+		Tags:        []string{"manifests"},
+		Parameters:  []openapi.Parameter{idempotencyKeyParam},
+		Responses: errorResponses(map[string]openapi.Response{
+			"202": {Description: "Sync queued; poll GET /api/sync for the outcome", Content: openapi.JSON(syncStatus)},
+		}, http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity),
+	})
+
+	doc.Add(http.MethodGet, "/api/clusters", &openapi.Operation{
+		OperationID: "listClusters",
+		Summary:     "List the clusters apps can be deployed to, with their health",
+		Tags:        []string{"clusters"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Every cluster of the registry", Content: openapi.JSON(clusterList)},
+		},
+	})
+	doc.Add(http.MethodGet, "/api/clusters/{name}", &openapi.Operation{
+		OperationID: "getCluster",
+		Summary:     "Get a cluster and check its health",
+		Tags:        []string{"clusters"},
+		Parameters: []openapi.Parameter{{
+			Name:        "name",
+			In:          "path",
+			Description: "Cluster name",
+			Required:    true,
+			Schema:      stringSchema,
+		}},
+		Responses: errorResponses(map[string]openapi.Response{
+			"200": {Description: "The cluster", Content: openapi.JSON(cluster)},
+		}, http.StatusNotFound),
+	})
+
+	doc.Add(http.MethodGet, "/api/sizes", &openapi.Operation{
+		OperationID: "listSizes",
+		Summary:     "List the machine sizes apps can pick, with their prices",
+		Tags:        []string{"sizes"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Every size of the catalog; empty without one", Content: openapi.JSON(sizeList)},
+		},
+	})
+
+	// Each event of a stream is an Event in its data field
+	eventStream := map[string]openapi.MediaType{
+		"text/event-stream": {Schema: event},
+	}
+	lastEventIDParams := []openapi.Parameter{
+		headerParam("Last-Event-ID", "ID of the last event the client got; the stream starts with the events after it, "+
+			"and the 100 before it, which may have been committed after it"),
+		queryParam("last_event_id", "Last-Event-ID for clients that can't set headers", stringSchema),
+	}
+	doc.Add(http.MethodGet, "/api/apps/{id}/events/stream", &openapi.Operation{
+		OperationID: "streamAppEvents",
+		Summary:     "Stream an app's status changes, deploy steps and pod events",
+		Description: "Server-Sent Events of the app recorded from now on, or after Last-Event-ID. " +
+			"Events are delivered at least once. The stream ends if the client falls behind; reconnecting resumes it.",
+		Tags:       []string{"events"},
+		Parameters: append([]openapi.Parameter{idParam}, lastEventIDParams...),
+		Responses: errorResponses(map[string]openapi.Response{
+			"200": {Description: "The app's events", Content: eventStream},
+		}, http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodGet, "/api/events/stream", &openapi.Operation{
+		OperationID: "streamEvents",
+		Summary:     "Stream the events of every app",
+		Tags:        []string{"events"},
+		Parameters:  lastEventIDParams,
+		Responses: errorResponses(map[string]openapi.Response{
+			"200": {Description: "The events of every app", Content: eventStream},
+		}, http.StatusBadRequest, http.StatusInternalServerError),
+	})
+
+	doc.Add(http.MethodGet, "/api/apps/{id}/exec", &openapi.Operation{
+		OperationID: "execApp",
+		Summary:     "Run a command in a container of an app",
+		Description: "Upgrades to a WebSocket speaking the session protocol described in API.md. " +
+			"Requires the role configured with EXEC_ROLE, developer by default, and is refused when TOKENS_FILE is not set. " +
+			"The start and end of each session are recorded in the audit log.",
+		Tags: []string{"pods"},
+		Parameters: []openapi.Parameter{
+			idParam,
+			queryParam("pod", "Pod to run the command in; a random running pod by default", stringSchema),
+			queryParam("container", "Container to run the command in; required for pods with several", stringSchema),
+			queryParam("command", "The command and its arguments, one parameter each; /bin/sh by default",
+				&openapi.Schema{Type: "array", Items: stringSchema}),
+			queryParam("tty", "Allocate a terminal", &openapi.Schema{Type: "boolean"}),
+		},
+		Responses: errorResponses(map[string]openapi.Response{
+			"101": {Description: "Switched to WebSocket"},
+		}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+
+	doc.Add(http.MethodGet, "/api/apps/{id}/port-forward", &openapi.Operation{
+		OperationID: "portForwardApp",
+		Summary:     "Forward connections to a port of a pod of an app",
+		Description: "Upgrades to a WebSocket speaking the session protocol described in API.md, " +
+			"over which the client opens any number of connections to the port. " +
+			"Requires the role configured with PORT_FORWARD_ROLE, developer by default, and is refused when TOKENS_FILE is not set. " +
+			"The start and end of each session are recorded in the audit log.",
+		Tags: []string{"pods"},
+		Parameters: []openapi.Parameter{
+			idParam,
+			queryParam("pod", "Pod to forward connections to; a random running pod by default", stringSchema),
+			{
+				Name:        "port",
+				In:          "query",
+				Description: "Port of the pod, from 1 to 65535",
+				Required:    true,
+				Schema:      &openapi.Schema{Type: "integer"},
+			},
+		},
+		Responses: errorResponses(map[string]openapi.Response{
+			"101": {Description: "Switched to WebSocket"},
+		}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError, http.StatusServiceUnavailable),
+	})
+
+	// With tokens configured, every API request needs one, and viewers may
+	// not change anything
+	for path, item := range doc.Paths {
+		if !strings.HasPrefix(path, "/api/") {
+			continue
+		}
+		for method, op := range *item {
+			op.Responses = errorResponses(op.Responses, http.StatusUnauthorized)
+			if isMutating(strings.ToUpper(method)) {
+				op.Responses = errorResponses(op.Responses, http.StatusForbidden)
+			}
+		}
+	}
+
+	return doc
+}
+
+// manifestContent is a superfly.toml request or response body
+var manifestContent = map[string]openapi.MediaType{
+	manifestContentType: {Schema: stringSchema},
+}
+
+var stringSchema = &openapi.Schema{Type: "string"}
+
+func headerParam(name, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "header", Description: description, Schema: stringSchema}
+}
+
+func queryParam(name, description string, schema *openapi.Schema) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// ServeOpenAPI handles GET /api/openapi.json
+func ServeOpenAPI(doc *openapi.Document) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, doc)
+	}
+}
+
+// ValidateRequest validates JSON request bodies against the request schema of
+// the given operation, responding 400 with per-field errors on mismatch. It
+// panics if the operation has no JSON request body, since that means the
+// route and the document disagree.
+func ValidateRequest(doc *openapi.Document, operationID string) func(http.Handler) http.Handler {
+	schema := doc.RequestSchema(operationID)
+	if schema == nil {
+		panic(fmt.Sprintf("handlers: operation %q has no JSON request body", operationID))
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					respondError(w, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "request body too large")
+					return
+				}
+				respondError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+				return
+			}
+
+			var value any
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			decoder.UseNumber()
+			if err := decoder.Decode(&value); err != nil {
+				respondError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+				return
+			}
+
+			if fields := doc.Validate(schema, value); len(fields) > 0 {
+				respondValidationError(w, fields)
+				return
+			}
+
+			// Hand the handler a fresh copy of the body we consumed
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superfly/superfly/internal/auth"
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/openapi"
+	"github.com/superfly/superfly/internal/service"
+)
+
+func TestRoutesMatchDocument(t *testing.T) {
+	doc := NewOpenAPIDocument()
+	router := NewRouter(RouterOptions{
+		Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Doc:             doc,
+		ExecRole:        auth.RoleDeveloper,
+		PortForwardRole: auth.RoleDeveloper,
+		Apps:            &AppHandlers{},
+		Health:          &HealthHandlers{},
+		Sync:            &SyncHandlers{},
+		Clusters:        &ClusterHandlers{},
+		Sizes:           &SizeHandlers{},
+		Events:          &EventHandlers{},
+	})
+
+	if err := doc.CheckRoutes(router); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestBodiesMatchSchemas(t *testing.T) {
+	doc := NewOpenAPIDocument()
+	replicas := int32(3)
+	image := "nginx:1.27"
+
+	tests := []struct {
+		name        string
+		operationID string
+		body        any
+		wantFields  []string
+	}{
+		{
+			name:        "full create request",
+			operationID: "createApp",
+			body: CreateAppRequest{
+				Name:            "Web",
+				Slug:            "web",
+				Image:           "nginx:alpine",
+				Port:            8080,
+				Replicas:        2,
+				CPULimit:        "500m",
+				MemoryLimit:     "256Mi",
+				Domain:          "web.example.com",
+				HealthCheckPath: "/healthz",
+				Owner:           "acme",
+				Labels:          map[string]string{"team": "web"},
+				Env:             []service.EnvVar{{Name: "MODE", Value: "prod"}},
+				HealthChecks:    service.HealthChecks{Readiness: &service.Probe{Type: "http", Path: "/ready"}},
+				Ports:           []service.Port{{Name: "http", Port: 8080, Protocol: "http"}},
+				ServiceType:     "cluster_ip",
+				Visibility:      "public",
+				Aliases:         []string{"www"},
+				AllowFrom:       []string{"api"},
+				Egress:          "allow_all",
+				AutoRollback:    true,
+				CPURequest:      "250m",
+				MemoryRequest:   "128Mi",
+			},
+		},
+		{
+			name:        "full update request",
+			operationID: "updateApp",
+			body:        UpdateAppRequest{Image: &image, Replicas: &replicas},
+		},
+		{
+			name:        "create request without image",
+			operationID: "createApp",
+			body:        map[string]any{"name": "web"},
+			wantFields:  []string{"image"},
+		},
+		{
+			name:        "misspelled field",
+			operationID: "updateApp",
+			body:        map[string]any{"replica": 2},
+			wantFields:  []string{"replica"},
+		},
+		{
+			name:        "port out of range",
+			operationID: "createApp",
+			body:        map[string]any{"name": "web", "image": "nginx", "port": 70000},
+			wantFields:  []string{"port"},
+		},
+		{
+			name:        "unknown visibility",
+			operationID: "updateApp",
+			body:        map[string]any{"visibility": "secret"},
+			wantFields:  []string{"visibility"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := doc.RequestSchema(tt.operationID)
+			if schema == nil {
+				t.Fatalf("operation %s has no request schema", tt.operationID)
+			}
+			assertFields(t, doc.Validate(schema, decode(t, tt.body)), tt.wantFields)
+		})
+	}
+}
+
+func TestResponseBodiesMatchSchemas(t *testing.T) {
+	doc := NewOpenAPIDocument()
+	app := AppResponse{App: db.App{
+		ID:           uuid.New(),
+		Slug:         "web",
+		Name:         "Web",
+		Image:        "nginx:alpine",
+		Port:         8080,
+		Replicas:     1,
+		Status:       "running",
+		Labels:       json.RawMessage(`{}`),
+		Env:          json.RawMessage(`[]`),
+		HealthChecks: json.RawMessage(`{}`),
+		Ports:        json.RawMessage(`[]`),
+		Aliases:      []string{},
+		AllowFrom:    []string{},
+		EgressCidrs:  []string{},
+	}}
+	cursor := "eyJ2IjoxfQ"
+
+	tests := []struct {
+		schema string
+		body   any
+	}{
+		{"App", app},
+		{"AppList", ListAppsResponse{Apps: []AppResponse{app}, NextCursor: &cursor}},
+		{"AppList", ListAppsResponse{Apps: []AppResponse{}}},
+		{"Error", errorResponse{Code: CodeInvalidRequest, Message: "invalid request body"}},
+		{"Event", EventResponse{ID: 1, AppID: uuid.New(), Type: "status", Data: json.RawMessage(`{"status":"running"}`), CreatedAt: time.Now()}},
+		{"SizeList", ListSizesResponse{Sizes: []SizeResponse{{
+			Name:                    "small",
+			CPURequest:              "250m",
+			CPULimit:                "500m",
+			MemoryRequest:           "256Mi",
+			MemoryLimit:             "512Mi",
+			EphemeralStorageRequest: "",
+			EphemeralStorageLimit:   "",
+			NodeSelector:            map[string]string{},
+			PricePerHour:            0.01,
+			Default:                 true,
+		}}}},
+		{"ClusterList", ListClustersResponse{Clusters: []ClusterResponse{{
+			Name:    "default",
+			Labels:  map[string]string{},
+			Default: true,
+			Health:  DependencyCheck{Status: "ok", LatencyMS: 1.5},
+		}}}},
+		{"Health", HealthResponse{Status: "ok", Version: "dev", Commit: "none", BuildDate: "unknown"}},
+		{"Ready", ReadyResponse{Status: "ok", Checks: map[string]DependencyCheck{"database": {Status: "ok"}}}},
+		{"PodList", ListPodsResponse{Pods: []service.Pod{}}},
+		{"KubernetesEventList", ListKubernetesEventsResponse{Events: []service.KubernetesEvent{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			assertFields(t, doc.Validate(openapi.Ref(tt.schema), decode(t, tt.body)), nil)
+		})
+	}
+}
+
+// decode round-trips v through JSON the way ValidateRequest decodes bodies
+func decode(t *testing.T, v any) any {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func assertFields(t *testing.T, errs []openapi.FieldError, want []string) {
+	t.Helper()
+	if len(errs) != len(want) {
+		t.Fatalf("got errors %v, want errors for %v", errs, want)
+	}
+	for i, err := range errs {
+		if err.Field != want[i] {
+			t.Errorf("error %d is for %s (%s), want %s", i, err.Field, err.Message, want[i])
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/superfly/superfly/internal/service"
+)
+
+// ListPodsResponse is the body of GET /api/apps/{id}/pods
+type ListPodsResponse struct {
+	Pods []service.Pod `json:"pods"`
+}
+
+// ListKubernetesEventsResponse is the body of GET /api/apps/{id}/k8s-events
+type ListKubernetesEventsResponse struct {
+	Events []service.KubernetesEvent `json:"events"`
+}
+
+// ListPods handles GET /api/apps/{id}/pods
+func (h *AppHandlers) ListPods(w http.ResponseWriter, r *http.Request) {
+	pods, err := h.appService.ListPods(r.Context(), appFromContext(r.Context()))
+	if err != nil {
+		respondServiceError(w, r, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, ListPodsResponse{Pods: pods})
+}
+
+// ListKubernetesEvents handles GET /api/apps/{id}/k8s-events
+func (h *AppHandlers) ListKubernetesEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := h.appService.ListKubernetesEvents(r.Context(), appFromContext(r.Context()))
+	if err != nil {
+		respondServiceError(w, r, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, ListKubernetesEventsResponse{Events: events})
+}
+
+// DeletePod handles DELETE /api/apps/{id}/pods/{pod}
+func (h *AppHandlers) DeletePod(w http.ResponseWriter, r *http.Request) {
+	err := h.appService.DeletePod(r.Context(), appFromContext(r.Context()), chi.URLParam(r, "pod"))
+	if err != nil {
+		respondServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/superfly/superfly/internal/k8s"
+	"github.com/superfly/superfly/internal/service"
+	"github.com/superfly/superfly/internal/session"
+)
+
+// forwardBuffer is how many messages of a forwarded connection may wait to
+// be written to the pod before the connection is closed as too slow
+const forwardBuffer = 16
+
+// PortForward handles GET /api/apps/{id}/port-forward, which forwards
+// connections to a port of a pod of the app over a WebSocket speaking the
+// session protocol
+func (h *AppHandlers) PortForward(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	port, err := strconv.Atoi(query.Get("port"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, CodeInvalidRequest, "port must be a port number")
+		return
+	}
+
+	app := appFromContext(r.Context())
+	forward, err := h.appService.PreparePortForward(r.Context(), app, service.PortForwardInput{
+		Pod:  query.Get("pod"),
+		Port: port,
+	})
+	if err != nil {
+		respondServiceError(w, r, err)
+		return
+	}
+
+	// Upgrade responds with an error itself if it fails
+	ws, err := sessionUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	conn := session.NewConn(ws)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	conn.KeepAlive(ctx)
+
+	err = forward.Run(ctx, func(pf *k8s.PortForward) error {
+		return forwardConnections(ctx, conn, pf)
+	})
+	if errors.Is(err, errClientGone) {
+		return
+	}
+
+	status := session.Status{}
+	if err != nil {
+		_, body := serviceErrorResponse(r, err)
+		status.Error, status.Code = body.Message, body.Code
+	}
+	conn.SendJSON(session.ChannelStatus, status)
+}
+
+// errClientGone ends a session whose client disconnected
+var errClientGone = errors.New("client disconnected")
+
+// forwardConnections forwards the connections the client opens until it
+// leaves, ctx is done or the connection to the pod is lost
+func forwardConnections(ctx context.Context, conn *session.Conn, pf *k8s.PortForward) error {
+	// stop tells the goroutines of the session it is over
+	stop := make(chan struct{})
+	defer close(stop)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type message struct {
+		channel byte
+		payload []byte
+	}
+	messages := make(chan message)
+	received := make(chan error, 1)
+	go func() {
+		for {
+			channel, payload, err := conn.Receive()
+			if err != nil {
+				received <- err
+				cancel()
+				return
+			}
+			select {
+			case messages <- message{channel, payload}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// Data for the pod, by connection ID, is written in the order it came
+	// by a goroutine of each connection
+	writes := make(map[uint32]*forwarded)
+	defer func() {
+		for _, f := range writes {
+			close(f.data)
+		}
+	}()
+	closed := make(chan *forwarded)
+
+	for {
+		select {
+		case <-ctx.Done():
+			if len(received) > 0 {
+				return errClientGone
+			}
+			return ctx.Err()
+		case <-pf.Done():
+			return errors.New("lost connection to the pod")
+
+		case f := <-closed:
+			// The client may have opened another connection with the ID
+			if writes[f.id] == f {
+				close(f.data)
+				delete(writes, f.id)
+			}
+
+		case m := <-messages:
+			id, payload, err := session.ParseForward(m.payload)
+			if err != nil {
+				continue
+			}
+
+			switch m.channel {
+			case session.ForwardOpen:
+				if _, ok := writes[id]; ok {
+					conn.SendForward(session.ForwardClose, id, []byte("connection ID in use"))
+					continue
+				}
+				podConn, err := pf.Dial()
+				if err != nil {
+					conn.SendForward(session.ForwardClose, id, []byte(err.Error()))
+					continue
+				}
+				f := &forwarded{id: id, pod: podConn, data: make(chan []byte, forwardBuffer)}
+				writes[id] = f
+				go forwardFromPod(conn, f, closed, stop)
+				go forwardToPod(f)
+
+			case session.ForwardData:
+				f, ok := writes[id]
+				if !ok {
+					continue
+				}
+				select {
+				case f.data <- payload:
+				default:
+					// Waiting for a pod that doesn't keep up would hold up
+					// every other connection, so this one is closed, which
+					// forwardFromPod reports to the client
+					f.slow.Store(true)
+					f.pod.Close()
+					close(f.data)
+					delete(writes, id)
+				}
+
+			case session.ForwardClose:
+				if f, ok := writes[id]; ok {
+					close(f.data)
+					delete(writes, id)
+				}
+			}
+		}
+	}
+}
+
+// forwarded is a connection the client opened to the pod
+type forwarded struct {
+	id  uint32
+	pod *k8s.ForwardedConn
+	// data is the client's data for the pod, closed once the client closed
+	// its side of the connection
+	data chan []byte
+	// slow is set when the connection was closed because the pod didn't
+	// take the client's data fast enough
+	slow atomic.Bool
+}
+
+// forwardFromPod sends what the pod writes to a connection to the client,
+// then tells the client the connection is closed
+func forwardFromPod(conn *session.Conn, f *forwarded, closed chan<- *forwarded, stop <-chan struct{}) {
+	defer f.pod.Close()
+
+	var reason []byte
+	_, err := io.Copy(conn.ForwardWriter(f.id), f.pod)
+	switch {
+	case f.slow.Load():
+		reason = []byte("the pod didn't keep up with the data sent to it")
+	case err != nil:
+		reason = []byte(err.Error())
+	}
+	conn.SendForward(session.ForwardClose, f.id, reason)
+
+	select {
+	case closed <- f:
+	case <-stop:
+	}
+}
+
+// forwardToPod writes the client's data of a connection to the pod until
+// the client closes its side of it
+func forwardToPod(f *forwarded) {
+	var err error
+	for payload := range f.data {
+		// Data after a failed write is dropped, and the connection closed,
+		// which forwardFromPod reports to the client
+		if err != nil {
+			continue
+		}
+		if _, err = f.pod.Write(payload); err != nil {
+			f.pod.Close()
+		}
+	}
+	if err == nil {
+		f.pod.CloseWrite()
+	}
+}
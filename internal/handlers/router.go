@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/cors"
+	"github.com/superfly/superfly/internal/auth"
+	"github.com/superfly/superfly/internal/logging"
+	"github.com/superfly/superfly/internal/openapi"
+	"github.com/superfly/superfly/internal/service"
+	"github.com/superfly/superfly/internal/tracing"
+)
+
+// RouterOptions are what the routes of the API are served by
+type RouterOptions struct {
+	Logger *slog.Logger
+	// Doc describes every route; it also validates request bodies
+	Doc *openapi.Document
+
+	// Tokens is nil when the API is open to everyone
+	Tokens          *auth.Tokens
+	ExecRole        auth.Role
+	PortForwardRole auth.Role
+	Idempotency     *service.IdempotencyService
+
+	Apps     *AppHandlers
+	Health   *HealthHandlers
+	Sync     *SyncHandlers
+	Clusters *ClusterHandlers
+	Sizes    *SizeHandlers
+	Events   *EventHandlers
+}
+
+// NewRouter routes every endpoint of the API. The routes are the ones
+// opts.Doc describes, which openapi.Document.CheckRoutes verifies.
+func NewRouter(opts RouterOptions) chi.Router {
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP)
+	r.Use(tracing.Middleware)
+	r.Use(logging.Middleware(opts.Logger))
+	r.Use(middleware.Recoverer)
+
+	// CORS
+	r.Use(cors.Handler(cors.Options{
+		AllowedOrigins:   []string{"http://localhost:*", "http://127.0.0.1:*"},
+		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match", IdempotencyKeyHeader},
+		ExposedHeaders:   []string{"ETag", "Link", IdempotentReplayedHeader},
+		AllowCredentials: true,
+		MaxAge:           300,
+	}))
+
+	// Requests time out, except event streams and sessions, which stay
+	// open as long as their clients do
+	timeout := middleware.Timeout(60 * time.Second)
+
+	// Health check routes
+	r.Group(func(r chi.Router) {
+		r.Use(timeout)
+		r.Get("/health", opts.Health.Health)
+		r.Get("/ready", opts.Health.Ready)
+		r.Get("/livez", opts.Health.Livez)
+	})
+
+	// API routes
+	r.Route("/api", func(r chi.Router) {
+		r.Use(Authenticate(opts.Tokens))
+		r.Use(Idempotency(opts.Idempotency))
+
+		r.Get("/events/stream", opts.Events.StreamEvents)
+		r.Group(func(r chi.Router) {
+			r.Use(timeout)
+			r.Get("/openapi.json", ServeOpenAPI(opts.Doc))
+			r.Post("/apply", opts.Apps.Apply)
+			r.Get("/sync", opts.Sync.GetStatus)
+			r.Post("/sync", opts.Sync.Trigger)
+			r.Get("/clusters", opts.Clusters.ListClusters)
+			r.Get("/clusters/{name}", opts.Clusters.GetCluster)
+			r.Get("/sizes", opts.Sizes.ListSizes)
+		})
+
+		r.Route("/apps", func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(timeout)
+				r.Get("/", opts.Apps.ListApps)
+				r.With(ValidateRequest(opts.Doc, "createApp")).Post("/", opts.Apps.CreateApp)
+			})
+
+			// {id} is an app UUID or slug
+			r.Route("/{id}", func(r chi.Router) {
+				r.Use(opts.Apps.AppCtx)
+				r.Get("/events/stream", opts.Events.StreamAppEvents)
+				r.With(RequireToken, RequireRole(opts.ExecRole)).Get("/exec", opts.Apps.Exec)
+				r.With(RequireToken, RequireRole(opts.PortForwardRole)).Get("/port-forward", opts.Apps.PortForward)
+				r.Group(func(r chi.Router) {
+					r.Use(timeout)
+					r.Get("/", opts.Apps.GetApp)
+					r.With(ValidateRequest(opts.Doc, "updateApp")).Patch("/", opts.Apps.UpdateApp)
+					r.Delete("/", opts.Apps.DeleteApp)
+					r.Post("/restart", opts.Apps.RestartApp)
+					r.Get("/manifest", opts.Apps.ExportApp)
+					r.Get("/pods", opts.Apps.ListPods)
+					r.Delete("/pods/{pod}", opts.Apps.DeletePod)
+					r.Get("/k8s-events", opts.Apps.ListKubernetesEvents)
+				})
+			})
+		})
+	})
+
+	return r
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/superfly/superfly/internal/service"
+)
+
+type SizeHandlers struct {
+	sizes *service.Sizes
+}
+
+func NewSizeHandlers(sizes *service.Sizes) *SizeHandlers {
+	return &SizeHandlers{
+		sizes: sizes,
+	}
+}
+
+// SizeResponse is a size of the catalog. Requests left out of the catalog
+// are shown as what pods get: half their limits.
+type SizeResponse struct {
+	Name                    string            `json:"name"`
+	CPURequest              string            `json:"cpu_request"`
+	CPULimit                string            `json:"cpu_limit"`
+	MemoryRequest           string            `json:"memory_request"`
+	MemoryLimit             string            `json:"memory_limit"`
+	EphemeralStorageRequest string            `json:"ephemeral_storage_request"`
+	EphemeralStorageLimit   string            `json:"ephemeral_storage_limit"`
+	NodeSelector            map[string]string `json:"node_selector"`
+	PricePerHour            float64           `json:"price_per_hour"`
+	Default                 bool              `json:"default"`
+}
+
+// ListSizesResponse is the body of GET /api/sizes
+type ListSizesResponse struct {
+	Sizes []SizeResponse `json:"sizes"`
+}
+
+// ListSizes handles GET /api/sizes. Without a catalog the list is empty.
+func (h *SizeHandlers) ListSizes(w http.ResponseWriter, r *http.Request) {
+	sizes := h.sizes.List()
+	response := ListSizesResponse{Sizes: make([]SizeResponse, 0, len(sizes))}
+	for _, size := range sizes {
+		nodeSelector := size.NodeSelector
+		if nodeSelector == nil {
+			nodeSelector = map[string]string{}
+		}
+		resources := size.Resources().WithDefaultRequests()
+		response.Sizes = append(response.Sizes, SizeResponse{
+			Name:                    size.Name,
+			CPURequest:              resources.CPURequest,
+			CPULimit:                resources.CPULimit,
+			MemoryRequest:           resources.MemoryRequest,
+			MemoryLimit:             resources.MemoryLimit,
+			EphemeralStorageRequest: resources.EphemeralStorageRequest,
+			EphemeralStorageLimit:   resources.EphemeralStorageLimit,
+			NodeSelector:            nodeSelector,
+			PricePerHour:            size.PricePerHour,
+			Default:                 size.Name == h.sizes.Default(),
+		})
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/superfly/superfly/internal/k8s"
+	"github.com/superfly/superfly/internal/service"
+)
+
+func TestListSizes(t *testing.T) {
+	catalog, err := service.NewSizes([]k8s.Size{
+		{Name: "small", CPULimit: "500m", MemoryLimit: "256Mi", PricePerHour: 0.004},
+		{Name: "large", CPURequest: "2", CPULimit: "2", MemoryLimit: "4Gi", NodeSelector: map[string]string{"pool": "performance"}},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		sizes *service.Sizes
+		want  string
+	}{
+		{name: "without a catalog", want: `{"sizes":[]}`},
+		{
+			name:  "with a catalog",
+			sizes: catalog,
+			want: `{"sizes":[` +
+				`{"name":"small","cpu_request":"250m","cpu_limit":"500m","memory_request":"128Mi","memory_limit":"256Mi",` +
+				`"ephemeral_storage_request":"","ephemeral_storage_limit":"","node_selector":{},"price_per_hour":0.004,"default":true},` +
+				`{"name":"large","cpu_request":"2","cpu_limit":"2","memory_request":"2Gi","memory_limit":"4Gi",` +
+				`"ephemeral_storage_request":"","ephemeral_storage_limit":"","node_selector":{"pool":"performance"},"price_per_hour":0,"default":false}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			NewSizeHandlers(tt.sizes).ListSizes(w, httptest.NewRequest(http.MethodGet, "/api/sizes", nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d", w.Code)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("got body\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
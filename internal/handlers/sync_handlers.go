@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/superfly/superfly/internal/gitops"
+)
+
+type SyncHandlers struct {
+	syncer *gitops.Syncer
+}
+
+func NewSyncHandlers(syncer *gitops.Syncer) *SyncHandlers {
+	return &SyncHandlers{
+		syncer: syncer,
+	}
+}
+
+// GetStatus handles GET /api/sync
+func (h *SyncHandlers) GetStatus(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, h.syncer.Status())
+}
+
+// Trigger handles POST /api/sync. The sync runs in the background; poll
+// GET /api/sync for its outcome.
+func (h *SyncHandlers) Trigger(w http.ResponseWriter, r *http.Request) {
+	if !h.syncer.Trigger() {
+		respondError(w, http.StatusConflict, CodeSyncDisabled, "gitops sync is not configured, set GITOPS_REPO_URL")
+		return
+	}
+	respondJSON(w, http.StatusAccepted, h.syncer.Status())
+}
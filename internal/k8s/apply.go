@@ -0,0 +1,119 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// FieldManager is the field manager superfly server-side applies objects
+// as. Kubernetes tracks the fields each manager sets, so fields set by
+// others, such as annotations cert-manager adds or sidecars injected into a
+// pod template, survive superfly's applies, while fields superfly stops
+// setting are removed.
+const FieldManager = "superfly"
+
+// applier is the Patch method of a typed client
+type applier[T any] interface {
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (T, error)
+}
+
+// object is an object of a typed client
+type object interface {
+	metav1.Object
+	runtime.Object
+}
+
+// serverSideApply applies obj, an object of kind gvk, as FieldManager.
+//
+// If another manager changed a field superfly sets, the apply conflicts.
+// yield, when given, is then passed the conflicts and may drop the fields
+// superfly leaves to their new manager from obj; superfly takes the rest
+// over with a forced apply, so the object ends up as the app describes it.
+func serverSideApply[T any](ctx context.Context, c *Client, api applier[T], obj object, gvk schema.GroupVersionKind, yield func(conflicts []applyConflict)) error {
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	logger := c.log(ctx).With("kind", gvk.Kind, "namespace", obj.GetNamespace(), "name", obj.GetName())
+
+	apply := func(force bool) error {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", gvk.Kind, err)
+		}
+		_, err = api.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: FieldManager,
+			Force:        &force,
+		})
+		return err
+	}
+
+	err := apply(false)
+	if errors.IsConflict(err) {
+		conflicts := applyConflicts(err)
+		if yield != nil {
+			yield(conflicts)
+		}
+		descriptions := make([]string, 0, len(conflicts))
+		for _, conflict := range conflicts {
+			descriptions = append(descriptions, conflict.Field+": "+conflict.Message)
+		}
+		logger.Warn("resolving conflicts with other field managers", "conflicts", descriptions)
+		err = apply(true)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply %s: %w", gvk.Kind, err)
+	}
+
+	logger.Info("applied object")
+	return nil
+}
+
+// applyConflict is a field another manager changed since superfly set it
+type applyConflict struct {
+	Field string
+	// Manager is the name of the other manager, or empty when the message
+	// doesn't name it
+	Manager string
+	Message string
+}
+
+// applyConflicts returns the conflicting fields of an apply conflict
+func applyConflicts(err error) []applyConflict {
+	status, ok := err.(errors.APIStatus)
+	if !ok || status.Status().Details == nil {
+		return nil
+	}
+	var conflicts []applyConflict
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		conflicts = append(conflicts, applyConflict{
+			Field:   cause.Field,
+			Manager: conflictManager(cause.Message),
+			Message: cause.Message,
+		})
+	}
+	return conflicts
+}
+
+// conflictManager returns the manager a conflict message such as
+// `conflict with "kubectl" with subresource "scale" using apps/v1` names
+func conflictManager(message string) string {
+	quoted, err := strconv.QuotedPrefix(strings.TrimPrefix(message, "conflict with "))
+	if err != nil {
+		return ""
+	}
+	manager, err := strconv.Unquote(quoted)
+	if err != nil {
+		return ""
+	}
+	return manager
+}
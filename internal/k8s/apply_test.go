@@ -0,0 +1,45 @@
+package k8s
+
+import (
+	"slices"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConflictManager(t *testing.T) {
+	tests := map[string]string{
+		`conflict with "kube-controller-manager" with subresource "scale" using apps/v1 at 2026-03-01T12:00:00Z`: "kube-controller-manager",
+		`conflict with "kubectl" with subresource "scale" using apps/v1`:                                         "kubectl",
+		`conflict with "helm"`:                 "helm",
+		`conflict with "a \"quoted\" manager"`: `a "quoted" manager`,
+		"conflict with someone":                "",
+		"":                                     "",
+	}
+	for message, want := range tests {
+		if got := conflictManager(message); got != want {
+			t.Errorf("conflictManager(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
+
+func TestApplyConflicts(t *testing.T) {
+	err := errors.NewApplyConflict([]metav1.StatusCause{
+		{Type: metav1.CauseTypeFieldManagerConflict, Field: ".spec.replicas", Message: `conflict with "kube-controller-manager" with subresource "scale" using apps/v1`},
+		{Type: metav1.CauseTypeFieldValueInvalid, Field: ".spec.template"},
+		{Type: metav1.CauseTypeFieldManagerConflict, Field: ".metadata.labels.team", Message: `conflict with "kubectl-label" using v1`},
+	}, "Apply failed with 2 conflicts")
+
+	got := applyConflicts(err)
+	want := []applyConflict{
+		{Field: ".spec.replicas", Manager: "kube-controller-manager", Message: `conflict with "kube-controller-manager" with subresource "scale" using apps/v1`},
+		{Field: ".metadata.labels.team", Manager: "kubectl-label", Message: `conflict with "kubectl-label" using v1`},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got conflicts %+v, want %+v", got, want)
+	}
+	if got := applyConflicts(errors.NewBadRequest("bad")); got != nil {
+		t.Errorf("got conflicts %+v from an error without any", got)
+	}
+}
@@ -2,36 +2,49 @@ package k8s
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"slices"
 	"time"
 
+	"github.com/superfly/superfly/internal/logging"
+	"github.com/superfly/superfly/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-const (
-	AppsNamespace = "superfly-apps"
-)
-
+// Client talks to the Kubernetes API of one cluster
 type Client struct {
-	clientset *kubernetes.Clientset
+	cluster   string
+	config    *rest.Config
+	clientset kubernetes.Interface
+	quota     NamespaceQuota
+	logger    *slog.Logger
 }
 
-// NewClient creates a new Kubernetes client
-func NewClient(inCluster bool, kubeconfig string) (*Client, error) {
+// NewClient creates a new Kubernetes client for a cluster. quota is applied
+// to every namespace the client ensures.
+func NewClient(cluster ClusterConfig, quota NamespaceQuota, logger *slog.Logger) (*Client, error) {
 	var config *rest.Config
 	var err error
 
-	if inCluster {
+	if cluster.InCluster {
 		config, err = rest.InClusterConfig()
 	} else {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		config, err = clientcmd.BuildConfigFromFlags("", cluster.Kubeconfig)
 	}
 
 	if err != nil {
@@ -43,110 +56,176 @@ func NewClient(inCluster bool, kubeconfig string) (*Client, error) {
 		return nil, fmt.Errorf("failed to create k8s clientset: %w", err)
 	}
 
-	return &Client{clientset: clientset}, nil
+	return &Client{
+		cluster:   cluster.Name,
+		config:    config,
+		clientset: clientset,
+		quota:     quota,
+		logger:    logger.With("cluster", cluster.Name),
+	}, nil
 }
 
-// EnsureNamespace creates the apps namespace if it doesn't exist
-func (c *Client) EnsureNamespace(ctx context.Context) error {
-	ns := &corev1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: AppsNamespace,
-		},
-	}
+// Cluster returns the name of the client's cluster
+func (c *Client) Cluster() string {
+	return c.cluster
+}
 
-	_, err := c.clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
-	if err != nil && !errors.IsAlreadyExists(err) {
-		return fmt.Errorf("failed to create namespace: %w", err)
-	}
+// startSpan starts a client span for operation op on the named object
+func (c *Client) startSpan(ctx context.Context, op, namespace, name string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "k8s."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.K8SClusterName(c.cluster),
+			semconv.K8SNamespaceName(namespace),
+			attribute.String("k8s.object.name", name),
+		),
+	)
+}
 
-	return nil
+// log returns the request-scoped logger from ctx, falling back to the client logger
+func (c *Client) log(ctx context.Context) *slog.Logger {
+	return logging.FromContext(ctx, c.logger)
 }
 
-// ApplyDeployment creates or updates a Deployment
-func (c *Client) ApplyDeployment(ctx context.Context, deployment *appsv1.Deployment) error {
-	deploymentsClient := c.clientset.AppsV1().Deployments(AppsNamespace)
+// EnsureNamespace applies an apps namespace with its labels, ResourceQuota
+// and LimitRange. Namespaces created before they were labeled, such as the
+// original superfly-apps, are adopted.
+func (c *Client) EnsureNamespace(ctx context.Context, name string) (err error) {
+	ctx, span := c.startSpan(ctx, "EnsureNamespace", name, name)
+	defer func() { tracing.End(span, err) }()
 
-	existing, err := deploymentsClient.Get(ctx, deployment.Name, metav1.GetOptions{})
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// Create new deployment
-			_, err = deploymentsClient.Create(ctx, deployment, metav1.CreateOptions{})
-			if err != nil {
-				return fmt.Errorf("failed to create deployment: %w", err)
-			}
-			return nil
-		}
-		return fmt.Errorf("failed to get deployment: %w", err)
+	namespaces := c.clientset.CoreV1().Namespaces()
+	if err := serverSideApply(ctx, c, namespaces, BuildNamespace(name), corev1.SchemeGroupVersion.WithKind("Namespace"), nil); err != nil {
+		return err
 	}
 
-	// Update existing deployment
-	deployment.ResourceVersion = existing.ResourceVersion
-	_, err = deploymentsClient.Update(ctx, deployment, metav1.UpdateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to update deployment: %w", err)
+	// A quota or limit lifted since the namespace was created is removed
+	quotas := c.clientset.CoreV1().ResourceQuotas(name)
+	if quota := BuildResourceQuota(name, c.quota); quota != nil {
+		if err := serverSideApply(ctx, c, quotas, quota, corev1.SchemeGroupVersion.WithKind("ResourceQuota"), nil); err != nil {
+			return err
+		}
+	} else if err := quotas.Delete(ctx, resourceQuotaName, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete resource quota: %w", err)
+	}
+	limitRanges := c.clientset.CoreV1().LimitRanges(name)
+	if limits := BuildLimitRange(name, c.quota); limits != nil {
+		if err := serverSideApply(ctx, c, limitRanges, limits, corev1.SchemeGroupVersion.WithKind("LimitRange"), nil); err != nil {
+			return err
+		}
+	} else if err := limitRanges.Delete(ctx, limitRangeName, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete limit range: %w", err)
 	}
-
 	return nil
 }
 
-// ApplyService creates or updates a Service
-func (c *Client) ApplyService(ctx context.Context, service *corev1.Service) error {
-	servicesClient := c.clientset.CoreV1().Services(AppsNamespace)
+// NamespaceExists verifies an apps namespace is present
+func (c *Client) NamespaceExists(ctx context.Context, name string) (err error) {
+	ctx, span := c.startSpan(ctx, "NamespaceExists", name, name)
+	defer func() { tracing.End(span, err) }()
 
-	existing, err := servicesClient.Get(ctx, service.Name, metav1.GetOptions{})
+	_, err = c.clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		if errors.IsNotFound(err) {
-			// Create new service
-			_, err = servicesClient.Create(ctx, service, metav1.CreateOptions{})
-			if err != nil {
-				return fmt.Errorf("failed to create service: %w", err)
-			}
-			return nil
-		}
-		return fmt.Errorf("failed to get service: %w", err)
+		return fmt.Errorf("failed to get namespace: %w", err)
 	}
+	return nil
+}
+
+// ServerVersion returns the Kubernetes API server version (e.g. "v1.29.0")
+func (c *Client) ServerVersion(ctx context.Context) (_ string, err error) {
+	ctx, span := c.startSpan(ctx, "ServerVersion", "", "")
+	defer func() { tracing.End(span, err) }()
 
-	// Update existing service (preserve ClusterIP)
-	service.ResourceVersion = existing.ResourceVersion
-	service.Spec.ClusterIP = existing.Spec.ClusterIP
-	_, err = servicesClient.Update(ctx, service, metav1.UpdateOptions{})
+	// The discovery client's ServerVersion does not accept a context, so
+	// query /version directly to honor cancellation
+	body, err := c.clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
 	if err != nil {
-		return fmt.Errorf("failed to update service: %w", err)
+		return "", fmt.Errorf("failed to get server version: %w", err)
 	}
 
-	return nil
+	var info version.Info
+	if err := json.Unmarshal(body, &info); err != nil {
+		return "", fmt.Errorf("failed to decode server version: %w", err)
+	}
+	return info.GitVersion, nil
 }
 
-// ApplyIngress creates or updates an Ingress
-func (c *Client) ApplyIngress(ctx context.Context, ingress *networkingv1.Ingress) error {
-	ingressClient := c.clientset.NetworkingV1().Ingresses(AppsNamespace)
+// autoscalerManagers are the field managers that scale Deployments on
+// their own. HorizontalPodAutoscalers, including those KEDA creates, scale
+// as the controller manager.
+var autoscalerManagers = []string{"kube-controller-manager"}
+
+// ReplicasAutoscaled reports whether an autoscaler owns the replica count
+// of a Deployment, which ApplyDeployment then leaves to it
+func ReplicasAutoscaled(deployment *appsv1.Deployment) bool {
+	for _, entry := range deployment.ManagedFields {
+		if !slices.Contains(autoscalerManagers, entry.Manager) || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields struct {
+			Spec struct {
+				Replicas json.RawMessage `json:"f:replicas"`
+			} `json:"f:spec"`
+		}
+		if json.Unmarshal(entry.FieldsV1.Raw, &fields) == nil && fields.Spec.Replicas != nil {
+			return true
+		}
+	}
+	return false
+}
 
-	existing, err := ingressClient.Get(ctx, ingress.Name, metav1.GetOptions{})
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// Create new ingress
-			_, err = ingressClient.Create(ctx, ingress, metav1.CreateOptions{})
-			if err != nil {
-				return fmt.Errorf("failed to create ingress: %w", err)
+// ApplyDeployment server-side applies a Deployment. A replica count an
+// autoscaler changed is left to it; one changed by anything else, such as
+// kubectl scale, is set back to the app's.
+func (c *Client) ApplyDeployment(ctx context.Context, deployment *appsv1.Deployment) (err error) {
+	ctx, span := c.startSpan(ctx, "ApplyDeployment", deployment.Namespace, deployment.Name)
+	defer func() { tracing.End(span, err) }()
+
+	deployments := c.clientset.AppsV1().Deployments(deployment.Namespace)
+	yieldReplicas := func(conflicts []applyConflict) {
+		for _, conflict := range conflicts {
+			if conflict.Field == ".spec.replicas" && slices.Contains(autoscalerManagers, conflict.Manager) {
+				deployment.Spec.Replicas = nil
 			}
-			return nil
 		}
-		return fmt.Errorf("failed to get ingress: %w", err)
 	}
+	return serverSideApply(ctx, c, deployments, deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"), yieldReplicas)
+}
 
-	// Update existing ingress
-	ingress.ResourceVersion = existing.ResourceVersion
-	_, err = ingressClient.Update(ctx, ingress, metav1.UpdateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to update ingress: %w", err)
-	}
+// ApplyService server-side applies a Service. The cluster IP and node ports
+// Kubernetes allocated are kept, since superfly doesn't set them.
+func (c *Client) ApplyService(ctx context.Context, service *corev1.Service) (err error) {
+	ctx, span := c.startSpan(ctx, "ApplyService", service.Namespace, service.Name)
+	defer func() { tracing.End(span, err) }()
 
-	return nil
+	services := c.clientset.CoreV1().Services(service.Namespace)
+	return serverSideApply(ctx, c, services, service, corev1.SchemeGroupVersion.WithKind("Service"), nil)
+}
+
+// ApplyIngress server-side applies an Ingress
+func (c *Client) ApplyIngress(ctx context.Context, ingress *networkingv1.Ingress) (err error) {
+	ctx, span := c.startSpan(ctx, "ApplyIngress", ingress.Namespace, ingress.Name)
+	defer func() { tracing.End(span, err) }()
+
+	ingresses := c.clientset.NetworkingV1().Ingresses(ingress.Namespace)
+	return serverSideApply(ctx, c, ingresses, ingress, networkingv1.SchemeGroupVersion.WithKind("Ingress"), nil)
+}
+
+// ApplyNetworkPolicy server-side applies a NetworkPolicy
+func (c *Client) ApplyNetworkPolicy(ctx context.Context, policy *networkingv1.NetworkPolicy) (err error) {
+	ctx, span := c.startSpan(ctx, "ApplyNetworkPolicy", policy.Namespace, policy.Name)
+	defer func() { tracing.End(span, err) }()
+
+	policies := c.clientset.NetworkingV1().NetworkPolicies(policy.Namespace)
+	return serverSideApply(ctx, c, policies, policy, networkingv1.SchemeGroupVersion.WithKind("NetworkPolicy"), nil)
 }
 
 // DeleteDeployment deletes a Deployment
-func (c *Client) DeleteDeployment(ctx context.Context, name string) error {
-	err := c.clientset.AppsV1().Deployments(AppsNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (c *Client) DeleteDeployment(ctx context.Context, namespace, name string) (err error) {
+	ctx, span := c.startSpan(ctx, "DeleteDeployment", namespace, name)
+	defer func() { tracing.End(span, err) }()
+
+	err = c.clientset.AppsV1().Deployments(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil && !errors.IsNotFound(err) {
 		return fmt.Errorf("failed to delete deployment: %w", err)
 	}
@@ -154,8 +233,11 @@ func (c *Client) DeleteDeployment(ctx context.Context, name string) error {
 }
 
 // DeleteService deletes a Service
-func (c *Client) DeleteService(ctx context.Context, name string) error {
-	err := c.clientset.CoreV1().Services(AppsNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (c *Client) DeleteService(ctx context.Context, namespace, name string) (err error) {
+	ctx, span := c.startSpan(ctx, "DeleteService", namespace, name)
+	defer func() { tracing.End(span, err) }()
+
+	err = c.clientset.CoreV1().Services(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil && !errors.IsNotFound(err) {
 		return fmt.Errorf("failed to delete service: %w", err)
 	}
@@ -163,66 +245,127 @@ func (c *Client) DeleteService(ctx context.Context, name string) error {
 }
 
 // DeleteIngress deletes an Ingress
-func (c *Client) DeleteIngress(ctx context.Context, name string) error {
-	err := c.clientset.NetworkingV1().Ingresses(AppsNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (c *Client) DeleteIngress(ctx context.Context, namespace, name string) (err error) {
+	ctx, span := c.startSpan(ctx, "DeleteIngress", namespace, name)
+	defer func() { tracing.End(span, err) }()
+
+	err = c.clientset.NetworkingV1().Ingresses(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil && !errors.IsNotFound(err) {
 		return fmt.Errorf("failed to delete ingress: %w", err)
 	}
 	return nil
 }
 
-// GetDeploymentStatus gets the status of a deployment
-func (c *Client) GetDeploymentStatus(ctx context.Context, name string) (*appsv1.DeploymentStatus, error) {
-	deployment, err := c.clientset.AppsV1().Deployments(AppsNamespace).Get(ctx, name, metav1.GetOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get deployment: %w", err)
+// DeleteNetworkPolicy deletes a NetworkPolicy
+func (c *Client) DeleteNetworkPolicy(ctx context.Context, namespace, name string) (err error) {
+	ctx, span := c.startSpan(ctx, "DeleteNetworkPolicy", namespace, name)
+	defer func() { tracing.End(span, err) }()
+
+	err = c.clientset.NetworkingV1().NetworkPolicies(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete network policy: %w", err)
 	}
-	return &deployment.Status, nil
+	return nil
 }
 
-// WaitForDeployment waits for a deployment to be ready
-func (c *Client) WaitForDeployment(ctx context.Context, name string, timeout time.Duration) error {
-	deadline := time.Now().Add(timeout)
+// PruneAliasServices deletes the alias Services of an app except those
+// named in keep
+func (c *Client) PruneAliasServices(ctx context.Context, namespace, slug string, keep []string) (err error) {
+	ctx, span := c.startSpan(ctx, "PruneAliasServices", namespace, slug)
+	defer func() { tracing.End(span, err) }()
 
-	for time.Now().Before(deadline) {
-		status, err := c.GetDeploymentStatus(ctx, name)
-		if err != nil {
-			return err
-		}
+	servicesClient := c.clientset.CoreV1().Services(namespace)
 
-		// Check if deployment is ready
-		if status.ReadyReplicas > 0 && status.ReadyReplicas == status.Replicas {
-			return nil
-		}
+	services, err := servicesClient.List(ctx, metav1.ListOptions{LabelSelector: AliasOfLabel + "=" + slug})
+	if err != nil {
+		return fmt.Errorf("failed to list alias services: %w", err)
+	}
 
-		// Wait before checking again
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(2 * time.Second):
-			// Continue loop
+	for _, service := range services.Items {
+		if slices.Contains(keep, service.Name) {
+			continue
 		}
+		err := servicesClient.Delete(ctx, service.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete alias service %s: %w", service.Name, err)
+		}
+		c.log(ctx).Info("deleted alias service", "namespace", namespace, "name", service.Name)
+	}
+	return nil
+}
+
+// DeploymentNamespaces lists the namespaces that have a Deployment of the
+// app with the given slug
+func (c *Client) DeploymentNamespaces(ctx context.Context, slug string) (_ []string, err error) {
+	ctx, span := c.startSpan(ctx, "DeploymentNamespaces", metav1.NamespaceAll, slug)
+	defer func() { tracing.End(span, err) }()
+
+	deployments, err := c.clientset.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: AppLabel + "=" + slug,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+
+	namespaces := make([]string, 0, len(deployments.Items))
+	for _, deployment := range deployments.Items {
+		namespaces = append(namespaces, deployment.Namespace)
 	}
+	return namespaces, nil
+}
+
+// GetDeployment gets a deployment
+func (c *Client) GetDeployment(ctx context.Context, namespace, name string) (_ *appsv1.Deployment, err error) {
+	ctx, span := c.startSpan(ctx, "GetDeployment", namespace, name)
+	defer func() { tracing.End(span, err) }()
 
-	return fmt.Errorf("timeout waiting for deployment to be ready")
+	deployment, err := c.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	return deployment, nil
 }
 
-// RestartDeployment restarts a deployment by updating its restart annotation
-func (c *Client) RestartDeployment(ctx context.Context, name string) error {
-	deployment, err := c.clientset.AppsV1().Deployments(AppsNamespace).Get(ctx, name, metav1.GetOptions{})
+// GetIngress gets an ingress
+func (c *Client) GetIngress(ctx context.Context, namespace, name string) (_ *networkingv1.Ingress, err error) {
+	ctx, span := c.startSpan(ctx, "GetIngress", namespace, name)
+	defer func() { tracing.End(span, err) }()
+
+	ingress, err := c.clientset.NetworkingV1().Ingresses(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to get deployment: %w", err)
+		return nil, fmt.Errorf("failed to get ingress: %w", err)
 	}
+	return ingress, nil
+}
 
-	if deployment.Spec.Template.Annotations == nil {
-		deployment.Spec.Template.Annotations = make(map[string]string)
+// RestartDeployment restarts a deployment by patching its restart
+// annotation, as kubectl rollout restart does
+func (c *Client) RestartDeployment(ctx context.Context, namespace, name string) (err error) {
+	ctx, span := c.startSpan(ctx, "RestartDeployment", namespace, name)
+	defer func() { tracing.End(span, err) }()
+
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"template": map[string]any{
+				"metadata": map[string]any{
+					"annotations": map[string]string{
+						"superfly.dev/restartedAt": time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode restart patch: %w", err)
 	}
-	deployment.Spec.Template.Annotations["superfly.dev/restartedAt"] = time.Now().Format(time.RFC3339)
 
-	_, err = c.clientset.AppsV1().Deployments(AppsNamespace).Update(ctx, deployment, metav1.UpdateOptions{})
+	_, err = c.clientset.AppsV1().Deployments(namespace).Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{
+		FieldManager: FieldManager,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to restart deployment: %w", err)
 	}
+	c.log(ctx).Info("restarted deployment", "namespace", namespace, "name", name)
 
 	return nil
 }
@@ -0,0 +1,107 @@
+package k8s
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ClusterConfig is an entry of the clusters registry: a cluster apps can be
+// deployed to, and how to reach it
+type ClusterConfig struct {
+	Name string `toml:"name"`
+	// Kubeconfig is the path of the cluster's kubeconfig file, unless
+	// InCluster is set
+	Kubeconfig string `toml:"kubeconfig"`
+	// InCluster uses the service account of the pod the API runs in
+	InCluster bool `toml:"in_cluster"`
+	// Labels describe the cluster, such as its region
+	Labels map[string]string `toml:"labels"`
+}
+
+// LoadClusters reads a clusters registry, a TOML file of [[cluster]] tables:
+//
+//	[[cluster]]
+//	name = "production"
+//	kubeconfig = "/etc/superfly/production.kubeconfig"
+//	labels = { region = "eu-west-1" }
+func LoadClusters(path string) ([]ClusterConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clusters file: %w", err)
+	}
+
+	var registry struct {
+		Clusters []ClusterConfig `toml:"cluster"`
+	}
+	meta, err := toml.Decode(string(data), &registry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse clusters file: %w", err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, fmt.Errorf("unknown keys in clusters file: %s", strings.Join(keys, ", "))
+	}
+	return registry.Clusters, nil
+}
+
+// Pool holds a client for each cluster of the registry
+type Pool struct {
+	clusters       []ClusterConfig
+	clients        map[string]*Client
+	defaultCluster string
+}
+
+// NewPool creates a client for each cluster. defaultCluster is where apps
+// go unless they name a cluster, and must be one of clusters.
+func NewPool(clusters []ClusterConfig, defaultCluster string, quota NamespaceQuota, logger *slog.Logger) (*Pool, error) {
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("no clusters configured")
+	}
+
+	pool := &Pool{
+		clusters:       clusters,
+		clients:        make(map[string]*Client, len(clusters)),
+		defaultCluster: defaultCluster,
+	}
+	for _, cluster := range clusters {
+		if msgs := validation.IsDNS1123Label(cluster.Name); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid cluster name %q: %s", cluster.Name, strings.Join(msgs, ", "))
+		}
+		if _, ok := pool.clients[cluster.Name]; ok {
+			return nil, fmt.Errorf("duplicate cluster %s", cluster.Name)
+		}
+		client, err := NewClient(cluster, quota, logger)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+		pool.clients[cluster.Name] = client
+	}
+	if _, ok := pool.clients[defaultCluster]; !ok {
+		return nil, fmt.Errorf("default cluster %s is not in the registry", defaultCluster)
+	}
+	return pool, nil
+}
+
+// Get returns the client of the named cluster
+func (p *Pool) Get(name string) (*Client, bool) {
+	client, ok := p.clients[name]
+	return client, ok
+}
+
+// Default returns the name of the default cluster
+func (p *Pool) Default() string {
+	return p.defaultCluster
+}
+
+// Clusters lists the clusters of the registry in the order they were given
+func (p *Pool) Clusters() []ClusterConfig {
+	return p.clusters
+}
@@ -0,0 +1,180 @@
+package k8s
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+users:
+- name: test
+  user:
+    token: secret
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+`
+
+func TestLoadClusters(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []ClusterConfig
+		wantErr string
+	}{
+		{
+			name: "clusters",
+			data: `
+[[cluster]]
+name = "production"
+kubeconfig = "/etc/superfly/production.kubeconfig"
+labels = { region = "eu-west-1" }
+
+[[cluster]]
+name = "local"
+in_cluster = true
+`,
+			want: []ClusterConfig{
+				{Name: "production", Kubeconfig: "/etc/superfly/production.kubeconfig", Labels: map[string]string{"region": "eu-west-1"}},
+				{Name: "local", InCluster: true},
+			},
+		},
+		{
+			name: "no clusters",
+			data: "",
+		},
+		{
+			name:    "unknown key",
+			data:    "[[cluster]]\nname = \"production\"\nkubecfg = \"/etc/kubeconfig\"\n",
+			wantErr: "unknown keys in clusters file: cluster.kubecfg",
+		},
+		{
+			name:    "invalid toml",
+			data:    "[[cluster]\nname = \"production\"\n",
+			wantErr: "failed to parse clusters file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "clusters.toml")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := LoadClusters(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadClusters(filepath.Join(t.TempDir(), "clusters.toml"))
+		if err == nil || !strings.Contains(err.Error(), "failed to read clusters file") {
+			t.Errorf("got error %v, want a read error", err)
+		}
+	})
+}
+
+func TestNewPool(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(kubeconfig, []byte(testKubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name           string
+		clusters       []ClusterConfig
+		defaultCluster string
+		wantErr        string
+	}{
+		{
+			name:           "clusters",
+			clusters:       []ClusterConfig{{Name: "eu", Kubeconfig: kubeconfig}, {Name: "us", Kubeconfig: kubeconfig}},
+			defaultCluster: "us",
+		},
+		{
+			name:    "no clusters",
+			wantErr: "no clusters configured",
+		},
+		{
+			name:           "invalid name",
+			clusters:       []ClusterConfig{{Name: "EU_West", Kubeconfig: kubeconfig}},
+			defaultCluster: "EU_West",
+			wantErr:        `invalid cluster name "EU_West"`,
+		},
+		{
+			name:           "duplicate name",
+			clusters:       []ClusterConfig{{Name: "eu", Kubeconfig: kubeconfig}, {Name: "eu", Kubeconfig: kubeconfig}},
+			defaultCluster: "eu",
+			wantErr:        "duplicate cluster eu",
+		},
+		{
+			name:           "unreadable kubeconfig",
+			clusters:       []ClusterConfig{{Name: "eu", Kubeconfig: filepath.Join(t.TempDir(), "missing")}},
+			defaultCluster: "eu",
+			wantErr:        "cluster eu: failed to create k8s config",
+		},
+		{
+			name:           "default not in the registry",
+			clusters:       []ClusterConfig{{Name: "eu", Kubeconfig: kubeconfig}},
+			defaultCluster: "us",
+			wantErr:        "default cluster us is not in the registry",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := NewPool(tt.clusters, tt.defaultCluster, NamespaceQuota{}, logger)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if pool.Default() != tt.defaultCluster {
+				t.Errorf("got default %s, want %s", pool.Default(), tt.defaultCluster)
+			}
+			if !reflect.DeepEqual(pool.Clusters(), tt.clusters) {
+				t.Errorf("got clusters %+v, want %+v", pool.Clusters(), tt.clusters)
+			}
+			for _, cluster := range tt.clusters {
+				client, ok := pool.Get(cluster.Name)
+				if !ok || client.Cluster() != cluster.Name {
+					t.Errorf("no client for cluster %s", cluster.Name)
+				}
+			}
+			if _, ok := pool.Get("missing"); ok {
+				t.Error("got a client for a cluster not in the registry")
+			}
+		})
+	}
+}
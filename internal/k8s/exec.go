@@ -0,0 +1,110 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/superfly/superfly/internal/tracing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// TerminalSize is the width and height of a terminal, in characters
+type TerminalSize = remotecommand.TerminalSize
+
+// ExecOptions describe a command to run in a container of a pod
+type ExecOptions struct {
+	Pod string
+	// Container may be empty for pods with a single container
+	Container string
+	Command   []string
+	// TTY allocates a terminal for the command, which then writes everything
+	// to Stdout
+	TTY    bool
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Resize returns the size of the terminal each time it changes, and
+	// nil once it is closed. It is only used with TTY.
+	Resize func() *TerminalSize
+}
+
+// Exec runs a command in a container until it exits or ctx is done,
+// returning its exit code. The API server is spoken to over WebSocket if it
+// supports that, and SPDY otherwise.
+func (c *Client) Exec(ctx context.Context, namespace string, opts ExecOptions) (exitCode int, err error) {
+	ctx, span := c.startSpan(ctx, "Exec", namespace, opts.Pod)
+	defer func() { tracing.End(span, err) }()
+
+	if opts.TTY {
+		opts.Stderr = nil
+	}
+	req := c.clientset.CoreV1().RESTClient().Post().
+		Namespace(namespace).
+		Resource("pods").
+		Name(opts.Pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: opts.Container,
+			Command:   opts.Command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+
+	executor, err := c.executor(req.URL())
+	if err != nil {
+		return 0, err
+	}
+
+	streamOptions := remotecommand.StreamOptions{
+		Stdin:  opts.Stdin,
+		Stdout: opts.Stdout,
+		Stderr: opts.Stderr,
+		Tty:    opts.TTY,
+	}
+	if opts.TTY && opts.Resize != nil {
+		streamOptions.TerminalSizeQueue = terminalSizeQueue(opts.Resize)
+	}
+	err = executor.StreamWithContext(ctx, streamOptions)
+
+	// A command that ran and failed is not an error of the exec
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to exec in pod: %w", err)
+	}
+	return 0, nil
+}
+
+// executor streams to a subresource of a pod, such as exec, over WebSocket
+// with a fallback to SPDY, as kubectl does
+func (c *Client) executor(u *url.URL) (remotecommand.Executor, error) {
+	websocket, err := remotecommand.NewWebSocketExecutor(c.config, http.MethodGet, u.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create websocket executor: %w", err)
+	}
+	spdy, err := remotecommand.NewSPDYExecutor(c.config, http.MethodPost, u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spdy executor: %w", err)
+	}
+	return remotecommand.NewFallbackExecutor(websocket, spdy, httpstream.IsUpgradeFailure)
+}
+
+// terminalSizeQueue adapts a function to remotecommand.TerminalSizeQueue
+type terminalSizeQueue func() *TerminalSize
+
+func (q terminalSizeQueue) Next() *TerminalSize {
+	return q()
+}
@@ -0,0 +1,234 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Pod lifecycle reasons WatchPodEvents reports. All but ReasonOOMKilled are
+// the reasons of the Kubernetes events of a pod.
+const (
+	ReasonScheduled = "Scheduled"
+	ReasonPulled    = "Pulled"
+	ReasonStarted   = "Started"
+	ReasonKilling   = "Killing"
+	ReasonOOMKilled = "OOMKilled"
+)
+
+var podEventReasons = map[string]bool{
+	ReasonScheduled: true,
+	ReasonPulled:    true,
+	ReasonStarted:   true,
+	ReasonKilling:   true,
+}
+
+// maxPodCache bounds the pods WatchPodEvents remembers not to belong to an
+// app, which it learns from the events of every pod of the cluster
+const maxPodCache = 10000
+
+// PodEvent is a lifecycle event of a pod of an app
+type PodEvent struct {
+	// Key identifies the event, however often it is seen
+	Key       string
+	Namespace string
+	App       string
+	Pod       string
+	// Container is empty for events of the whole pod
+	Container string
+	Reason    string
+	Message   string
+	Time      time.Time
+}
+
+// WatchPodEvents watches the pods of apps in every namespace, calling handle
+// with their lifecycle events until ctx is done or a watch fails. Events
+// from before the watch started are reported too, and an event may be
+// reported more than once, always with the same Key.
+func (c *Client) WatchPodEvents(ctx context.Context, handle func(PodEvent)) error {
+	w := &podEventWatch{client: c, apps: make(map[string]string)}
+	return w.run(ctx, handle)
+}
+
+// podEventWatch follows the pods of apps and the events of every pod
+type podEventWatch struct {
+	client *Client
+	// apps maps the namespace/name of a pod to the slug of its app, which
+	// is empty for pods that aren't an app's
+	apps map[string]string
+}
+
+func (w *podEventWatch) run(ctx context.Context, handle func(PodEvent)) error {
+	pods := w.client.clientset.CoreV1().Pods(metav1.NamespaceAll)
+	events := w.client.clientset.CoreV1().Events(metav1.NamespaceAll)
+
+	// As in WatchRollout, closed watches are reopened without a resource
+	// version and catch up with an event for each existing object
+	var podEvents, eventEvents watch.Interface
+	defer func() {
+		if podEvents != nil {
+			podEvents.Stop()
+		}
+		if eventEvents != nil {
+			eventEvents.Stop()
+		}
+	}()
+
+	for {
+		var err error
+		if podEvents == nil {
+			podEvents, err = pods.Watch(ctx, metav1.ListOptions{LabelSelector: AppLabel})
+			if err != nil {
+				return fmt.Errorf("failed to watch pods: %w", err)
+			}
+		}
+		if eventEvents == nil {
+			eventEvents, err = events.Watch(ctx, metav1.ListOptions{
+				FieldSelector: fields.OneTermEqualSelector("involvedObject.kind", "Pod").String(),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to watch events: %w", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case event, ok := <-podEvents.ResultChan():
+			if !ok {
+				podEvents = nil
+				continue
+			}
+			if event.Type == watch.Error {
+				return fmt.Errorf("failed to watch pods: %w", errors.FromObject(event.Object))
+			}
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			if event.Type == watch.Deleted {
+				delete(w.apps, pod.Namespace+"/"+pod.Name)
+				continue
+			}
+			w.apps[pod.Namespace+"/"+pod.Name] = pod.Labels[AppLabel]
+			for _, e := range oomKills(pod) {
+				handle(e)
+			}
+
+		case event, ok := <-eventEvents.ResultChan():
+			if !ok {
+				eventEvents = nil
+				continue
+			}
+			if event.Type == watch.Error {
+				return fmt.Errorf("failed to watch events: %w", errors.FromObject(event.Object))
+			}
+			e, ok := event.Object.(*corev1.Event)
+			if !ok || event.Type == watch.Deleted || !podEventReasons[e.Reason] {
+				continue
+			}
+			app, err := w.podApp(ctx, e.InvolvedObject.Namespace, e.InvolvedObject.Name)
+			if err != nil {
+				return err
+			}
+			if app == "" {
+				continue
+			}
+			handle(PodEvent{
+				// Repeats of an event, such as a pull for each restart,
+				// update its count
+				Key:       fmt.Sprintf("event/%s/%d", e.UID, e.Count),
+				Namespace: e.InvolvedObject.Namespace,
+				App:       app,
+				Pod:       e.InvolvedObject.Name,
+				Container: fieldPathContainer(e.InvolvedObject.FieldPath),
+				Reason:    e.Reason,
+				Message:   e.Message,
+				Time:      eventTime(e),
+			})
+		}
+	}
+}
+
+// podApp returns the slug of the app a pod belongs to, or "" if it isn't
+// an app's or is gone. The pod watch usually has told already; otherwise,
+// such as for an event seen before its pod, the pod is looked up.
+func (w *podEventWatch) podApp(ctx context.Context, namespace, name string) (string, error) {
+	key := namespace + "/" + name
+	if app, ok := w.apps[key]; ok {
+		return app, nil
+	}
+
+	pod, err := w.client.clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod: %w", err)
+	}
+	if len(w.apps) >= maxPodCache {
+		clear(w.apps)
+	}
+	w.apps[key] = pod.Labels[AppLabel]
+	return pod.Labels[AppLabel], nil
+}
+
+// oomKills returns an event for each container of a pod that was killed
+// for running out of memory, whether it is still terminated or has since
+// been restarted
+func oomKills(pod *corev1.Pod) []PodEvent {
+	var events []PodEvent
+	for _, container := range pod.Status.ContainerStatuses {
+		for _, state := range []corev1.ContainerState{container.State, container.LastTerminationState} {
+			terminated := state.Terminated
+			if terminated == nil || terminated.Reason != ReasonOOMKilled {
+				continue
+			}
+			events = append(events, PodEvent{
+				// A container ID names one run of a container, so the
+				// kill is the same event once the container restarts
+				Key:       "oom/" + terminated.ContainerID,
+				Namespace: pod.Namespace,
+				App:       pod.Labels[AppLabel],
+				Pod:       pod.Name,
+				Container: container.Name,
+				Reason:    ReasonOOMKilled,
+				Message:   fmt.Sprintf("container exceeded its memory limit and was killed (exit code %d)", terminated.ExitCode),
+				Time:      terminated.FinishedAt.Time,
+			})
+		}
+	}
+	return events
+}
+
+// fieldPathContainer returns the container an event's field path, such as
+// spec.containers{app}, refers to
+func fieldPathContainer(fieldPath string) string {
+	start := strings.IndexByte(fieldPath, '{')
+	end := strings.LastIndexByte(fieldPath, '}')
+	if start < 0 || end < start {
+		return ""
+	}
+	return fieldPath[start+1 : end]
+}
+
+// eventTime returns when an event last happened, which depends on the API
+// version that recorded it
+func eventTime(e *corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.FirstTimestamp.Time
+	}
+}
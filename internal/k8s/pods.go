@@ -0,0 +1,168 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/superfly/superfly/internal/tracing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// podNameSuffixLength is the length of the random suffix Kubernetes adds to
+// the name of a pod a ReplicaSet creates
+const podNameSuffixLength = 5
+
+// ListPods lists the pods of an app, oldest first
+func (c *Client) ListPods(ctx context.Context, namespace, slug string) (_ []corev1.Pod, err error) {
+	ctx, span := c.startSpan(ctx, "ListPods", namespace, slug)
+	defer func() { tracing.End(span, err) }()
+
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: AppLabel + "=" + slug,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].CreationTimestamp.Before(&pods.Items[j].CreationTimestamp)
+	})
+	return pods.Items, nil
+}
+
+// maxAppEvents caps the events ListEvents returns, keeping the most recent
+const maxAppEvents = 200
+
+// eventListPageSize bounds the events read from the API server at once
+const eventListPageSize = 500
+
+// ListEvents lists the events of an app's Deployment, its ReplicaSets and
+// its pods, most recent last, up to maxAppEvents. Kubernetes keeps events
+// for an hour by default.
+func (c *Client) ListEvents(ctx context.Context, namespace, slug string) (_ []corev1.Event, err error) {
+	ctx, span := c.startSpan(ctx, "ListEvents", namespace, slug)
+	defer func() { tracing.End(span, err) }()
+
+	// ReplicaSets and pods carry the labels of the Deployment's pod template
+	selector := metav1.ListOptions{LabelSelector: AppLabel + "=" + slug}
+	replicaSets, err := c.clientset.AppsV1().ReplicaSets(namespace).List(ctx, selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replica sets: %w", err)
+	}
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	// The events of the Deployment and each ReplicaSet are selected by name
+	all := func(*corev1.Event) bool { return true }
+	appEvents, err := c.listEvents(ctx, namespace, involvedObject("Deployment", slug), all)
+	if err != nil {
+		return nil, err
+	}
+	for _, replicaSet := range replicaSets.Items {
+		events, err := c.listEvents(ctx, namespace, involvedObject("ReplicaSet", replicaSet.Name), all)
+		if err != nil {
+			return nil, err
+		}
+		appEvents = append(appEvents, events...)
+	}
+
+	// Events of pods that are gone are kept, since those are often the
+	// ones that explain a failure. Their names start with a ReplicaSet's,
+	// which a field selector can't match, so pod events are filtered here.
+	current := make(map[string]bool, len(pods.Items))
+	for _, pod := range pods.Items {
+		current[pod.Name] = true
+	}
+	events, err := c.listEvents(ctx, namespace, fields.OneTermEqualSelector("involvedObject.kind", "Pod"), func(event *corev1.Event) bool {
+		name := event.InvolvedObject.Name
+		return current[name] || replicaSetPod(replicaSets.Items, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	appEvents = append(appEvents, events...)
+
+	sort.SliceStable(appEvents, func(i, j int) bool {
+		return eventTime(&appEvents[i]).Before(eventTime(&appEvents[j]))
+	})
+	if len(appEvents) > maxAppEvents {
+		appEvents = appEvents[len(appEvents)-maxAppEvents:]
+	}
+	return appEvents, nil
+}
+
+// involvedObject selects the events of an object
+func involvedObject(kind, name string) fields.Selector {
+	return fields.Set{"involvedObject.kind": kind, "involvedObject.name": name}.AsSelector()
+}
+
+// listEvents lists the events of a namespace the selector matches, a page
+// at a time, keeping those keep returns true for
+func (c *Client) listEvents(ctx context.Context, namespace string, selector fields.Selector, keep func(*corev1.Event) bool) ([]corev1.Event, error) {
+	var kept []corev1.Event
+	opts := metav1.ListOptions{FieldSelector: selector.String(), Limit: eventListPageSize}
+	for {
+		events, err := c.clientset.CoreV1().Events(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list events: %w", err)
+		}
+		for i := range events.Items {
+			if keep(&events.Items[i]) {
+				kept = append(kept, events.Items[i])
+			}
+		}
+		if events.Continue == "" {
+			return kept, nil
+		}
+		opts.Continue = events.Continue
+	}
+}
+
+// replicaSetPod reports whether a pod name is that of a pod of one of the
+// ReplicaSets, which name their pods after themselves
+func replicaSetPod(replicaSets []appsv1.ReplicaSet, pod string) bool {
+	for _, replicaSet := range replicaSets {
+		prefix := replicaSet.Name + "-"
+		if len(pod) == len(prefix)+podNameSuffixLength && strings.HasPrefix(pod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeletePod deletes a pod of an app, which its ReplicaSet replaces. Pods
+// of other apps are reported as not found.
+func (c *Client) DeletePod(ctx context.Context, namespace, slug, name string) (err error) {
+	ctx, span := c.startSpan(ctx, "DeletePod", namespace, name)
+	defer func() { tracing.End(span, err) }()
+
+	pods := c.clientset.CoreV1().Pods(namespace)
+	pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod: %w", err)
+	}
+	if pod.Labels[AppLabel] != slug {
+		return errors.NewNotFound(corev1.Resource("pods"), name)
+	}
+
+	// Deleting the pod as it was read keeps a pod recreated under the same
+	// name in the meantime
+	err = pods.Delete(ctx, name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &pod.UID},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete pod: %w", err)
+	}
+
+	c.log(ctx).Info("deleted pod", "namespace", namespace, "name", name)
+	return nil
+}
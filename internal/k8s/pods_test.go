@@ -0,0 +1,35 @@
+package k8s
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReplicaSetPod(t *testing.T) {
+	replicaSets := []appsv1.ReplicaSet{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web-7d9c8f6b5"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "web-5f4b7c9d8"}},
+	}
+	tests := map[string]bool{
+		"web-7d9c8f6b5-x2k4f":     true,
+		"web-5f4b7c9d8-abcde":     true,
+		"web-7d9c8f6b5-x2k4":      false,
+		"web-7d9c8f6b5-x2k4f-abc": false,
+		"web-api-7d9c8f6b5-x2k4f": false,
+		"web-6a1b2c3d4-x2k4f":     false,
+	}
+	for pod, want := range tests {
+		if got := replicaSetPod(replicaSets, pod); got != want {
+			t.Errorf("replicaSetPod(%q) = %v, want %v", pod, got, want)
+		}
+	}
+}
+
+func TestInvolvedObject(t *testing.T) {
+	got := involvedObject("ReplicaSet", "web-7d9c8f6b5").String()
+	if want := "involvedObject.kind=ReplicaSet,involvedObject.name=web-7d9c8f6b5"; got != want {
+		t.Errorf("got selector %q, want %q", got, want)
+	}
+}
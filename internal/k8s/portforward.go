@@ -0,0 +1,142 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/superfly/superfly/internal/tracing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// PortForward forwards connections to a port of a pod over a single
+// connection to the API server, as kubectl port-forward does
+type PortForward struct {
+	conn      httpstream.Connection
+	port      string
+	requestID atomic.Int64
+}
+
+// PortForward connects to the API server to forward connections to a port
+// of a pod, which Dial opens. It must be closed with Close.
+func (c *Client) PortForward(ctx context.Context, namespace, pod string, port int) (_ *PortForward, err error) {
+	ctx, span := c.startSpan(ctx, "PortForward", namespace, pod)
+	defer func() { tracing.End(span, err) }()
+
+	transport, upgrader, err := spdy.RoundTripperFor(c.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spdy transport: %w", err)
+	}
+	u := c.clientset.CoreV1().RESTClient().Post().
+		Namespace(namespace).
+		Resource("pods").
+		Name(pod).
+		SubResource("portforward").
+		URL()
+	// spdy.NewDialer makes its request without a context, so connecting
+	// couldn't be cancelled
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create port forward request: %w", err)
+	}
+	conn, _, err := spdy.Negotiate(upgrader, &http.Client{Transport: transport}, req, portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to forward port: %w", err)
+	}
+
+	return &PortForward{conn: conn, port: strconv.Itoa(port)}, nil
+}
+
+// Done is closed when the connection to the API server is lost
+func (f *PortForward) Done() <-chan bool {
+	return f.conn.CloseChan()
+}
+
+// Close closes the connection to the API server, and every connection
+// forwarded over it
+func (f *PortForward) Close() error {
+	return f.conn.Close()
+}
+
+// Dial opens a connection to the port
+func (f *PortForward) Dial() (*ForwardedConn, error) {
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, f.port)
+	headers.Set(corev1.PortForwardRequestIDHeader, strconv.FormatInt(f.requestID.Add(1), 10))
+	errorStream, err := f.conn.CreateStream(headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create error stream: %w", err)
+	}
+	// Only the kubelet writes to the error stream
+	errorStream.Close()
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := f.conn.CreateStream(headers)
+	if err != nil {
+		f.conn.RemoveStreams(errorStream)
+		return nil, fmt.Errorf("failed to create data stream: %w", err)
+	}
+
+	conn := &ForwardedConn{forward: f, data: dataStream, errors: errorStream, done: make(chan struct{})}
+	go func() {
+		defer close(conn.done)
+		message, err := io.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			conn.err = fmt.Errorf("failed to read error stream: %w", err)
+		case len(message) > 0:
+			conn.err = errors.New(string(message))
+		}
+	}()
+	return conn, nil
+}
+
+// ForwardedConn is a connection to a port of a pod
+type ForwardedConn struct {
+	forward *PortForward
+	data    httpstream.Stream
+	errors  httpstream.Stream
+	// done is closed once the kubelet reported how the connection ended,
+	// with err if it failed, such as when nothing listens on the port
+	done chan struct{}
+	err  error
+}
+
+// Read reads from the pod. Once the pod closed the connection, Read returns
+// the error the connection failed with, if any.
+func (c *ForwardedConn) Read(p []byte) (int, error) {
+	n, err := c.data.Read(p)
+	if err != nil {
+		<-c.done
+		if c.err != nil {
+			return n, c.err
+		}
+	}
+	return n, err
+}
+
+// Write writes to the pod
+func (c *ForwardedConn) Write(p []byte) (int, error) {
+	return c.data.Write(p)
+}
+
+// CloseWrite tells the pod no more data follows, while reading goes on
+func (c *ForwardedConn) CloseWrite() error {
+	return c.data.Close()
+}
+
+// Close closes the connection
+func (c *ForwardedConn) Close() error {
+	err := c.data.Reset()
+	c.forward.conn.RemoveStreams(c.errors, c.data)
+	return err
+}
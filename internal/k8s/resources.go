@@ -1,6 +1,9 @@
 package k8s
 
 import (
+	"fmt"
+	"sort"
+
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
@@ -10,28 +13,207 @@ import (
 )
 
 type AppSpec struct {
-	Name            string
-	Slug            string
-	Image           string
-	Port            int32
-	Replicas        int32
-	CPULimit        string
-	MemoryLimit     string
-	Domain          string
-	HealthCheckPath string
-}
-
-// BuildDeployment creates a Deployment manifest for an app
-func BuildDeployment(spec AppSpec) *appsv1.Deployment {
+	Name      string
+	Slug      string
+	Namespace string
+	Image     string
+	Port      int32
+	Replicas  int32
+	Resources Resources
+	Domain    string
+	Env       []EnvVar
+
+	// Size, when set, decides the resources of the app container and the
+	// nodes its pods run on instead of Resources
+	Size *Size
+
+	// Ports of the app container, each exposed on the Service. Ports with
+	// a Path are routed by the Ingress.
+	Ports       []AppPort
+	ServiceType string
+
+	// Visibility decides whether the app gets an Ingress and a Service.
+	// Aliases are extra Service names; AllowFrom lists the slugs of the apps
+	// allowed to call the app.
+	Visibility string
+	Aliases    []string
+	AllowFrom  []string
+
+	// Egress limits the outgoing connections of the app; EgressCIDRs are
+	// the destinations of an allowlist
+	Egress      string
+	EgressCIDRs []string
+
+	// Probes of the app container; nil leaves the probe out
+	LivenessProbe  *Probe
+	ReadinessProbe *Probe
+	StartupProbe   *Probe
+}
+
+// Resources are the compute resources of the app container, as quantities
+// such as 500m or 256Mi. A request left empty is half its limit, and a
+// resource with neither is neither reserved nor capped.
+type Resources struct {
+	CPURequest              string
+	CPULimit                string
+	MemoryRequest           string
+	MemoryLimit             string
+	EphemeralStorageRequest string
+	EphemeralStorageLimit   string
+}
+
+// WithDefaultRequests returns the resources with each request left empty
+// set to half its limit, as the container gets them. Limits that don't
+// parse are left to buildResources to report.
+func (r Resources) WithDefaultRequests() Resources {
+	for _, res := range []struct {
+		name    corev1.ResourceName
+		request *string
+		limit   string
+	}{
+		{corev1.ResourceCPU, &r.CPURequest, r.CPULimit},
+		{corev1.ResourceMemory, &r.MemoryRequest, r.MemoryLimit},
+		{corev1.ResourceEphemeralStorage, &r.EphemeralStorageRequest, r.EphemeralStorageLimit},
+	} {
+		if *res.request != "" || res.limit == "" {
+			continue
+		}
+		limit, err := resource.ParseQuantity(res.limit)
+		if err != nil {
+			continue
+		}
+		half := HalfQuantity(res.name, limit)
+		*res.request = half.String()
+	}
+	return r
+}
+
+// EnvVar is an environment variable of the app container: a literal Value,
+// or a key of a Secret in the apps namespace when SecretName is set
+type EnvVar struct {
+	Name       string
+	Value      string
+	SecretName string
+	SecretKey  string
+}
+
+// Port protocols
+const (
+	ProtocolHTTP  = "http"
+	ProtocolHTTP2 = "http2"
+	ProtocolGRPC  = "grpc"
+	ProtocolTCP   = "tcp"
+	ProtocolUDP   = "udp"
+)
+
+// Service types
+const (
+	ServiceTypeClusterIP    = "cluster_ip"
+	ServiceTypeNodePort     = "node_port"
+	ServiceTypeLoadBalancer = "load_balancer"
+)
+
+// App visibilities
+const (
+	// VisibilityPublic apps are reachable through the Ingress when they
+	// have a domain, and from outside the cluster with a node_port or
+	// load_balancer Service
+	VisibilityPublic = "public"
+	// VisibilityInternal apps have a Service but no Ingress
+	VisibilityInternal = "internal"
+	// VisibilityNone apps, such as workers, accept no traffic at all
+	VisibilityNone = "none"
+)
+
+// Egress policies
+const (
+	// EgressAllowAll lets the app connect anywhere
+	EgressAllowAll = "allow_all"
+	// EgressDenyInternet limits the app to other apps
+	EgressDenyInternet = "deny_internet"
+	// EgressAllowlist limits the app to other apps and to EgressCIDRs
+	EgressAllowlist = "allowlist"
+)
+
+// DefaultDenyPolicyName names the NetworkPolicy that blocks all traffic of
+// an apps namespace not allowed by an app's own policy. Slugs can't
+// contain dots, so it never clashes with an app's policy.
+const DefaultDenyPolicyName = "superfly.default-deny"
+
+// ingressControllerNamespace is where Traefik runs. Its pods are the only
+// ones allowed to connect to apps besides their declared peers.
+const ingressControllerNamespace = "traefik"
+
+// AppLabel holds the slug of the app an object belongs to
+const AppLabel = "superfly.dev/app"
+
+// ManagedLabel marks the namespaces apps are deployed to
+const ManagedLabel = "superfly.dev/managed"
+
+// AliasOfLabel marks a Service as an alias of the app whose slug it holds
+const AliasOfLabel = "superfly.dev/alias-of"
+
+// serversSchemeAnnotation tells Traefik how to talk to a Service's pods.
+// Traefik reads it from the Service rather than the Ingress.
+const serversSchemeAnnotation = "traefik.ingress.kubernetes.io/service.serversscheme"
+
+// AppPort is a named port of the app container. NodePort is only used by
+// node_port and load_balancer Services; zero lets Kubernetes pick one.
+type AppPort struct {
+	Name          string
+	ContainerPort int32
+	Protocol      string
+	ServicePort   int32
+	NodePort      int32
+	Path          string
+}
+
+// Probe types
+const (
+	ProbeHTTP = "http"
+	ProbeTCP  = "tcp"
+	ProbeGRPC = "grpc"
+	ProbeExec = "exec"
+)
+
+// Probe is a container probe with every timing set. Type selects which of
+// the other fields are used: Path and Headers for http, Port for http, tcp
+// and grpc, GRPCService for grpc and Command for exec.
+type Probe struct {
+	Type                string
+	Path                string
+	Port                int32
+	Headers             map[string]string
+	GRPCService         string
+	Command             []string
+	InitialDelaySeconds int32
+	PeriodSeconds       int32
+	TimeoutSeconds      int32
+	SuccessThreshold    int32
+	FailureThreshold    int32
+}
+
+// BuildDeployment creates a Deployment manifest for an app, with the
+// resources of its size if it has one. It fails if a resource quantity is
+// invalid.
+func BuildDeployment(spec AppSpec) (*appsv1.Deployment, error) {
 	labels := map[string]string{
-		"app":              spec.Slug,
-		"superfly.dev/app": spec.Slug,
+		"app":    spec.Slug,
+		AppLabel: spec.Slug,
+	}
+	wanted, nodeSelector := spec.Resources, map[string]string(nil)
+	if spec.Size != nil {
+		wanted, nodeSelector = spec.Size.Resources(), spec.Size.NodeSelector
+	}
+	resources, err := buildResources(wanted)
+	if err != nil {
+		return nil, err
 	}
 
 	return &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      spec.Slug,
-			Namespace: AppsNamespace,
+			Namespace: spec.Namespace,
 			Labels:    labels,
 		},
 		Spec: appsv1.DeploymentSpec{
@@ -52,52 +234,17 @@ func BuildDeployment(spec AppSpec) *appsv1.Deployment {
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
 						{
-							Name:  "app",
-							Image: spec.Image,
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "http",
-									ContainerPort: spec.Port,
-									Protocol:      corev1.ProtocolTCP,
-								},
-							},
-							Resources: corev1.ResourceRequirements{
-								Limits: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse(spec.CPULimit),
-									corev1.ResourceMemory: resource.MustParse(spec.MemoryLimit),
-								},
-								Requests: corev1.ResourceList{
-									// Requests are 50% of limits
-									corev1.ResourceCPU:    resource.MustParse(halveResource(spec.CPULimit)),
-									corev1.ResourceMemory: resource.MustParse(halveResource(spec.MemoryLimit)),
-								},
-							},
-							LivenessProbe: &corev1.Probe{
-								ProbeHandler: corev1.ProbeHandler{
-									HTTPGet: &corev1.HTTPGetAction{
-										Path: spec.HealthCheckPath,
-										Port: intstr.FromInt32(spec.Port),
-									},
-								},
-								InitialDelaySeconds: 10,
-								PeriodSeconds:       10,
-								TimeoutSeconds:      5,
-								FailureThreshold:    3,
-							},
-							ReadinessProbe: &corev1.Probe{
-								ProbeHandler: corev1.ProbeHandler{
-									HTTPGet: &corev1.HTTPGetAction{
-										Path: spec.HealthCheckPath,
-										Port: intstr.FromInt32(spec.Port),
-									},
-								},
-								InitialDelaySeconds: 5,
-								PeriodSeconds:       5,
-								TimeoutSeconds:      3,
-								FailureThreshold:    3,
-							},
+							Name:           "app",
+							Image:          spec.Image,
+							Env:            buildEnv(spec.Env),
+							Ports:          buildContainerPorts(spec.Ports),
+							Resources:      resources,
+							LivenessProbe:  buildProbe(spec.LivenessProbe),
+							ReadinessProbe: buildProbe(spec.ReadinessProbe),
+							StartupProbe:   buildProbe(spec.StartupProbe),
 						},
 					},
+					NodeSelector:  nodeSelector,
 					RestartPolicy: corev1.RestartPolicyAlways,
 				},
 			},
@@ -109,53 +256,246 @@ func BuildDeployment(spec AppSpec) *appsv1.Deployment {
 				},
 			},
 		},
-	}
+	}, nil
 }
 
 // BuildService creates a Service manifest for an app
 func BuildService(spec AppSpec) *corev1.Service {
 	labels := map[string]string{
-		"app":              spec.Slug,
-		"superfly.dev/app": spec.Slug,
+		"app":    spec.Slug,
+		AppLabel: spec.Slug,
 	}
 
-	return &corev1.Service{
+	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      spec.Slug,
-			Namespace: AppsNamespace,
+			Namespace: spec.Namespace,
 			Labels:    labels,
 		},
 		Spec: corev1.ServiceSpec{
 			Selector: labels,
-			Ports: []corev1.ServicePort{
-				{
-					Name:       "http",
-					Port:       80,
-					TargetPort: intstr.FromInt32(spec.Port),
-					Protocol:   corev1.ProtocolTCP,
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+
+	switch spec.ServiceType {
+	case ServiceTypeNodePort:
+		service.Spec.Type = corev1.ServiceTypeNodePort
+	case ServiceTypeLoadBalancer:
+		service.Spec.Type = corev1.ServiceTypeLoadBalancer
+	}
+
+	for _, p := range spec.Ports {
+		port := corev1.ServicePort{
+			Name:        p.Name,
+			Port:        p.ServicePort,
+			TargetPort:  intstr.FromString(p.Name),
+			Protocol:    transportProtocol(p.Protocol),
+			AppProtocol: appProtocol(p.Protocol),
+		}
+		if service.Spec.Type != corev1.ServiceTypeClusterIP {
+			port.NodePort = p.NodePort
+		}
+		service.Spec.Ports = append(service.Spec.Ports, port)
+
+		// Routed ports share a scheme, so the first one decides
+		if p.Path != "" && service.Annotations == nil && isH2C(p.Protocol) {
+			service.Annotations = map[string]string{serversSchemeAnnotation: "h2c"}
+		}
+	}
+
+	return service
+}
+
+// BuildAliasService creates a Service named alias that reaches the same pods
+// and ports as the app's own Service, giving it another cluster DNS name
+func BuildAliasService(spec AppSpec, alias string) *corev1.Service {
+	service := BuildService(spec)
+	service.Name = alias
+	service.Labels = map[string]string{AliasOfLabel: spec.Slug}
+
+	// Only the app's own Service is exposed outside the cluster
+	service.Spec.Type = corev1.ServiceTypeClusterIP
+	for i := range service.Spec.Ports {
+		service.Spec.Ports[i].NodePort = 0
+	}
+	return service
+}
+
+// BuildDefaultDenyPolicy creates the NetworkPolicy that denies all ingress
+// and egress of pods in an apps namespace. Each app's own policy then
+// allows the traffic it needs; policies add up, so any of them allowing a
+// connection is enough.
+func BuildDefaultDenyPolicy(namespace string) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DefaultDenyPolicyName,
+			Namespace: namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+}
+
+// BuildNetworkPolicy creates the NetworkPolicy of an app. Public apps
+// accept connections from the ingress controller on their routed ports, and
+// from anywhere when their Service is exposed outside the cluster; apps
+// with a Service accept connections from the apps in AllowFrom. Egress
+// follows spec.Egress, and DNS lookups are always allowed.
+func BuildNetworkPolicy(spec AppSpec) *networkingv1.NetworkPolicy {
+	labels := map[string]string{
+		"app":    spec.Slug,
+		AppLabel: spec.Slug,
+	}
+
+	var ingress []networkingv1.NetworkPolicyIngressRule
+	if spec.Visibility == VisibilityPublic {
+		var routed []networkingv1.NetworkPolicyPort
+		for _, p := range spec.Ports {
+			if p.Path != "" {
+				routed = append(routed, policyPort(corev1.ProtocolTCP, p.ContainerPort))
+			}
+		}
+		if spec.Domain != "" && len(routed) > 0 {
+			ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+				Ports: routed,
+				From:  []networkingv1.NetworkPolicyPeer{namespacePeer(ingressControllerNamespace, nil)},
+			})
+		}
+		if spec.ServiceType == ServiceTypeNodePort || spec.ServiceType == ServiceTypeLoadBalancer {
+			ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{
+					{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0"}},
+					{IPBlock: &networkingv1.IPBlock{CIDR: "::/0"}},
 				},
+			})
+		}
+	}
+	if spec.Visibility != VisibilityNone && len(spec.AllowFrom) > 0 {
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+			// Peers may live in the namespace of another tenant
+			From: []networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: managedNamespaces(),
+				PodSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{
+							Key:      AppLabel,
+							Operator: metav1.LabelSelectorOpIn,
+							Values:   spec.AllowFrom,
+						},
+					},
+				},
+			}},
+		})
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      spec.Slug,
+			Namespace: spec.Namespace,
+			Labels:    labels,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: labels},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress:     ingress,
+			Egress:      buildEgressRules(spec.Egress, spec.EgressCIDRs),
+		},
+	}
+}
+
+// buildEgressRules returns the egress rules of an egress policy
+func buildEgressRules(egress string, cidrs []string) []networkingv1.NetworkPolicyEgressRule {
+	// A rule without peers or ports allows everything
+	if egress == EgressAllowAll || egress == "" {
+		return []networkingv1.NetworkPolicyEgressRule{{}}
+	}
+
+	rules := []networkingv1.NetworkPolicyEgressRule{
+		{
+			Ports: []networkingv1.NetworkPolicyPort{
+				policyPort(corev1.ProtocolUDP, 53),
+				policyPort(corev1.ProtocolTCP, 53),
 			},
-			Type: corev1.ServiceTypeClusterIP,
+			To: []networkingv1.NetworkPolicyPeer{
+				namespacePeer("kube-system", map[string]string{"k8s-app": "kube-dns"}),
+			},
+		},
+		{
+			// Whether the other app accepts the connection is up to its own
+			// policy
+			To: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: managedNamespaces()}},
 		},
 	}
+	if egress == EgressAllowlist && len(cidrs) > 0 {
+		to := make([]networkingv1.NetworkPolicyPeer, 0, len(cidrs))
+		for _, cidr := range cidrs {
+			to = append(to, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+		}
+		rules = append(rules, networkingv1.NetworkPolicyEgressRule{To: to})
+	}
+	return rules
+}
+
+// managedNamespaces selects the namespaces apps are deployed to
+func managedNamespaces() *metav1.LabelSelector {
+	return &metav1.LabelSelector{MatchLabels: map[string]string{ManagedLabel: "true"}}
+}
+
+// namespacePeer selects the pods of another namespace, or only those
+// matching podLabels when set
+func namespacePeer(namespace string, podLabels map[string]string) networkingv1.NetworkPolicyPeer {
+	peer := networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{corev1.LabelMetadataName: namespace},
+		},
+	}
+	if podLabels != nil {
+		peer.PodSelector = &metav1.LabelSelector{MatchLabels: podLabels}
+	}
+	return peer
+}
+
+func policyPort(protocol corev1.Protocol, port int32) networkingv1.NetworkPolicyPort {
+	target := intstr.FromInt32(port)
+	return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &target}
 }
 
 // BuildIngress creates an Ingress manifest for an app
 func BuildIngress(spec AppSpec) *networkingv1.Ingress {
 	labels := map[string]string{
-		"app":              spec.Slug,
-		"superfly.dev/app": spec.Slug,
+		"app":    spec.Slug,
+		AppLabel: spec.Slug,
 	}
 
 	pathTypePrefix := networkingv1.PathTypePrefix
 
+	var paths []networkingv1.HTTPIngressPath
+	for _, p := range spec.Ports {
+		if p.Path == "" {
+			continue
+		}
+		paths = append(paths, networkingv1.HTTPIngressPath{
+			Path:     p.Path,
+			PathType: &pathTypePrefix,
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: spec.Slug,
+					Port: networkingv1.ServiceBackendPort{Name: p.Name},
+				},
+			},
+		})
+	}
+
 	ingress := &networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      spec.Slug,
-			Namespace: AppsNamespace,
+			Namespace: spec.Namespace,
 			Labels:    labels,
 			Annotations: map[string]string{
-				"cert-manager.io/cluster-issuer":          "letsencrypt-prod",
+				"cert-manager.io/cluster-issuer":           "letsencrypt-prod",
 				"traefik.ingress.kubernetes.io/router.tls": "true",
 			},
 		},
@@ -165,20 +505,7 @@ func BuildIngress(spec AppSpec) *networkingv1.Ingress {
 					Host: spec.Domain,
 					IngressRuleValue: networkingv1.IngressRuleValue{
 						HTTP: &networkingv1.HTTPIngressRuleValue{
-							Paths: []networkingv1.HTTPIngressPath{
-								{
-									Path:     "/",
-									PathType: &pathTypePrefix,
-									Backend: networkingv1.IngressBackend{
-										Service: &networkingv1.IngressServiceBackend{
-											Name: spec.Slug,
-											Port: networkingv1.ServiceBackendPort{
-												Number: 80,
-											},
-										},
-									},
-								},
-							},
+							Paths: paths,
 						},
 					},
 				},
@@ -199,21 +526,262 @@ func BuildIngress(spec AppSpec) *networkingv1.Ingress {
 	return ingress
 }
 
-// halveResource returns half of a resource string (e.g., "1000m" -> "500m")
-func halveResource(resourceStr string) string {
-	q := resource.MustParse(resourceStr)
-	q.Sub(q)
-	q.Add(resource.MustParse(resourceStr))
-	
-	// Get value in milli units
-	milliValue := q.MilliValue()
-	halved := milliValue / 2
-	
-	// Return as string
-	if resourceStr[len(resourceStr)-1] == 'i' {
-		// Memory (e.g., "512Mi")
-		return resource.NewQuantity(halved/(1024*1024), resource.BinarySI).String()
-	}
-	// CPU (e.g., "500m")
-	return resource.NewMilliQuantity(halved, resource.DecimalSI).String()
+// NamespaceQuota caps what the apps of a namespace may use together, and
+// sets the limits of containers that don't declare their own. Zero values
+// are left unlimited.
+type NamespaceQuota struct {
+	CPU           resource.Quantity
+	Memory        resource.Quantity
+	Pods          int64
+	DefaultCPU    resource.Quantity
+	DefaultMemory resource.Quantity
+}
+
+// ParseNamespaceQuota parses the quantities of a NamespaceQuota, leaving
+// empty ones unlimited
+func ParseNamespaceQuota(cpu, memory string, pods int64, defaultCPU, defaultMemory string) (NamespaceQuota, error) {
+	quota := NamespaceQuota{Pods: pods}
+	quantities := []struct {
+		name  string
+		value string
+		into  *resource.Quantity
+	}{
+		{"cpu", cpu, &quota.CPU},
+		{"memory", memory, &quota.Memory},
+		{"default cpu", defaultCPU, &quota.DefaultCPU},
+		{"default memory", defaultMemory, &quota.DefaultMemory},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		parsed, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return NamespaceQuota{}, fmt.Errorf("invalid namespace quota %s %q: %w", q.name, q.value, err)
+		}
+		*q.into = parsed
+	}
+	return quota, nil
+}
+
+// BuildNamespace creates a Namespace manifest for apps
+func BuildNamespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{ManagedLabel: "true"},
+		},
+	}
+}
+
+// Names of the ResourceQuota and LimitRange of each apps namespace
+const (
+	resourceQuotaName = "superfly-quota"
+	limitRangeName    = "superfly-limits"
+)
+
+// BuildResourceQuota creates the ResourceQuota of an apps namespace, or nil
+// when the quota is unlimited
+func BuildResourceQuota(namespace string, quota NamespaceQuota) *corev1.ResourceQuota {
+	hard := corev1.ResourceList{}
+	if !quota.CPU.IsZero() {
+		hard[corev1.ResourceLimitsCPU] = quota.CPU
+	}
+	if !quota.Memory.IsZero() {
+		hard[corev1.ResourceLimitsMemory] = quota.Memory
+	}
+	if quota.Pods > 0 {
+		hard[corev1.ResourcePods] = *resource.NewQuantity(quota.Pods, resource.DecimalSI)
+	}
+	if len(hard) == 0 {
+		return nil
+	}
+
+	return &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      resourceQuotaName,
+			Namespace: namespace,
+		},
+		Spec: corev1.ResourceQuotaSpec{Hard: hard},
+	}
+}
+
+// BuildLimitRange creates the LimitRange of an apps namespace, or nil when
+// containers get no default limits
+func BuildLimitRange(namespace string, quota NamespaceQuota) *corev1.LimitRange {
+	defaults := corev1.ResourceList{}
+	if !quota.DefaultCPU.IsZero() {
+		defaults[corev1.ResourceCPU] = quota.DefaultCPU
+	}
+	if !quota.DefaultMemory.IsZero() {
+		defaults[corev1.ResourceMemory] = quota.DefaultMemory
+	}
+	if len(defaults) == 0 {
+		return nil
+	}
+
+	return &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      limitRangeName,
+			Namespace: namespace,
+		},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{
+				{
+					Type:    corev1.LimitTypeContainer,
+					Default: defaults,
+				},
+			},
+		},
+	}
+}
+
+// buildContainerPorts converts ports to their container form
+func buildContainerPorts(ports []AppPort) []corev1.ContainerPort {
+	containerPorts := make([]corev1.ContainerPort, 0, len(ports))
+	for _, p := range ports {
+		containerPorts = append(containerPorts, corev1.ContainerPort{
+			Name:          p.Name,
+			ContainerPort: p.ContainerPort,
+			Protocol:      transportProtocol(p.Protocol),
+		})
+	}
+	return containerPorts
+}
+
+// transportProtocol is the L4 protocol carrying an app protocol
+func transportProtocol(protocol string) corev1.Protocol {
+	if protocol == ProtocolUDP {
+		return corev1.ProtocolUDP
+	}
+	return corev1.ProtocolTCP
+}
+
+// appProtocol is the Service port's appProtocol hint for an app protocol
+func appProtocol(protocol string) *string {
+	var value string
+	switch {
+	case protocol == ProtocolHTTP:
+		value = "http"
+	case isH2C(protocol):
+		value = "kubernetes.io/h2c"
+	default:
+		return nil
+	}
+	return &value
+}
+
+// isH2C reports whether a protocol is HTTP/2 without TLS, as gRPC is
+func isH2C(protocol string) bool {
+	return protocol == ProtocolHTTP2 || protocol == ProtocolGRPC
+}
+
+// buildEnv converts env vars to their container form
+func buildEnv(env []EnvVar) []corev1.EnvVar {
+	vars := make([]corev1.EnvVar, 0, len(env))
+	for _, e := range env {
+		if e.SecretName == "" {
+			vars = append(vars, corev1.EnvVar{Name: e.Name, Value: e.Value})
+			continue
+		}
+		vars = append(vars, corev1.EnvVar{
+			Name: e.Name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: e.SecretName},
+					Key:                  e.SecretKey,
+				},
+			},
+		})
+	}
+	return vars
+}
+
+// buildProbe converts a probe to its container form. Defaulted fields such
+// as the HTTP scheme are set explicitly so a live Deployment compares equal
+// to a freshly built one.
+func buildProbe(p *Probe) *corev1.Probe {
+	if p == nil {
+		return nil
+	}
+
+	probe := &corev1.Probe{
+		InitialDelaySeconds: p.InitialDelaySeconds,
+		PeriodSeconds:       p.PeriodSeconds,
+		TimeoutSeconds:      p.TimeoutSeconds,
+		SuccessThreshold:    p.SuccessThreshold,
+		FailureThreshold:    p.FailureThreshold,
+	}
+
+	switch p.Type {
+	case ProbeHTTP:
+		action := &corev1.HTTPGetAction{
+			Path:   p.Path,
+			Port:   intstr.FromInt32(p.Port),
+			Scheme: corev1.URISchemeHTTP,
+		}
+		names := make([]string, 0, len(p.Headers))
+		for name := range p.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			action.HTTPHeaders = append(action.HTTPHeaders, corev1.HTTPHeader{Name: name, Value: p.Headers[name]})
+		}
+		probe.HTTPGet = action
+	case ProbeTCP:
+		probe.TCPSocket = &corev1.TCPSocketAction{Port: intstr.FromInt32(p.Port)}
+	case ProbeGRPC:
+		// The API server defaults an unset service to "", so it is always
+		// set to keep the live probe equal to the built one
+		probe.GRPC = &corev1.GRPCAction{Port: p.Port, Service: &p.GRPCService}
+	case ProbeExec:
+		probe.Exec = &corev1.ExecAction{Command: p.Command}
+	}
+
+	return probe
+}
+
+// buildResources converts the app's resources to their container form
+func buildResources(r Resources) (corev1.ResourceRequirements, error) {
+	requirements := corev1.ResourceRequirements{
+		Limits:   corev1.ResourceList{},
+		Requests: corev1.ResourceList{},
+	}
+	for _, res := range []struct {
+		name           corev1.ResourceName
+		request, limit string
+	}{
+		{corev1.ResourceCPU, r.CPURequest, r.CPULimit},
+		{corev1.ResourceMemory, r.MemoryRequest, r.MemoryLimit},
+		{corev1.ResourceEphemeralStorage, r.EphemeralStorageRequest, r.EphemeralStorageLimit},
+	} {
+		if res.limit != "" {
+			limit, err := resource.ParseQuantity(res.limit)
+			if err != nil {
+				return corev1.ResourceRequirements{}, fmt.Errorf("invalid %s limit %q: %w", res.name, res.limit, err)
+			}
+			requirements.Limits[res.name] = limit
+			if res.request == "" {
+				requirements.Requests[res.name] = HalfQuantity(res.name, limit)
+			}
+		}
+		if res.request != "" {
+			request, err := resource.ParseQuantity(res.request)
+			if err != nil {
+				return corev1.ResourceRequirements{}, fmt.Errorf("invalid %s request %q: %w", res.name, res.request, err)
+			}
+			requirements.Requests[res.name] = request
+		}
+	}
+	return requirements, nil
+}
+
+// HalfQuantity is half of a limit, the default request. CPU is halved in
+// millicores and everything else in bytes, keeping the limit's format.
+func HalfQuantity(name corev1.ResourceName, q resource.Quantity) resource.Quantity {
+	if name == corev1.ResourceCPU {
+		return *resource.NewMilliQuantity(q.MilliValue()/2, resource.DecimalSI)
+	}
+	return *resource.NewQuantity(q.Value()/2, q.Format)
 }
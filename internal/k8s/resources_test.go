@@ -0,0 +1,347 @@
+package k8s
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestBuildNetworkPolicyIngress(t *testing.T) {
+	routed := []AppPort{{Name: "http", ContainerPort: 8080, Protocol: ProtocolHTTP, Path: "/"}}
+	unrouted := []AppPort{{Name: "db", ContainerPort: 5432, Protocol: ProtocolTCP}}
+
+	tests := []struct {
+		name string
+		spec AppSpec
+		// want describes the peers of each ingress rule
+		want []string
+	}{
+		{
+			name: "public with domain",
+			spec: AppSpec{Visibility: VisibilityPublic, Domain: "web.example.com", Ports: routed},
+			want: []string{"namespace traefik on 8080"},
+		},
+		{
+			name: "public without domain",
+			spec: AppSpec{Visibility: VisibilityPublic, Ports: routed},
+		},
+		{
+			name: "public without routed ports",
+			spec: AppSpec{Visibility: VisibilityPublic, Domain: "web.example.com", Ports: unrouted},
+		},
+		{
+			name: "public load balancer",
+			spec: AppSpec{Visibility: VisibilityPublic, ServiceType: ServiceTypeLoadBalancer, Ports: unrouted},
+			want: []string{"0.0.0.0/0 ::/0"},
+		},
+		{
+			name: "public with peers",
+			spec: AppSpec{Visibility: VisibilityPublic, Domain: "web.example.com", Ports: routed, AllowFrom: []string{"api"}},
+			want: []string{"namespace traefik on 8080", "apps api"},
+		},
+		{
+			name: "internal with peers",
+			spec: AppSpec{Visibility: VisibilityInternal, Domain: "web.example.com", Ports: routed, AllowFrom: []string{"api", "worker"}},
+			want: []string{"apps api,worker"},
+		},
+		{
+			name: "internal node port",
+			spec: AppSpec{Visibility: VisibilityInternal, ServiceType: ServiceTypeNodePort, Ports: unrouted},
+		},
+		{
+			name: "none with peers",
+			spec: AppSpec{Visibility: VisibilityNone, AllowFrom: []string{"api"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.spec.Slug, tt.spec.Namespace = "web", "superfly-apps"
+			policy := BuildNetworkPolicy(tt.spec)
+
+			if policy.Name != "web" || policy.Spec.PodSelector.MatchLabels[AppLabel] != "web" {
+				t.Errorf("policy %s selects %v, want the pods of web", policy.Name, policy.Spec.PodSelector.MatchLabels)
+			}
+			var got []string
+			for _, rule := range policy.Spec.Ingress {
+				got = append(got, describeIngressRule(rule))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got ingress rules %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildNetworkPolicyEgress(t *testing.T) {
+	tests := []struct {
+		egress string
+		cidrs  []string
+		// want lists the destinations of each egress rule; "*" is anywhere
+		want []string
+	}{
+		{egress: EgressAllowAll, want: []string{"*"}},
+		{egress: "", want: []string{"*"}},
+		{egress: EgressDenyInternet, want: []string{"dns", "apps"}},
+		{egress: EgressAllowlist, want: []string{"dns", "apps"}},
+		{egress: EgressAllowlist, cidrs: []string{"10.0.0.0/8", "192.0.2.1/32"}, want: []string{"dns", "apps", "10.0.0.0/8 192.0.2.1/32"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.egress+strings.Join(tt.cidrs, ","), func(t *testing.T) {
+			policy := BuildNetworkPolicy(AppSpec{Slug: "web", Visibility: VisibilityInternal, Egress: tt.egress, EgressCIDRs: tt.cidrs})
+
+			var got []string
+			for _, rule := range policy.Spec.Egress {
+				switch {
+				case len(rule.To) == 0 && len(rule.Ports) == 0:
+					got = append(got, "*")
+				case len(rule.Ports) > 0:
+					got = append(got, "dns")
+				case rule.To[0].IPBlock != nil:
+					var cidrs []string
+					for _, peer := range rule.To {
+						cidrs = append(cidrs, peer.IPBlock.CIDR)
+					}
+					got = append(got, strings.Join(cidrs, " "))
+				default:
+					got = append(got, "apps")
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got egress rules %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildDefaultDenyPolicy(t *testing.T) {
+	policy := BuildDefaultDenyPolicy("superfly-acme")
+	if policy.Name != DefaultDenyPolicyName || policy.Namespace != "superfly-acme" {
+		t.Errorf("got policy %s/%s", policy.Namespace, policy.Name)
+	}
+	if len(policy.Spec.PodSelector.MatchLabels) > 0 || len(policy.Spec.Ingress) > 0 || len(policy.Spec.Egress) > 0 {
+		t.Errorf("default deny policy allows something: %+v", policy.Spec)
+	}
+	want := []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}
+	if !slices.Equal(policy.Spec.PolicyTypes, want) {
+		t.Errorf("got policy types %v, want %v", policy.Spec.PolicyTypes, want)
+	}
+}
+
+// describeIngressRule summarizes who an ingress rule lets in
+func describeIngressRule(rule networkingv1.NetworkPolicyIngressRule) string {
+	var parts []string
+	for _, peer := range rule.From {
+		switch {
+		case peer.IPBlock != nil:
+			parts = append(parts, peer.IPBlock.CIDR)
+		case peer.PodSelector != nil && len(peer.PodSelector.MatchExpressions) > 0:
+			parts = append(parts, "apps "+strings.Join(peer.PodSelector.MatchExpressions[0].Values, ","))
+		case peer.NamespaceSelector != nil:
+			parts = append(parts, "namespace "+peer.NamespaceSelector.MatchLabels[corev1.LabelMetadataName])
+		}
+	}
+	description := strings.Join(parts, " ")
+	for _, port := range rule.Ports {
+		description += " on " + port.Port.String()
+	}
+	return description
+}
+
+func TestHalfQuantity(t *testing.T) {
+	tests := []struct {
+		name  corev1.ResourceName
+		limit string
+		want  string
+	}{
+		{corev1.ResourceCPU, "500m", "250m"},
+		{corev1.ResourceCPU, "1", "500m"},
+		{corev1.ResourceCPU, "1.5", "750m"},
+		{corev1.ResourceCPU, "1m", "0"},
+		{corev1.ResourceMemory, "256Mi", "128Mi"},
+		{corev1.ResourceMemory, "1Gi", "512Mi"},
+		{corev1.ResourceMemory, "1G", "500M"},
+		{corev1.ResourceEphemeralStorage, "2Gi", "1Gi"},
+	}
+	for _, tt := range tests {
+		got := HalfQuantity(tt.name, resource.MustParse(tt.limit))
+		if got.String() != tt.want {
+			t.Errorf("HalfQuantity(%s, %s) = %s, want %s", tt.name, tt.limit, got.String(), tt.want)
+		}
+	}
+}
+
+func TestBuildResources(t *testing.T) {
+	tests := []struct {
+		name         string
+		resources    Resources
+		wantLimits   map[corev1.ResourceName]string
+		wantRequests map[corev1.ResourceName]string
+		wantErr      string
+	}{
+		{
+			name:         "limits only",
+			resources:    Resources{CPULimit: "500m", MemoryLimit: "256Mi"},
+			wantLimits:   map[corev1.ResourceName]string{corev1.ResourceCPU: "500m", corev1.ResourceMemory: "256Mi"},
+			wantRequests: map[corev1.ResourceName]string{corev1.ResourceCPU: "250m", corev1.ResourceMemory: "128Mi"},
+		},
+		{
+			name:         "requests and limits",
+			resources:    Resources{CPURequest: "100m", CPULimit: "1", MemoryRequest: "256Mi", MemoryLimit: "256Mi"},
+			wantLimits:   map[corev1.ResourceName]string{corev1.ResourceCPU: "1", corev1.ResourceMemory: "256Mi"},
+			wantRequests: map[corev1.ResourceName]string{corev1.ResourceCPU: "100m", corev1.ResourceMemory: "256Mi"},
+		},
+		{
+			name:         "request without a limit",
+			resources:    Resources{CPURequest: "250m"},
+			wantLimits:   map[corev1.ResourceName]string{},
+			wantRequests: map[corev1.ResourceName]string{corev1.ResourceCPU: "250m"},
+		},
+		{
+			name:         "ephemeral storage",
+			resources:    Resources{EphemeralStorageLimit: "2Gi"},
+			wantLimits:   map[corev1.ResourceName]string{corev1.ResourceEphemeralStorage: "2Gi"},
+			wantRequests: map[corev1.ResourceName]string{corev1.ResourceEphemeralStorage: "1Gi"},
+		},
+		{
+			name:      "invalid limit",
+			resources: Resources{MemoryLimit: "lots"},
+			wantErr:   `invalid memory limit "lots"`,
+		},
+		{
+			name:      "invalid request",
+			resources: Resources{CPULimit: "1", CPURequest: "half"},
+			wantErr:   `invalid cpu request "half"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildResources(tt.resources)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertResourceList(t, "limits", got.Limits, tt.wantLimits)
+			assertResourceList(t, "requests", got.Requests, tt.wantRequests)
+		})
+	}
+}
+
+func assertResourceList(t *testing.T, kind string, got corev1.ResourceList, want map[corev1.ResourceName]string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got %s %v, want %v", kind, got, want)
+		return
+	}
+	for name, quantity := range want {
+		if q, ok := got[name]; !ok || q.String() != quantity {
+			t.Errorf("got %s %s of %s, want %s", name, kind, q.String(), quantity)
+		}
+	}
+}
+
+func TestWithDefaultRequests(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources Resources
+		want      Resources
+	}{
+		{
+			name:      "limits only",
+			resources: Resources{CPULimit: "500m", MemoryLimit: "256Mi", EphemeralStorageLimit: "2Gi"},
+			want:      Resources{CPURequest: "250m", CPULimit: "500m", MemoryRequest: "128Mi", MemoryLimit: "256Mi", EphemeralStorageRequest: "1Gi", EphemeralStorageLimit: "2Gi"},
+		},
+		{
+			name:      "requests kept",
+			resources: Resources{CPURequest: "2", CPULimit: "2", MemoryRequest: "1Gi", MemoryLimit: "4Gi"},
+			want:      Resources{CPURequest: "2", CPULimit: "2", MemoryRequest: "1Gi", MemoryLimit: "4Gi"},
+		},
+		{
+			name:      "invalid limit",
+			resources: Resources{CPULimit: "fast", MemoryLimit: "1Gi"},
+			want:      Resources{CPULimit: "fast", MemoryRequest: "512Mi", MemoryLimit: "1Gi"},
+		},
+		{name: "nothing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.resources.WithDefaultRequests(); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildProbeMatchesServerDefaults(t *testing.T) {
+	timing := Probe{PeriodSeconds: 10, TimeoutSeconds: 1, SuccessThreshold: 1, FailureThreshold: 3}
+	probe := func(edit func(p *Probe)) *Probe {
+		p := timing
+		edit(&p)
+		return &p
+	}
+
+	tests := []struct {
+		name  string
+		probe *Probe
+	}{
+		{"http", probe(func(p *Probe) {
+			p.Type, p.Path, p.Port = ProbeHTTP, "/healthz", 8080
+			p.Headers = map[string]string{"X-Probe": "1", "Accept": "text/plain"}
+		})},
+		{"tcp", probe(func(p *Probe) { p.Type, p.Port = ProbeTCP, 5432 })},
+		{"grpc without a service", probe(func(p *Probe) { p.Type, p.Port = ProbeGRPC, 9090 })},
+		{"grpc with a service", probe(func(p *Probe) { p.Type, p.Port, p.GRPCService = ProbeGRPC, 9090, "health.v1" })},
+		{"exec", probe(func(p *Probe) { p.Type, p.Command = ProbeExec, []string{"pg_isready"} })},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			built := buildProbe(tt.probe)
+			live := built.DeepCopy()
+			setProbeDefaults(live)
+			if !equality.Semantic.DeepEqual(built, live) {
+				t.Errorf("the API server changes the probe from\n%+v\nto\n%+v", built, live)
+			}
+		})
+	}
+}
+
+// setProbeDefaults sets the defaults the API server gives probes, as
+// k8s.io/kubernetes/pkg/apis/core/v1 does
+func setProbeDefaults(p *corev1.Probe) {
+	if p.TimeoutSeconds == 0 {
+		p.TimeoutSeconds = 1
+	}
+	if p.PeriodSeconds == 0 {
+		p.PeriodSeconds = 10
+	}
+	if p.SuccessThreshold == 0 {
+		p.SuccessThreshold = 1
+	}
+	if p.FailureThreshold == 0 {
+		p.FailureThreshold = 3
+	}
+	if p.HTTPGet != nil {
+		if p.HTTPGet.Path == "" {
+			p.HTTPGet.Path = "/"
+		}
+		if p.HTTPGet.Scheme == "" {
+			p.HTTPGet.Scheme = corev1.URISchemeHTTP
+		}
+	}
+	if p.GRPC != nil && p.GRPC.Service == nil {
+		p.GRPC.Service = new(string)
+	}
+}
@@ -0,0 +1,359 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/superfly/superfly/internal/tracing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// revisionAnnotation is where the Deployment controller numbers the
+// revisions of a Deployment and its ReplicaSets
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// Rollout failure reasons. Those of the Deployment's conditions and of its
+// containers' waiting states are passed through as Kubernetes reports them.
+const (
+	ReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	ReasonImagePullBackOff         = "ImagePullBackOff"
+	ReasonCrashLoopBackOff         = "CrashLoopBackOff"
+	ReasonRolloutTimeout           = "RolloutTimeout"
+	ReasonDeploymentDeleted        = "DeploymentDeleted"
+)
+
+// failedWaitingReasons are the waiting reasons of a container that mean a
+// rollout won't succeed without a change to the app. ErrImagePull isn't
+// one, since the kubelet retries it before backing off.
+var failedWaitingReasons = map[string]bool{
+	ReasonImagePullBackOff:       true,
+	"ErrImageNeverPull":          true,
+	"InvalidImageName":           true,
+	ReasonCrashLoopBackOff:       true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// RolloutStatus is the progress of a Deployment's rollout
+type RolloutStatus struct {
+	Revision  string
+	Replicas  int32
+	Updated   int32
+	Available int32
+	// Old is the number of pods of previous revisions still running
+	Old  int32
+	Done bool
+}
+
+func (s RolloutStatus) String() string {
+	if s.Old > 0 {
+		return fmt.Sprintf("%d of %d replicas updated, %d old replicas terminating", s.Updated, s.Replicas, s.Old)
+	}
+	return fmt.Sprintf("%d of %d updated replicas available", s.Available, s.Replicas)
+}
+
+// RolloutError is a rollout that failed, with the reason Kubernetes gave
+type RolloutError struct {
+	Reason  string
+	Message string
+}
+
+func (e *RolloutError) Error() string {
+	return e.Reason + ": " + e.Message
+}
+
+// WatchRollout watches a Deployment and its pods until the rollout of its
+// latest revision finishes, calling progress whenever it advances. It
+// fails with a *RolloutError if the Deployment stops progressing, if a
+// container of the new revision can't start, or after timeout.
+func (c *Client) WatchRollout(ctx context.Context, namespace, name string, timeout time.Duration, progress func(RolloutStatus)) (err error) {
+	ctx, span := c.startSpan(ctx, "WatchRollout", namespace, name)
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	w := &rolloutWatch{
+		client:      c,
+		namespace:   namespace,
+		name:        name,
+		pods:        make(map[string]*corev1.Pod),
+		replicaSets: make(map[string]string),
+	}
+	err = w.run(ctx, progress)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return &RolloutError{Reason: ReasonRolloutTimeout, Message: fmt.Sprintf("rollout did not finish within %s", timeout)}
+	}
+	return err
+}
+
+// rolloutWatch follows the rollout of a Deployment
+type rolloutWatch struct {
+	client    *Client
+	namespace string
+	name      string
+
+	// status is the rollout as of the last Deployment event
+	status RolloutStatus
+	// pods are the app's current pods by name
+	pods map[string]*corev1.Pod
+	// replicaSets caches the revision of the ReplicaSets owning the pods
+	replicaSets map[string]string
+}
+
+func (w *rolloutWatch) run(ctx context.Context, progress func(RolloutStatus)) error {
+	deployments := w.client.clientset.AppsV1().Deployments(w.namespace)
+	pods := w.client.clientset.CoreV1().Pods(w.namespace)
+
+	// Watches started without a resource version begin with an event for
+	// each existing object, so reopening one after the server closes it
+	// catches up on anything missed
+	var deploymentEvents, podEvents watch.Interface
+	defer func() {
+		if deploymentEvents != nil {
+			deploymentEvents.Stop()
+		}
+		if podEvents != nil {
+			podEvents.Stop()
+		}
+	}()
+
+	for {
+		var err error
+		if deploymentEvents == nil {
+			deploymentEvents, err = deployments.Watch(ctx, metav1.ListOptions{
+				FieldSelector: fields.OneTermEqualSelector("metadata.name", w.name).String(),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to watch deployment: %w", err)
+			}
+		}
+		if podEvents == nil {
+			podEvents, err = pods.Watch(ctx, metav1.ListOptions{LabelSelector: AppLabel + "=" + w.name})
+			if err != nil {
+				return fmt.Errorf("failed to watch pods: %w", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case event, ok := <-deploymentEvents.ResultChan():
+			if !ok {
+				deploymentEvents = nil
+				continue
+			}
+			switch event.Type {
+			case watch.Error:
+				return fmt.Errorf("failed to watch deployment: %w", errors.FromObject(event.Object))
+			case watch.Deleted:
+				return &RolloutError{Reason: ReasonDeploymentDeleted, Message: "deployment was deleted during the rollout"}
+			}
+			deployment, ok := event.Object.(*appsv1.Deployment)
+			if !ok {
+				continue
+			}
+
+			status, err := rolloutStatus(deployment)
+			if err != nil {
+				return err
+			}
+			if status != w.status {
+				w.status = status
+				progress(status)
+			}
+			if status.Done {
+				return nil
+			}
+
+		case event, ok := <-podEvents.ResultChan():
+			if !ok {
+				podEvents = nil
+				continue
+			}
+			switch event.Type {
+			case watch.Error:
+				return fmt.Errorf("failed to watch pods: %w", errors.FromObject(event.Object))
+			case watch.Deleted:
+				if pod, ok := event.Object.(*corev1.Pod); ok {
+					delete(w.pods, pod.Name)
+				}
+				continue
+			}
+			if pod, ok := event.Object.(*corev1.Pod); ok {
+				w.pods[pod.Name] = pod
+			}
+		}
+
+		// Pods are only judged once the revision they have to be of is
+		// known, which may be after their events arrived
+		if err := w.checkPods(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// checkPods fails the rollout if a container of a pod of the latest
+// revision can't start
+func (w *rolloutWatch) checkPods(ctx context.Context) error {
+	if w.status.Revision == "" {
+		return nil
+	}
+	for _, pod := range w.pods {
+		failure := podFailure(pod)
+		if failure == nil {
+			continue
+		}
+		revision, err := w.podRevision(ctx, pod)
+		if err != nil {
+			return err
+		}
+		if revision == w.status.Revision {
+			return failure
+		}
+	}
+	return nil
+}
+
+// podRevision returns the revision of the ReplicaSet owning a pod
+func (w *rolloutWatch) podRevision(ctx context.Context, pod *corev1.Pod) (string, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "ReplicaSet" {
+		return "", nil
+	}
+	if revision, ok := w.replicaSets[owner.Name]; ok {
+		return revision, nil
+	}
+
+	replicaSet, err := w.client.clientset.AppsV1().ReplicaSets(w.namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get replica set: %w", err)
+	}
+	revision := replicaSet.Annotations[revisionAnnotation]
+	w.replicaSets[owner.Name] = revision
+	return revision, nil
+}
+
+// rolloutStatus evaluates a Deployment's rollout the way kubectl rollout
+// status does. Until the controller has observed the Deployment's latest
+// generation its status describes an earlier one, so the rollout isn't
+// done and its revision isn't known yet.
+func rolloutStatus(deployment *appsv1.Deployment) (RolloutStatus, error) {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := RolloutStatus{
+		Replicas:  replicas,
+		Updated:   deployment.Status.UpdatedReplicas,
+		Available: deployment.Status.AvailableReplicas,
+		Old:       deployment.Status.Replicas - deployment.Status.UpdatedReplicas,
+	}
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return status, nil
+	}
+	status.Revision = deployment.Annotations[revisionAnnotation]
+
+	for _, condition := range deployment.Status.Conditions {
+		switch {
+		case condition.Type == appsv1.DeploymentProgressing && condition.Reason == ReasonProgressDeadlineExceeded:
+			return status, &RolloutError{Reason: condition.Reason, Message: condition.Message}
+		case condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == corev1.ConditionTrue:
+			// Such as pods the namespace's quota has no room for
+			return status, &RolloutError{Reason: condition.Reason, Message: condition.Message}
+		}
+	}
+
+	status.Done = status.Updated >= replicas && status.Old <= 0 && status.Available >= status.Updated
+	return status, nil
+}
+
+// podFailure returns why a container of a pod can't start, if it can't
+func podFailure(pod *corev1.Pod) *RolloutError {
+	statuses := make([]corev1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses))
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, container := range statuses {
+		waiting := container.State.Waiting
+		if waiting == nil || !failedWaitingReasons[waiting.Reason] {
+			continue
+		}
+		message := fmt.Sprintf("pod %s container %s: %s", pod.Name, container.Name, waiting.Message)
+		if terminated := container.LastTerminationState.Terminated; terminated != nil {
+			message += fmt.Sprintf(" (last exit code %d, %s)", terminated.ExitCode, terminated.Reason)
+		}
+		return &RolloutError{Reason: waiting.Reason, Message: message}
+	}
+	return nil
+}
+
+// RollbackDeployment rolls a Deployment back to the pod template of its
+// previous revision, as kubectl rollout undo does, and returns the revision
+// it rolled back to
+func (c *Client) RollbackDeployment(ctx context.Context, namespace, name string) (_ int64, err error) {
+	ctx, span := c.startSpan(ctx, "RollbackDeployment", namespace, name)
+	defer func() { tracing.End(span, err) }()
+
+	deployment, err := c.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	current, _ := strconv.ParseInt(deployment.Annotations[revisionAnnotation], 10, 64)
+
+	replicaSets, err := c.clientset.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{LabelSelector: AppLabel + "=" + name})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list replica sets: %w", err)
+	}
+	var previous *appsv1.ReplicaSet
+	var previousRevision int64
+	for i := range replicaSets.Items {
+		replicaSet := &replicaSets.Items[i]
+		if !metav1.IsControlledBy(replicaSet, deployment) {
+			continue
+		}
+		revision, err := strconv.ParseInt(replicaSet.Annotations[revisionAnnotation], 10, 64)
+		if err != nil || revision >= current || revision <= previousRevision {
+			continue
+		}
+		previous, previousRevision = replicaSet, revision
+	}
+	if previous == nil {
+		return 0, fmt.Errorf("no previous revision to roll back to")
+	}
+
+	template := previous.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	patch, err := json.Marshal([]map[string]any{
+		// Fails if the Deployment changed since it was read, rather than
+		// undoing a deploy that started in the meantime
+		{"op": "test", "path": "/metadata/resourceVersion", "value": deployment.ResourceVersion},
+		{"op": "replace", "path": "/spec/template", "value": template},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode rollback patch: %w", err)
+	}
+
+	_, err = c.clientset.AppsV1().Deployments(namespace).Patch(ctx, name, types.JSONPatchType, patch, metav1.PatchOptions{
+		FieldManager: FieldManager,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to roll back deployment: %w", err)
+	}
+	c.log(ctx).Info("rolled back deployment", "namespace", namespace, "name", name, "revision", previousRevision)
+
+	return previousRevision, nil
+}
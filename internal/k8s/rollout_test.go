@@ -0,0 +1,296 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRolloutStatus(t *testing.T) {
+	replicas := int32(3)
+	deployment := func(generation, observed int64, status appsv1.DeploymentStatus) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "web",
+				Generation:  generation,
+				Annotations: map[string]string{revisionAnnotation: "2"},
+			},
+			Spec:   appsv1.DeploymentSpec{Replicas: &replicas},
+			Status: status,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		deployment *appsv1.Deployment
+		want       RolloutStatus
+		wantReason string
+	}{
+		{
+			name:       "generation not observed yet",
+			deployment: deployment(2, 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}),
+			want:       RolloutStatus{Replicas: 3, Updated: 3, Available: 3},
+		},
+		{
+			name:       "old replicas running",
+			deployment: deployment(2, 2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 1, AvailableReplicas: 3}),
+			want:       RolloutStatus{Revision: "2", Replicas: 3, Updated: 1, Available: 3, Old: 3},
+		},
+		{
+			name:       "updated replicas not available",
+			deployment: deployment(2, 2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 2}),
+			want:       RolloutStatus{Revision: "2", Replicas: 3, Updated: 3, Available: 2},
+		},
+		{
+			name:       "done",
+			deployment: deployment(2, 2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}),
+			want:       RolloutStatus{Revision: "2", Replicas: 3, Updated: 3, Available: 3, Done: true},
+		},
+		{
+			name: "progress deadline exceeded",
+			deployment: deployment(2, 2, appsv1.DeploymentStatus{
+				ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 1, AvailableReplicas: 3,
+				Conditions: []appsv1.DeploymentCondition{{
+					Type:    appsv1.DeploymentProgressing,
+					Status:  corev1.ConditionFalse,
+					Reason:  ReasonProgressDeadlineExceeded,
+					Message: `ReplicaSet "web-7d9c8f6b5" has timed out progressing.`,
+				}},
+			}),
+			want:       RolloutStatus{Revision: "2", Replicas: 3, Updated: 1, Available: 3, Old: 3},
+			wantReason: ReasonProgressDeadlineExceeded,
+		},
+		{
+			name: "replica failure",
+			deployment: deployment(2, 2, appsv1.DeploymentStatus{
+				ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 0, AvailableReplicas: 3,
+				Conditions: []appsv1.DeploymentCondition{{
+					Type:    appsv1.DeploymentReplicaFailure,
+					Status:  corev1.ConditionTrue,
+					Reason:  "FailedCreate",
+					Message: "exceeded quota: apps-quota",
+				}},
+			}),
+			want:       RolloutStatus{Revision: "2", Replicas: 3, Available: 3, Old: 3},
+			wantReason: "FailedCreate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rolloutStatus(tt.deployment)
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			assertRolloutError(t, err, tt.wantReason)
+		})
+	}
+
+	t.Run("default replicas", func(t *testing.T) {
+		d := deployment(1, 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1})
+		d.Spec.Replicas = nil
+		got, err := rolloutStatus(d)
+		if err != nil || got.Replicas != 1 || !got.Done {
+			t.Errorf("got %+v, %v, want one replica done", got, err)
+		}
+	})
+}
+
+func TestPodFailure(t *testing.T) {
+	waiting := func(name, reason, message string) corev1.ContainerStatus {
+		return corev1.ContainerStatus{
+			Name:  name,
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: message}},
+		}
+	}
+	crashLooping := waiting("web", ReasonCrashLoopBackOff, "back-off 5m0s restarting failed container")
+	crashLooping.LastTerminationState.Terminated = &corev1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}
+
+	tests := []struct {
+		name        string
+		status      corev1.PodStatus
+		wantReason  string
+		wantMessage string
+	}{
+		{
+			name: "running",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "web",
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			}}},
+		},
+		{
+			name:   "image pull retried",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{waiting("web", "ErrImagePull", "not found")}},
+		},
+		{
+			name:   "container creating",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{waiting("web", "ContainerCreating", "")}},
+		},
+		{
+			name:        "image pull back-off",
+			status:      corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{waiting("web", ReasonImagePullBackOff, `Back-off pulling image "nginx:nope"`)}},
+			wantReason:  ReasonImagePullBackOff,
+			wantMessage: `pod web-1 container web: Back-off pulling image "nginx:nope"`,
+		},
+		{
+			name:        "crash loop with last exit code",
+			status:      corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{crashLooping}},
+			wantReason:  ReasonCrashLoopBackOff,
+			wantMessage: "pod web-1 container web: back-off 5m0s restarting failed container (last exit code 1, Error)",
+		},
+		{
+			name: "init container",
+			status: corev1.PodStatus{
+				InitContainerStatuses: []corev1.ContainerStatus{waiting("migrate", "CreateContainerConfigError", `secret "db" not found`)},
+				ContainerStatuses:     []corev1.ContainerStatus{waiting("web", "PodInitializing", "")},
+			},
+			wantReason:  "CreateContainerConfigError",
+			wantMessage: `pod web-1 container migrate: secret "db" not found`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1"}, Status: tt.status}
+			failure := podFailure(pod)
+			if tt.wantReason == "" {
+				if failure != nil {
+					t.Errorf("got failure %v, want none", failure)
+				}
+				return
+			}
+			if failure == nil {
+				t.Fatalf("got no failure, want %s", tt.wantReason)
+			}
+			if failure.Reason != tt.wantReason || failure.Message != tt.wantMessage {
+				t.Errorf("got %s %q, want %s %q", failure.Reason, failure.Message, tt.wantReason, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestRollbackDeployment(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web",
+			Namespace:       "apps",
+			UID:             types.UID("deployment-uid"),
+			ResourceVersion: "10",
+			Annotations:     map[string]string{revisionAnnotation: "3"},
+		},
+		Spec: appsv1.DeploymentSpec{Template: podTemplate("nginx:1.27", "")},
+	}
+	replicaSet := func(name, revision, image string, owner *appsv1.Deployment) *appsv1.ReplicaSet {
+		rs := &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "apps",
+				Labels:      map[string]string{AppLabel: "web"},
+				Annotations: map[string]string{revisionAnnotation: revision},
+			},
+			Spec: appsv1.ReplicaSetSpec{Template: podTemplate(image, name)},
+		}
+		if owner != nil {
+			rs.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(owner, appsv1.SchemeGroupVersion.WithKind("Deployment"))}
+		}
+		return rs
+	}
+	other := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps", UID: types.UID("other-uid")}}
+
+	tests := []struct {
+		name         string
+		replicaSets  []runtime.Object
+		wantRevision int64
+		wantImage    string
+		wantErr      string
+	}{
+		{
+			name: "previous revision",
+			replicaSets: []runtime.Object{
+				replicaSet("web-1", "1", "nginx:1.25", deployment),
+				replicaSet("web-2", "2", "nginx:1.26", deployment),
+				replicaSet("web-3", "3", "nginx:1.27", deployment),
+				replicaSet("web-4", "2", "nginx:latest", other),
+			},
+			wantRevision: 2,
+			wantImage:    "nginx:1.26",
+		},
+		{
+			name: "no previous revision",
+			replicaSets: []runtime.Object{
+				replicaSet("web-3", "3", "nginx:1.27", deployment),
+			},
+			wantErr: "no previous revision to roll back to",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(append([]runtime.Object{deployment.DeepCopy()}, tt.replicaSets...)...)
+			client := &Client{cluster: "test", clientset: clientset, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+			revision, err := client.RollbackDeployment(context.Background(), "apps", "web")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revision != tt.wantRevision {
+				t.Errorf("got revision %d, want %d", revision, tt.wantRevision)
+			}
+
+			got, err := clientset.AppsV1().Deployments("apps").Get(context.Background(), "web", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image := got.Spec.Template.Spec.Containers[0].Image; image != tt.wantImage {
+				t.Errorf("got image %s, want %s", image, tt.wantImage)
+			}
+			if _, ok := got.Spec.Template.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; ok {
+				t.Errorf("template kept the %s label", appsv1.DefaultDeploymentUniqueLabelKey)
+			}
+		})
+	}
+}
+
+// podTemplate is the pod template of the web app, labeled with the hash the
+// Deployment controller adds to its ReplicaSets' templates when hash is set
+func podTemplate(image, hash string) corev1.PodTemplateSpec {
+	labels := map[string]string{AppLabel: "web"}
+	if hash != "" {
+		labels[appsv1.DefaultDeploymentUniqueLabelKey] = hash
+	}
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: labels},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: image}}},
+	}
+}
+
+func assertRolloutError(t *testing.T, err error, wantReason string) {
+	t.Helper()
+	if wantReason == "" {
+		if err != nil {
+			t.Errorf("got error %v, want none", err)
+		}
+		return
+	}
+	var rolloutErr *RolloutError
+	if !errors.As(err, &rolloutErr) || rolloutErr.Reason != wantReason {
+		t.Errorf("got error %v, want a rollout error with reason %s", err, wantReason)
+	}
+}
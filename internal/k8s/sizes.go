@@ -0,0 +1,79 @@
+package k8s
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Size is an entry of the sizes catalog: a named machine size apps pick
+// instead of setting their resources, and the nodes its pods may run on
+type Size struct {
+	Name string `toml:"name"`
+	// Requests and limits as quantities; a request left out is half its
+	// limit, as for an app's own resources
+	CPURequest              string `toml:"cpu_request"`
+	CPULimit                string `toml:"cpu_limit"`
+	MemoryRequest           string `toml:"memory_request"`
+	MemoryLimit             string `toml:"memory_limit"`
+	EphemeralStorageRequest string `toml:"ephemeral_storage_request"`
+	EphemeralStorageLimit   string `toml:"ephemeral_storage_limit"`
+	// NodeSelector restricts the pods of the size to nodes with these labels
+	NodeSelector map[string]string `toml:"node_selector"`
+	// PricePerHour is what one replica costs per hour, in the currency the
+	// catalog is priced in
+	PricePerHour float64 `toml:"price_per_hour"`
+}
+
+// Resources returns the resources of the container of a pod of the size
+func (s *Size) Resources() Resources {
+	return Resources{
+		CPURequest:              s.CPURequest,
+		CPULimit:                s.CPULimit,
+		MemoryRequest:           s.MemoryRequest,
+		MemoryLimit:             s.MemoryLimit,
+		EphemeralStorageRequest: s.EphemeralStorageRequest,
+		EphemeralStorageLimit:   s.EphemeralStorageLimit,
+	}
+}
+
+// LoadSizes reads a sizes catalog, a TOML file of [[size]] tables:
+//
+//	[[size]]
+//	name = "shared-1x"
+//	cpu_limit = "500m"
+//	memory_limit = "256Mi"
+//	price_per_hour = 0.004
+//
+//	[[size]]
+//	name = "performance-2x"
+//	cpu_request = "2"
+//	cpu_limit = "2"
+//	memory_request = "4Gi"
+//	memory_limit = "4Gi"
+//	node_selector = { "superfly.dev/pool" = "performance" }
+//	price_per_hour = 0.12
+func LoadSizes(path string) ([]Size, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sizes file: %w", err)
+	}
+
+	var catalog struct {
+		Sizes []Size `toml:"size"`
+	}
+	meta, err := toml.Decode(string(data), &catalog)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sizes file: %w", err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, fmt.Errorf("unknown keys in sizes file: %s", strings.Join(keys, ", "))
+	}
+	return catalog.Sizes, nil
+}
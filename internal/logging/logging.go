@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys shared by every log line so a single deploy can be followed
+// from the originating request through the async deploy goroutine.
+const (
+	KeyRequestID    = "request_id"
+	KeyAppID        = "app_id"
+	KeyAppSlug      = "app_slug"
+	KeyDeploymentID = "deployment_id"
+	KeyTraceID      = "trace_id"
+	KeyActor        = "actor"
+)
+
+type contextKey struct{}
+
+// New creates a logger for the given level and environment.
+// Production uses JSON output, everything else human-readable text.
+func New(w io.Writer, level, env string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	if env == "production" {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+
+	return slog.New(handler), nil
+}
+
+// ParseLevel converts a LOG_LEVEL value (debug/info/warn/error) to a slog level
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("invalid log level %q", level)
+	}
+}
+
+// WithLogger returns a copy of ctx carrying logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or fallback if there is none
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// Middleware stores a request-scoped logger tagged with the request ID in the
+// request context and logs each completed request. It must run after
+// middleware.RequestID and, to pick up the trace ID, tracing.Middleware.
+func Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			reqLogger := logger.With(KeyRequestID, middleware.GetReqID(r.Context()))
+			if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+				reqLogger = reqLogger.With(KeyTraceID, sc.TraceID().String())
+			}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+
+			defer func() {
+				reqLogger.Info("request completed",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"status", ww.Status(),
+					"bytes", ww.BytesWritten(),
+					"duration", time.Since(start),
+					"remote_addr", r.RemoteAddr,
+				)
+			}()
+
+			next.ServeHTTP(ww, r.WithContext(WithLogger(r.Context(), reqLogger)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
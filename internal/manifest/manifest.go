@@ -0,0 +1,181 @@
+// Package manifest reads and writes superfly.toml, the declarative
+// description of an app that is kept in git and applied with POST /api/apply.
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Version is the manifest format version this server reads and writes
+const Version = 1
+
+// Manifest describes everything about an app. Optional fields left out take
+// the same defaults as when creating an app through the API; applying a
+// manifest sets every field, so a removed field reverts to its default.
+//
+//	version = 1
+//	slug = "my-app"
+//	name = "My App"
+//	image = "nginx:alpine"
+//	port = 80
+//	replicas = 2
+//	domain = "example.com"
+//
+//	[[ports]]
+//	name = "http"
+//	port = 80
+//
+//	[[ports]]
+//	name = "grpc"
+//	port = 9000
+//	protocol = "grpc"
+//	path = "/my.v1.Service"
+//
+//	[resources]
+//	cpu = "500m"
+//	memory = "256Mi"
+//
+//	[health_check]
+//	path = "/healthz"
+//
+//	[health_check.startup]
+//	type = "tcp"
+//	failure_threshold = 60
+//
+//	[[env]]
+//	name = "DATABASE_URL"
+//	secret = { name = "my-app-db", key = "url" }
+type Manifest struct {
+	Version     int               `toml:"version"`
+	Slug        string            `toml:"slug"`
+	Name        string            `toml:"name"`
+	Namespace   string            `toml:"namespace,omitempty"`
+	Cluster     string            `toml:"cluster,omitempty"`
+	Image       string            `toml:"image,omitempty"`
+	Port        int32             `toml:"port,omitempty"`
+	Ports       []Port            `toml:"ports,omitempty"`
+	ServiceType string            `toml:"service_type,omitempty"`
+	Visibility  string            `toml:"visibility,omitempty"`
+	Aliases     []string          `toml:"aliases,omitempty"`
+	AllowFrom   []string          `toml:"allow_from,omitempty"`
+	Egress      string            `toml:"egress,omitempty"`
+	EgressCIDRs []string          `toml:"egress_cidrs,omitempty"`
+	Replicas    *int32            `toml:"replicas,omitempty"`
+	Domain      string            `toml:"domain,omitempty"`
+	Owner       string            `toml:"owner,omitempty"`
+	Labels      map[string]string `toml:"labels,omitempty"`
+	Resources   *Resources        `toml:"resources,omitempty"`
+	HealthCheck *HealthCheck      `toml:"health_check,omitempty"`
+	Env         []EnvVar          `toml:"env,omitempty"`
+	// AutoRollback rolls the app back to its previous revision when a
+	// rollout fails
+	AutoRollback bool `toml:"auto_rollback,omitempty"`
+	// Size is a size of the server's catalog, which decides the resources
+	// instead of [resources]
+	Size string `toml:"size,omitempty"`
+}
+
+// Port is a named container port. Protocol is http, http2, grpc, tcp or
+// udp; ServicePort defaults to Port.
+type Port struct {
+	Name        string `toml:"name"`
+	Port        int32  `toml:"port"`
+	Protocol    string `toml:"protocol,omitempty"`
+	ServicePort int32  `toml:"service_port,omitzero"`
+	NodePort    int32  `toml:"node_port,omitzero"`
+	Path        string `toml:"path,omitempty"`
+}
+
+// Resources are the container's compute resources. CPU, Memory and
+// EphemeralStorage are limits; a request left out is half its limit.
+type Resources struct {
+	CPU                     string `toml:"cpu,omitempty"`
+	CPURequest              string `toml:"cpu_request,omitempty"`
+	Memory                  string `toml:"memory,omitempty"`
+	MemoryRequest           string `toml:"memory_request,omitempty"`
+	EphemeralStorage        string `toml:"ephemeral_storage,omitempty"`
+	EphemeralStorageRequest string `toml:"ephemeral_storage_request,omitempty"`
+}
+
+// HealthCheck configures the container's health checks. Path is used by
+// the default liveness and readiness probes and by HTTP probes without a
+// path of their own.
+type HealthCheck struct {
+	Path      string `toml:"path,omitempty"`
+	Liveness  *Probe `toml:"liveness,omitempty"`
+	Readiness *Probe `toml:"readiness,omitempty"`
+	Startup   *Probe `toml:"startup,omitempty"`
+}
+
+// Probe overrides one container probe. Type is http, tcp, grpc or exec;
+// timings left out take the defaults of the kind of probe.
+type Probe struct {
+	Disabled            bool              `toml:"disabled,omitempty"`
+	Type                string            `toml:"type,omitempty"`
+	Path                string            `toml:"path,omitempty"`
+	Port                int32             `toml:"port,omitzero"`
+	Headers             map[string]string `toml:"headers,omitempty"`
+	Service             string            `toml:"service,omitempty"`
+	Command             []string          `toml:"command,omitempty"`
+	InitialDelaySeconds *int32            `toml:"initial_delay_seconds,omitempty"`
+	PeriodSeconds       *int32            `toml:"period_seconds,omitempty"`
+	TimeoutSeconds      *int32            `toml:"timeout_seconds,omitempty"`
+	SuccessThreshold    *int32            `toml:"success_threshold,omitempty"`
+	FailureThreshold    *int32            `toml:"failure_threshold,omitempty"`
+}
+
+// EnvVar is a container environment variable with either a literal value or
+// a reference to a key of a Kubernetes Secret
+type EnvVar struct {
+	Name   string     `toml:"name"`
+	Value  string     `toml:"value,omitempty"`
+	Secret *SecretRef `toml:"secret,omitempty"`
+}
+
+// SecretRef names a key of a Kubernetes Secret in the apps namespace
+type SecretRef struct {
+	Name string `toml:"name"`
+	Key  string `toml:"key"`
+}
+
+// Parse decodes a manifest. Unknown keys are rejected so that typos don't
+// silently fall back to defaults.
+func Parse(data []byte) (*Manifest, error) {
+	var m Manifest
+	meta, err := toml.Decode(string(data), &m)
+	if err != nil {
+		return nil, err
+	}
+
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, fmt.Errorf("unknown keys: %s", strings.Join(keys, ", "))
+	}
+
+	switch {
+	case m.Version == 0:
+		return nil, fmt.Errorf("version is required (current version is %d)", Version)
+	case m.Version > Version:
+		return nil, fmt.Errorf("unsupported version %d, this server reads version %d", m.Version, Version)
+	}
+
+	return &m, nil
+}
+
+// Encode renders a manifest as TOML
+func Encode(m *Manifest) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := toml.NewEncoder(&buf)
+	encoder.Indent = ""
+	if err := encoder.Encode(m); err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return buf.Bytes(), nil
+}
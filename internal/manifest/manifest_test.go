@@ -0,0 +1,148 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "minimal",
+			data: `
+version = 1
+slug = "web"
+name = "Web"
+image = "nginx:alpine"
+`,
+		},
+		{
+			name: "full",
+			data: `
+version = 1
+slug = "web"
+name = "Web"
+image = "nginx:alpine"
+port = 80
+replicas = 2
+domain = "example.com"
+size = "small"
+
+[[ports]]
+name = "http"
+port = 80
+
+[health_check]
+path = "/healthz"
+
+[health_check.startup]
+type = "tcp"
+failure_threshold = 60
+
+[[env]]
+name = "DATABASE_URL"
+secret = { name = "web-db", key = "url" }
+`,
+		},
+		{
+			name:    "missing version",
+			data:    `slug = "web"`,
+			wantErr: "version is required",
+		},
+		{
+			name:    "newer version",
+			data:    "version = 2",
+			wantErr: "unsupported version 2",
+		},
+		{
+			name: "typo",
+			data: `
+version = 1
+replica = 2
+`,
+			wantErr: "unknown keys: replica",
+		},
+		{
+			name: "nested typo",
+			data: `
+version = 1
+[resources]
+cpus = "1"
+`,
+			wantErr: "unknown keys: resources.cpus",
+		},
+		{
+			name: "build section",
+			data: `
+version = 1
+[build]
+dockerfile = "Dockerfile"
+`,
+			wantErr: "unknown keys: build, build.dockerfile",
+		},
+		{
+			name:    "invalid TOML",
+			data:    "version = ",
+			wantErr: "toml:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Parse failed: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("Parse error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	replicas := int32(0)
+	failureThreshold := int32(60)
+	m := &Manifest{
+		Version:  Version,
+		Slug:     "web",
+		Name:     "Web",
+		Image:    "nginx:alpine",
+		Port:     80,
+		Replicas: &replicas,
+		Domain:   "example.com",
+		Labels:   map[string]string{"team": "web"},
+		Ports:    []Port{{Name: "http", Port: 80}, {Name: "grpc", Port: 9000, Protocol: "grpc", ServicePort: 90}},
+		Resources: &Resources{
+			CPU:           "500m",
+			Memory:        "256Mi",
+			MemoryRequest: "128Mi",
+		},
+		HealthCheck: &HealthCheck{
+			Path:    "/healthz",
+			Startup: &Probe{Type: "tcp", FailureThreshold: &failureThreshold},
+		},
+		Env: []EnvVar{
+			{Name: "MODE", Value: "prod"},
+			{Name: "DATABASE_URL", Secret: &SecretRef{Name: "web-db", Key: "url"}},
+		},
+		AutoRollback: true,
+	}
+
+	data, err := Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse of encoded manifest failed: %v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("round trip changed the manifest:\ngot  %+v\nwant %+v", got, m)
+	}
+}
@@ -0,0 +1,166 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Document is an OpenAPI 3 document. Only the subset of the specification
+// superfly uses is modelled.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem maps lower-case HTTP methods to operations
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// NewDocument creates an empty OpenAPI 3.0 document
+func NewDocument(title, version string) *Document {
+	return &Document{
+		OpenAPI:    "3.0.3",
+		Info:       Info{Title: title, Version: version},
+		Paths:      make(map[string]*PathItem),
+		Components: Components{Schemas: make(map[string]*Schema)},
+	}
+}
+
+// Schema registers the schema derived from v as a named component and
+// returns a reference to it
+func (d *Document) Schema(name string, v any) *Schema {
+	d.Components.Schemas[name] = SchemaFor(v)
+	return Ref(name)
+}
+
+// Add registers an operation. Paths use chi's {param} syntax, which is also
+// what OpenAPI expects.
+func (d *Document) Add(method, path string, op *Operation) {
+	item, ok := d.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+	(*item)[strings.ToLower(method)] = op
+}
+
+// RequestSchema returns the resolved JSON request body schema of an
+// operation, or nil if the operation does not exist or takes no body
+func (d *Document) RequestSchema(operationID string) *Schema {
+	for _, item := range d.Paths {
+		for _, op := range *item {
+			if op.OperationID != operationID || op.RequestBody == nil {
+				continue
+			}
+			if media, ok := op.RequestBody.Content["application/json"]; ok {
+				return d.Resolve(media.Schema)
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve follows a component reference
+func (d *Document) Resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, refPrefix)]
+	}
+	return s
+}
+
+// CheckRoutes verifies that the router and the document describe exactly the
+// same set of routes, so an endpoint cannot be added without documenting it
+func (d *Document) CheckRoutes(routes chi.Routes) error {
+	documented := make(map[string]bool)
+	for path, item := range d.Paths {
+		for method := range *item {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	var problems []string
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if method == http.MethodOptions || method == http.MethodHead {
+			return nil
+		}
+		if len(route) > 1 {
+			route = strings.TrimSuffix(route, "/")
+		}
+		key := method + " " + route
+		if !documented[key] {
+			problems = append(problems, key+" is not documented")
+		}
+		delete(documented, key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk routes: %w", err)
+	}
+
+	for key := range documented {
+		problems = append(problems, key+" is documented but not routed")
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("OpenAPI document out of sync with router: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// JSON returns a content map with a single application/json entry
+func JSON(s *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: s}}
+}
@@ -0,0 +1,204 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const refPrefix = "#/components/schemas/"
+
+// Schema is the subset of the OpenAPI schema object superfly uses
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+}
+
+// Ref returns a reference to a named component schema
+func Ref(name string) *Schema {
+	return &Schema{Ref: refPrefix + name}
+}
+
+// knownTypes maps types whose JSON encoding is not apparent from their Go
+// structure to their schema
+var knownTypes = map[reflect.Type]Schema{
+	reflect.TypeOf(time.Time{}):          {Type: "string", Format: "date-time"},
+	reflect.TypeOf(uuid.UUID{}):          {Type: "string", Format: "uuid"},
+	reflect.TypeOf(pgtype.Timestamptz{}): {Type: "string", Format: "date-time", Nullable: true},
+	reflect.TypeOf(pgtype.Text{}):        {Type: "string", Nullable: true},
+	// Stored JSON columns; any JSON value
+	reflect.TypeOf(json.RawMessage{}): {},
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// SchemaFor derives a schema from a Go value using its JSON encoding rules.
+// Struct fields are required unless they are pointers or tagged omitempty.
+// Validation constraints are read from the `openapi` struct tag, e.g.
+//
+//	Port int32 `json:"port" openapi:"minimum=1,maximum=65535"`
+//
+// Supported keys are minLength, maxLength, minimum, maximum, format, enum
+// (values separated by |) and pattern, which must come last since the
+// expression may itself contain commas.
+func SchemaFor(v any) *Schema {
+	return schemaForType(reflect.TypeOf(v))
+}
+
+func schemaForType(t reflect.Type) *Schema {
+	if known, ok := knownTypes[t]; ok {
+		s := known
+		return &s
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := schemaForType(t.Elem())
+		s.Nullable = true
+		return s
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int32, reflect.Uint32, reflect.Int16, reflect.Uint16, reflect.Int8, reflect.Uint8:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Implements(textMarshalerType) {
+			return &Schema{Type: "string"}
+		}
+		return &Schema{Type: "array", Items: schemaForType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaForType(t.Elem())}
+	case reflect.Struct:
+		return schemaForStruct(t)
+	default:
+		return &Schema{}
+	}
+}
+
+func schemaForStruct(t reflect.Type) *Schema {
+	// Unknown fields would be silently dropped by the JSON decoder, so
+	// reject them to surface typos like "replica"
+	s := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: false,
+	}
+
+	var embedded []*Schema
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		// Like encoding/json, inline the fields of untagged embedded structs
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, schemaForStruct(field.Type))
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := schemaForType(field.Type)
+		applyTag(prop, field.Tag.Get("openapi"))
+		s.Properties[name] = prop
+
+		if field.Type.Kind() != reflect.Pointer && !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	// Fields of the outer struct hide embedded fields of the same name
+	for _, e := range embedded {
+		for name, prop := range e.Properties {
+			if _, ok := s.Properties[name]; !ok {
+				s.Properties[name] = prop
+			}
+		}
+		for _, name := range e.Required {
+			if s.Properties[name] == e.Properties[name] {
+				s.Required = append(s.Required, name)
+			}
+		}
+	}
+
+	return s
+}
+
+// applyTag applies the constraints from an `openapi` struct tag
+func applyTag(s *Schema, tag string) {
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, "pattern=") {
+			part, tag = tag, ""
+		} else {
+			part, tag, _ = strings.Cut(tag, ",")
+		}
+
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "minLength":
+			s.MinLength = mustInt(key, value)
+		case "maxLength":
+			s.MaxLength = mustInt(key, value)
+		case "minimum":
+			s.Minimum = mustFloat(key, value)
+		case "maximum":
+			s.Maximum = mustFloat(key, value)
+		case "format":
+			s.Format = value
+		case "enum":
+			s.Enum = strings.Split(value, "|")
+		case "pattern":
+			s.Pattern = value
+		default:
+			panic(fmt.Sprintf("openapi: unknown tag key %q", key))
+		}
+	}
+}
+
+func mustInt(key, value string) *int {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		panic(fmt.Sprintf("openapi: invalid %s %q", key, value))
+	}
+	return &n
+}
+
+func mustFloat(key, value string) *float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		panic(fmt.Sprintf("openapi: invalid %s %q", key, value))
+	}
+	return &f
+}
@@ -0,0 +1,185 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError describes why a single field failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validate checks a decoded JSON value against schema. Numbers must be
+// decoded as json.Number (see json.Decoder.UseNumber) so integers can be
+// told apart from fractions.
+func (d *Document) Validate(schema *Schema, value any) []FieldError {
+	var errs []FieldError
+	d.validate(schema, value, "", &errs)
+	return errs
+}
+
+func (d *Document) validate(schema *Schema, value any, path string, errs *[]FieldError) {
+	schema = d.Resolve(schema)
+	if schema == nil {
+		return
+	}
+
+	fail := func(format string, args ...any) {
+		field := path
+		if field == "" {
+			field = "body"
+		}
+		*errs = append(*errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if value == nil {
+		if !schema.Nullable && schema.Type != "" {
+			fail("must not be null")
+		}
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		d.validateObject(schema, obj, path, errs)
+
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		for i, item := range items {
+			d.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		length := utf8.RuneCountInString(str)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			if *schema.MinLength == 1 {
+				fail("must not be empty")
+			} else {
+				fail("must be at least %d characters", *schema.MinLength)
+			}
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			fail("must be at most %d characters", *schema.MaxLength)
+		}
+		if schema.Pattern != "" && !compilePattern(schema.Pattern).MatchString(str) {
+			fail("must match pattern %s", schema.Pattern)
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, str) {
+			fail("must be one of %v", schema.Enum)
+		}
+
+	case "integer", "number":
+		num, ok := value.(json.Number)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		f, err := num.Float64()
+		if err != nil {
+			fail("must be a number")
+			return
+		}
+		if schema.Type == "integer" {
+			if _, err := strconv.ParseInt(num.String(), 10, 64); err != nil {
+				fail("must be an integer")
+				return
+			}
+			if schema.Format == "int32" && (f < -1<<31 || f > 1<<31-1) {
+				fail("must fit in a 32-bit integer")
+				return
+			}
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			fail("must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			fail("must be at most %v", *schema.Maximum)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be a boolean")
+		}
+	}
+}
+
+func (d *Document) validateObject(schema *Schema, obj map[string]any, path string, errs *[]FieldError) {
+	for _, name := range schema.Required {
+		if _, ok := obj[name]; !ok {
+			*errs = append(*errs, FieldError{Field: join(path, name), Message: "is required"})
+		}
+	}
+
+	// Iterate in a stable order so error lists are deterministic
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prop, ok := schema.Properties[name]
+		if !ok {
+			switch extra := schema.AdditionalProperties.(type) {
+			case bool:
+				if !extra {
+					*errs = append(*errs, FieldError{Field: join(path, name), Message: "is not a known field"})
+				}
+			case *Schema:
+				d.validate(extra, obj[name], join(path, name), errs)
+			}
+			continue
+		}
+		d.validate(prop, obj[name], join(path, name), errs)
+	}
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var patternCache sync.Map
+
+// compilePattern compiles and caches a schema pattern. Patterns come from
+// struct tags, so an invalid one is a programming error.
+func compilePattern(pattern string) *regexp.Regexp {
+	if re, ok := patternCache.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	patternCache.Store(pattern, re)
+	return re
+}
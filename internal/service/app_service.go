@@ -2,31 +2,62 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
-	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/superfly/superfly/internal/db"
 	"github.com/superfly/superfly/internal/k8s"
+	"github.com/superfly/superfly/internal/logging"
+	"github.com/superfly/superfly/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 type AppService struct {
-	pool      *pgxpool.Pool
-	queries   *db.Queries
-	k8sClient *k8s.Client
+	pool       *pgxpool.Pool
+	queries    *db.Queries
+	clusters   *k8s.Pool
+	namespaces Namespaces
+	sizes      *Sizes
+	logger     *slog.Logger
+	deploys    *deployTracker
+	events     *EventService
 }
 
-func NewAppService(pool *pgxpool.Pool, k8sClient *k8s.Client) *AppService {
+// NewAppService creates the app service. Apps are deployed to the clusters
+// of the pool, in namespaces decided by namespaces, with sizes from the
+// sizes catalog, if any, and what happens to them is recorded with events.
+func NewAppService(pool *pgxpool.Pool, clusters *k8s.Pool, namespaces Namespaces, sizes *Sizes, events *EventService, logger *slog.Logger) *AppService {
 	return &AppService{
-		pool:      pool,
-		queries:   db.New(pool),
-		k8sClient: k8sClient,
+		pool:       pool,
+		queries:    db.New(pool),
+		clusters:   clusters,
+		namespaces: namespaces,
+		sizes:      sizes,
+		logger:     logger,
+		deploys:    newDeployTracker(),
+		events:     events,
 	}
 }
 
+// log returns the request-scoped logger from ctx tagged with the app's ID and slug
+func (s *AppService) log(ctx context.Context, app *db.App) *slog.Logger {
+	return logging.FromContext(ctx, s.logger).With(
+		logging.KeyAppID, app.ID,
+		logging.KeyAppSlug, app.Slug,
+	)
+}
+
 type CreateAppInput struct {
 	Name            string
 	Slug            string
@@ -37,6 +68,32 @@ type CreateAppInput struct {
 	MemoryLimit     string
 	Domain          string
 	HealthCheckPath string
+	Owner           string
+	Labels          map[string]string
+	Env             []EnvVar
+	HealthChecks    HealthChecks
+	Ports           []Port
+	ServiceType     string
+	Visibility      string
+	Aliases         []string
+	AllowFrom       []string
+	Egress          string
+	EgressCIDRs     []string
+	Namespace       string // derived from the owner when empty
+	Cluster         string // the default cluster when empty
+	AutoRollback    bool
+
+	// Requests left empty are half their limits, and ephemeral storage is
+	// neither reserved nor capped unless set
+	CPURequest              string
+	MemoryRequest           string
+	EphemeralStorageRequest string
+	EphemeralStorageLimit   string
+
+	// Size is a size of the catalog, which decides the app's resources
+	// instead. Apps that neither pick a size nor set resources get the
+	// default size.
+	Size string
 }
 
 type UpdateAppInput struct {
@@ -48,6 +105,35 @@ type UpdateAppInput struct {
 	MemoryLimit     *string
 	Domain          *string
 	HealthCheckPath *string
+	Owner           *string
+	Labels          map[string]string // replaces all labels when non-nil
+	Env             []EnvVar          // replaces the whole env when non-nil
+	HealthChecks    *HealthChecks     // replaces all probe overrides when non-nil
+	Ports           []Port            // replaces all ports when non-nil
+	ServiceType     *string
+	Visibility      *string
+	Aliases         []string // replaces all aliases when non-nil
+	AllowFrom       []string // replaces all callers when non-nil
+	Egress          *string
+	EgressCIDRs     []string // replaces all egress CIDRs when non-nil
+	Namespace       *string  // moves the app to another namespace
+	Cluster         *string  // moves the app to another cluster
+	AutoRollback    *bool
+
+	// Setting a request to "" makes it half its limit again
+	CPURequest              *string
+	MemoryRequest           *string
+	EphemeralStorageRequest *string
+	EphemeralStorageLimit   *string
+
+	// Size switches the app to a size of the catalog, or with "" to the
+	// resources above
+	Size *string
+
+	// IfMatch lists the versions the caller expects the app to be at. When
+	// non-empty the update fails with ErrPreconditionFailed unless the app
+	// is at one of them.
+	IfMatch []int64
 }
 
 // CreateApp creates a new app and deploys it to Kubernetes
@@ -61,27 +147,57 @@ func (s *AppService) CreateApp(ctx context.Context, input CreateAppInput) (*db.A
 	if err := validateSlug(input.Slug); err != nil {
 		return nil, err
 	}
+	if err := validateLabels(input.Labels); err != nil {
+		return nil, err
+	}
+	if err := validateEnv(input.Env); err != nil {
+		return nil, err
+	}
+	if err := validateHealthChecks(&input.HealthChecks); err != nil {
+		return nil, err
+	}
+	if input.Namespace == "" {
+		input.Namespace = s.namespaces.forOwner(input.Owner)
+	}
+	if err := validateNamespace(input.Namespace); err != nil {
+		return nil, err
+	}
+	if input.Cluster == "" {
+		input.Cluster = s.clusters.Default()
+	}
+	if err := s.validateCluster(input.Cluster); err != nil {
+		return nil, err
+	}
 
 	// Check if slug already exists
 	exists, err := s.queries.CheckSlugExists(ctx, input.Slug)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check slug: %w", err)
+		return nil, dbError(err, "failed to check slug")
 	}
 	if exists {
-		return nil, fmt.Errorf("app with slug '%s' already exists", input.Slug)
+		return nil, conflict(CodeSlugTaken, "app with slug '%s' already exists", input.Slug)
 	}
 
 	// Check if domain already exists
 	if input.Domain != "" {
 		exists, err := s.queries.CheckDomainExists(ctx, input.Domain)
 		if err != nil {
-			return nil, fmt.Errorf("failed to check domain: %w", err)
+			return nil, dbError(err, "failed to check domain")
 		}
 		if exists {
-			return nil, fmt.Errorf("domain '%s' already in use", input.Domain)
+			return nil, conflict(CodeDomainTaken, "domain '%s' already in use", input.Domain)
 		}
 	}
 
+	custom := input.CPULimit != "" || input.MemoryLimit != "" || input.CPURequest != "" ||
+		input.MemoryRequest != "" || input.EphemeralStorageRequest != "" || input.EphemeralStorageLimit != ""
+	if input.Size == "" && !custom {
+		input.Size = s.sizes.Default()
+	}
+	if err := s.checkSize(ctx, input.Size, custom); err != nil {
+		return nil, err
+	}
+
 	// Set defaults
 	if input.Port == 0 {
 		input.Port = 8080
@@ -90,132 +206,376 @@ func (s *AppService) CreateApp(ctx context.Context, input CreateAppInput) (*db.A
 		input.Replicas = 1
 	}
 	if input.CPULimit == "" {
-		input.CPULimit = "500m"
+		input.CPULimit = defaultCPULimit
 	}
 	if input.MemoryLimit == "" {
-		input.MemoryLimit = "256Mi"
+		input.MemoryLimit = defaultMemoryLimit
+	}
+	err = validateResources(resourceConfig{
+		CPURequest:              input.CPURequest,
+		CPULimit:                input.CPULimit,
+		MemoryRequest:           input.MemoryRequest,
+		MemoryLimit:             input.MemoryLimit,
+		EphemeralStorageRequest: input.EphemeralStorageRequest,
+		EphemeralStorageLimit:   input.EphemeralStorageLimit,
+	})
+	if err != nil {
+		return nil, err
 	}
 	if input.HealthCheckPath == "" {
 		input.HealthCheckPath = "/"
 	}
+	if input.Labels == nil {
+		input.Labels = map[string]string{}
+	}
+	if input.ServiceType == "" {
+		input.ServiceType = ServiceTypeClusterIP
+	}
+	if input.Visibility == "" {
+		input.Visibility = VisibilityPublic
+	}
+	if input.Aliases == nil {
+		input.Aliases = []string{}
+	}
+	if input.AllowFrom == nil {
+		input.AllowFrom = []string{}
+	}
+	if input.Egress == "" {
+		input.Egress = EgressAllowAll
+	}
+	if input.EgressCIDRs == nil {
+		input.EgressCIDRs = []string{}
+	}
+	err = validateNetwork(input.Slug, networkConfig{
+		Port:        input.Port,
+		Ports:       input.Ports,
+		Domain:      input.Domain,
+		ServiceType: input.ServiceType,
+		Visibility:  input.Visibility,
+		Aliases:     input.Aliases,
+		AllowFrom:   input.AllowFrom,
+		Egress:      input.Egress,
+		EgressCIDRs: input.EgressCIDRs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAliases(ctx, uuid.Nil, input.Aliases); err != nil {
+		return nil, err
+	}
+
+	labels, err := json.Marshal(input.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode labels: %w", err)
+	}
+	env, err := encodeEnv(input.Env)
+	if err != nil {
+		return nil, err
+	}
+	healthChecks, err := encodeHealthChecks(input.HealthChecks)
+	if err != nil {
+		return nil, err
+	}
+	ports, err := encodePorts(input.Ports)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create app in database
 	app, err := s.queries.CreateApp(ctx, db.CreateAppParams{
-		Slug:            input.Slug,
-		Name:            input.Name,
-		Image:           input.Image,
-		Port:            input.Port,
-		Replicas:        input.Replicas,
-		CpuLimit:        input.CPULimit,
-		MemoryLimit:     input.MemoryLimit,
-		Domain:          input.Domain,
-		HealthCheckPath: input.HealthCheckPath,
-		Status:          "pending",
+		Slug:                    input.Slug,
+		Name:                    input.Name,
+		Image:                   input.Image,
+		Port:                    input.Port,
+		Replicas:                input.Replicas,
+		CpuLimit:                input.CPULimit,
+		MemoryLimit:             input.MemoryLimit,
+		Domain:                  input.Domain,
+		HealthCheckPath:         input.HealthCheckPath,
+		Status:                  "pending",
+		Owner:                   input.Owner,
+		Labels:                  labels,
+		Env:                     env,
+		HealthChecks:            healthChecks,
+		Ports:                   ports,
+		ServiceType:             input.ServiceType,
+		Visibility:              input.Visibility,
+		Aliases:                 input.Aliases,
+		AllowFrom:               input.AllowFrom,
+		Egress:                  input.Egress,
+		EgressCidrs:             input.EgressCIDRs,
+		Namespace:               input.Namespace,
+		Cluster:                 input.Cluster,
+		AutoRollback:            input.AutoRollback,
+		CpuRequest:              input.CPURequest,
+		MemoryRequest:           input.MemoryRequest,
+		EphemeralStorageRequest: input.EphemeralStorageRequest,
+		EphemeralStorageLimit:   input.EphemeralStorageLimit,
+		Size:                    input.Size,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create app: %w", err)
+		return nil, dbError(err, "failed to create app")
 	}
 
+	s.log(ctx, &app).Info("app created", "image", app.Image)
+
 	// Deploy to Kubernetes
+	s.startDeploy(ctx, app)
+
+	return &app, nil
+}
+
+// startDeploy runs deployApp in the background. The deploy outlives the
+// request, so it gets a fresh context that only inherits the request's logger,
+// tagged with a deployment ID to tie together every line of this rollout,
+// and a new trace linked to the originating request span.
+func (s *AppService) startDeploy(ctx context.Context, app db.App) {
+	deploymentID := uuid.NewString()
+	logger := s.log(ctx, &app).With(logging.KeyDeploymentID, deploymentID)
+	link := trace.LinkFromContext(ctx)
+
+	s.deploys.start(deploymentID, app.Slug)
+
 	go func() {
-		deployCtx := context.Background()
-		if err := s.deployApp(deployCtx, &app); err != nil {
-			// Update status to failed
-			_, _ = s.queries.UpdateAppStatus(deployCtx, db.UpdateAppStatusParams{
-				ID:     app.ID,
-				Status: "failed",
-			})
+		defer s.deploys.finish(deploymentID)
+
+		deployCtx, span := tracing.Tracer().Start(context.Background(), "deployApp",
+			trace.WithNewRoot(),
+			trace.WithLinks(link),
+			trace.WithAttributes(
+				attribute.String("superfly.app.id", app.ID.String()),
+				attribute.String("superfly.app.slug", app.Slug),
+				attribute.String("superfly.deployment.id", deploymentID),
+			),
+		)
+		deployCtx = logging.WithLogger(deployCtx, logger)
+
+		err := s.deployApp(deployCtx, deploymentID, &app)
+		defer tracing.End(span, err)
+
+		if err != nil {
+			logger.Error("deploy failed", "error", err)
+
+			// Update status to failed, saying why
+			if err := s.setStatus(deployCtx, &app, "failed", err.Error()); err != nil {
+				logger.Error("failed to mark app as failed", "error", err)
+			}
 		}
 	}()
-
-	return &app, nil
 }
 
 // deployApp deploys an app to Kubernetes
-func (s *AppService) deployApp(ctx context.Context, app *db.App) error {
+func (s *AppService) deployApp(ctx context.Context, deploymentID string, app *db.App) error {
+	logger := logging.FromContext(ctx, s.logger)
+	logger.Info("deploy started", "image", app.Image, "replicas", app.Replicas, "cluster", app.Cluster)
+
 	// Update status to deploying
-	_, err := s.queries.UpdateAppStatus(ctx, db.UpdateAppStatusParams{
-		ID:     app.ID,
-		Status: "deploying",
-	})
-	if err != nil {
+	if err := s.setStatus(ctx, app, "deploying", ""); err != nil {
 		return fmt.Errorf("failed to update status: %w", err)
 	}
 
+	client, err := s.client(app.Cluster)
+	if err != nil {
+		return err
+	}
+
+	s.progress(ctx, deploymentID, app, "ensuring namespace")
+
 	// Ensure namespace exists
-	if err := s.k8sClient.EnsureNamespace(ctx); err != nil {
+	if err := client.EnsureNamespace(ctx, app.Namespace); err != nil {
 		return fmt.Errorf("failed to ensure namespace: %w", err)
 	}
 
-	spec := k8s.AppSpec{
-		Name:            app.Name,
-		Slug:            app.Slug,
-		Image:           app.Image,
-		Port:            app.Port,
-		Replicas:        app.Replicas,
-		CPULimit:        app.CpuLimit,
-		MemoryLimit:     app.MemoryLimit,
-		Domain:          app.Domain,
-		HealthCheckPath: app.HealthCheckPath,
+	config, err := configFromApp(app)
+	if err != nil {
+		return err
+	}
+	spec, err := s.spec(&config, app.Slug)
+	if err != nil {
+		return err
+	}
+
+	s.progress(ctx, deploymentID, app, "applying network policies")
+
+	// Before the pods start, so they come up with their traffic allowed
+	if err := s.applyNetworkPolicies(ctx, client, spec); err != nil {
+		return err
 	}
 
+	s.progress(ctx, deploymentID, app, "applying deployment")
+
 	// Create Deployment
-	deployment := k8s.BuildDeployment(spec)
-	if err := s.k8sClient.ApplyDeployment(ctx, deployment); err != nil {
+	deployment, err := k8s.BuildDeployment(spec)
+	if err != nil {
+		return err
+	}
+	if err := client.ApplyDeployment(ctx, deployment); err != nil {
 		return fmt.Errorf("failed to apply deployment: %w", err)
 	}
 
-	// Create Service
-	service := k8s.BuildService(spec)
-	if err := s.k8sClient.ApplyService(ctx, service); err != nil {
-		return fmt.Errorf("failed to apply service: %w", err)
+	s.progress(ctx, deploymentID, app, "applying service")
+
+	// Create Service and its aliases, unless the app takes no traffic
+	if spec.Visibility != VisibilityNone {
+		service := k8s.BuildService(spec)
+		if err := client.ApplyService(ctx, service); err != nil {
+			return fmt.Errorf("failed to apply service: %w", err)
+		}
+		for _, alias := range spec.Aliases {
+			if err := client.ApplyService(ctx, k8s.BuildAliasService(spec, alias)); err != nil {
+				return fmt.Errorf("failed to apply alias service: %w", err)
+			}
+		}
+	} else if err := client.DeleteService(ctx, app.Namespace, app.Slug); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	if err := client.PruneAliasServices(ctx, app.Namespace, app.Slug, spec.Aliases); err != nil {
+		return fmt.Errorf("failed to prune alias services: %w", err)
 	}
 
-	// Create Ingress if domain is provided
-	if app.Domain != "" {
+	s.progress(ctx, deploymentID, app, "applying ingress")
+
+	// Create Ingress for public apps with a domain, and remove it otherwise
+	if spec.Visibility == VisibilityPublic && app.Domain != "" {
 		ingress := k8s.BuildIngress(spec)
-		if err := s.k8sClient.ApplyIngress(ctx, ingress); err != nil {
+		if err := client.ApplyIngress(ctx, ingress); err != nil {
 			return fmt.Errorf("failed to apply ingress: %w", err)
 		}
+	} else if err := client.DeleteIngress(ctx, app.Namespace, app.Slug); err != nil {
+		return fmt.Errorf("failed to delete ingress: %w", err)
 	}
 
-	// Wait for deployment to be ready (with timeout)
-	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-	defer cancel()
+	s.progress(ctx, deploymentID, app, "waiting for rollout")
+
+	if err := s.waitForRollout(ctx, client, deploymentID, app); err != nil {
+		return err
+	}
 
-	if err := s.k8sClient.WaitForDeployment(waitCtx, app.Slug, 5*time.Minute); err != nil {
-		// Don't fail completely, just log warning
-		// Deployment might still succeed after we return
+	// Now that the app runs in its cluster and namespace, remove what it
+	// left in those it was moved out of
+	if err := s.pruneOtherCopies(ctx, app); err != nil {
+		logger.Warn("failed to prune other copies", "error", err)
 	}
 
+	s.progress(ctx, deploymentID, app, "marking running")
+
 	// Update status to running
-	_, err = s.queries.UpdateAppStatus(ctx, db.UpdateAppStatusParams{
-		ID:     app.ID,
-		Status: "running",
-	})
-	if err != nil {
+	if err := s.setStatus(ctx, app, "running", ""); err != nil {
 		return fmt.Errorf("failed to update status: %w", err)
 	}
 
+	logger.Info("deploy finished", "status", "running")
+
 	return nil
 }
 
+// StalledDeploys returns background deploys that have stopped making progress
+func (s *AppService) StalledDeploys() []StalledDeploy {
+	return s.deploys.stalled(deployStallTimeout)
+}
+
 // GetApp gets an app by ID
 func (s *AppService) GetApp(ctx context.Context, id uuid.UUID) (*db.App, error) {
 	app, err := s.queries.GetApp(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get app: %w", err)
+		return nil, dbError(err, "failed to get app")
+	}
+	return &app, nil
+}
+
+// ResolveApp gets an app by ID or slug. A reference that parses as a UUID is
+// tried as an ID first and then as a slug, since a slug may look like one.
+func (s *AppService) ResolveApp(ctx context.Context, ref string) (*db.App, error) {
+	if id, err := uuid.Parse(ref); err == nil {
+		app, err := s.queries.GetApp(ctx, id)
+		if err == nil {
+			return &app, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, dbError(err, "failed to get app")
+		}
+	}
+
+	app, err := s.queries.GetAppBySlug(ctx, ref)
+	if err != nil {
+		return nil, dbError(err, "failed to get app")
 	}
 	return &app, nil
 }
 
-// ListApps lists all apps
-func (s *AppService) ListApps(ctx context.Context) ([]db.App, error) {
-	apps, err := s.queries.ListApps(ctx)
+// ListApps lists one page of apps matching the input's filters
+func (s *AppService) ListApps(ctx context.Context, input ListAppsInput) (*ListAppsResult, error) {
+	var fields []FieldError
+
+	switch input.Sort {
+	case "":
+		input.Sort = SortCreatedAt
+	case SortCreatedAt, SortUpdatedAt, SortName, SortSlug:
+	default:
+		fields = append(fields, FieldError{Field: "sort", Message: "must be one of created_at, updated_at, name, slug"})
+	}
+
+	switch {
+	case input.Limit == 0:
+		input.Limit = defaultPageSize
+	case input.Limit < 0 || input.Limit > maxPageSize:
+		fields = append(fields, FieldError{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", maxPageSize)})
+	}
+
+	if input.Status != "" && !appStatuses[input.Status] {
+		fields = append(fields, FieldError{Field: "status", Message: "must be one of pending, deploying, running, failed"})
+	}
+
+	filter := appFilter{
+		Status: optional(input.Status),
+		Domain: optional(input.Domain),
+		Owner:  optional(input.Owner),
+	}
+	if input.Search != "" {
+		filter.Search = optional(escapeLike(input.Search))
+	}
+	if input.Image != "" {
+		filter.Image = optional(escapeLike(input.Image))
+	}
+	if len(input.Labels) > 0 {
+		labels, err := json.Marshal(input.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode labels: %w", err)
+		}
+		filter.Labels = labels
+	}
+
+	var cursor *pageCursor
+	if input.Cursor != "" {
+		decoded, err := decodeCursor(input.Cursor)
+		switch {
+		case err != nil || isTimeSort(decoded.Sort) != (decoded.Time != nil):
+			fields = append(fields, FieldError{Field: "cursor", Message: "is malformed"})
+		case decoded.Sort != input.Sort || decoded.Descending != input.Descending:
+			fields = append(fields, FieldError{Field: "cursor", Message: "was issued for a different sort order"})
+		default:
+			cursor = &decoded
+		}
+	}
+
+	if len(fields) > 0 {
+		return nil, validationFailed(fields...)
+	}
+
+	// Fetch one extra row to learn whether there is a next page
+	apps, err := listAppsPage(ctx, s.queries, input.Sort, input.Descending, filter, cursor, input.Limit+1)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list apps: %w", err)
+		return nil, dbError(err, "failed to list apps")
+	}
+
+	result := &ListAppsResult{Apps: apps}
+	if len(apps) > int(input.Limit) {
+		result.Apps = apps[:input.Limit]
+		last := &result.Apps[len(result.Apps)-1]
+		result.NextCursor = encodeCursor(cursorAfter(last, input.Sort, input.Descending))
 	}
-	return apps, nil
+
+	return result, nil
 }
 
 // UpdateApp updates an app and redeploys if necessary
@@ -223,69 +583,243 @@ func (s *AppService) UpdateApp(ctx context.Context, id uuid.UUID, input UpdateAp
 	// Get current app
 	currentApp, err := s.queries.GetApp(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get app: %w", err)
+		return nil, dbError(err, "failed to get app")
+	}
+
+	if len(input.IfMatch) > 0 && !slices.Contains(input.IfMatch, currentApp.Version) {
+		return nil, preconditionFailed("app is at version %d, not the expected version", currentApp.Version)
+	}
+
+	if err := validateLabels(input.Labels); err != nil {
+		return nil, err
+	}
+	if err := validateEnv(input.Env); err != nil {
+		return nil, err
+	}
+	if err := validateHealthChecks(input.HealthChecks); err != nil {
+		return nil, err
+	}
+	if input.Ports != nil || input.Port != nil || input.Domain != nil || input.ServiceType != nil ||
+		input.Visibility != nil || input.Aliases != nil || input.AllowFrom != nil ||
+		input.Egress != nil || input.EgressCIDRs != nil {
+		if err := validateUpdatedNetwork(&currentApp, input); err != nil {
+			return nil, err
+		}
+	}
+	custom := input.CPULimit != nil || input.MemoryLimit != nil || input.CPURequest != nil ||
+		input.MemoryRequest != nil || input.EphemeralStorageRequest != nil || input.EphemeralStorageLimit != nil
+	size := currentApp.Size
+	if input.Size != nil {
+		size = *input.Size
+	}
+	// Going from a size back to resources of the app's own sets them too
+	if err := s.checkSize(ctx, size, custom || (size == "" && currentApp.Size != "")); err != nil {
+		return nil, err
+	}
+	if input.Size != nil && *input.Size != "" {
+		// An app with a size keeps the default resources, which apply
+		// again should it stop using a size
+		cpuLimit, memoryLimit := defaultCPULimit, defaultMemoryLimit
+		input.CPULimit, input.MemoryLimit = &cpuLimit, &memoryLimit
+		input.CPURequest, input.MemoryRequest = new(string), new(string)
+		input.EphemeralStorageRequest, input.EphemeralStorageLimit = new(string), new(string)
+	}
+	if custom {
+		if err := validateUpdatedResources(&currentApp, input); err != nil {
+			return nil, err
+		}
+	}
+	if input.Aliases != nil {
+		if err := s.checkAliases(ctx, id, input.Aliases); err != nil {
+			return nil, err
+		}
+	}
+	if input.Namespace != nil {
+		if err := validateNamespace(*input.Namespace); err != nil {
+			return nil, err
+		}
+	}
+	if input.Cluster != nil {
+		if err := s.validateCluster(*input.Cluster); err != nil {
+			return nil, err
+		}
 	}
 
 	// Check if domain changed and if new domain is available
 	if input.Domain != nil && *input.Domain != currentApp.Domain {
 		exists, err := s.queries.CheckDomainExists(ctx, *input.Domain)
 		if err != nil {
-			return nil, fmt.Errorf("failed to check domain: %w", err)
+			return nil, dbError(err, "failed to check domain")
 		}
 		if exists {
-			return nil, fmt.Errorf("domain '%s' already in use", *input.Domain)
+			return nil, conflict(CodeDomainTaken, "domain '%s' already in use", *input.Domain)
+		}
+	}
+
+	var labels json.RawMessage
+	if input.Labels != nil {
+		labels, err = json.Marshal(input.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode labels: %w", err)
+		}
+	}
+	var env json.RawMessage
+	if input.Env != nil {
+		env, err = encodeEnv(input.Env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var healthChecks json.RawMessage
+	if input.HealthChecks != nil {
+		healthChecks, err = encodeHealthChecks(*input.HealthChecks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var ports json.RawMessage
+	if input.Ports != nil {
+		ports, err = encodePorts(input.Ports)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	// Update app in database
 	app, err := s.queries.UpdateApp(ctx, db.UpdateAppParams{
-		ID:              id,
-		Name:            input.Name,
-		Image:           input.Image,
-		Port:            input.Port,
-		Replicas:        input.Replicas,
-		CpuLimit:        input.CPULimit,
-		MemoryLimit:     input.MemoryLimit,
-		Domain:          input.Domain,
-		HealthCheckPath: input.HealthCheckPath,
+		ID:                      id,
+		Name:                    input.Name,
+		Image:                   input.Image,
+		Port:                    input.Port,
+		Replicas:                input.Replicas,
+		CpuLimit:                input.CPULimit,
+		MemoryLimit:             input.MemoryLimit,
+		Domain:                  input.Domain,
+		HealthCheckPath:         input.HealthCheckPath,
+		Owner:                   input.Owner,
+		Labels:                  labels,
+		Env:                     env,
+		HealthChecks:            healthChecks,
+		Ports:                   ports,
+		ServiceType:             input.ServiceType,
+		Visibility:              input.Visibility,
+		Aliases:                 input.Aliases,
+		AllowFrom:               input.AllowFrom,
+		Egress:                  input.Egress,
+		EgressCidrs:             input.EgressCIDRs,
+		Namespace:               input.Namespace,
+		Cluster:                 input.Cluster,
+		AutoRollback:            input.AutoRollback,
+		CpuRequest:              input.CPURequest,
+		MemoryRequest:           input.MemoryRequest,
+		EphemeralStorageRequest: input.EphemeralStorageRequest,
+		EphemeralStorageLimit:   input.EphemeralStorageLimit,
+		Size:                    input.Size,
+		Version:                 currentApp.Version,
 	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Someone else updated or deleted the app since we read it
+		if len(input.IfMatch) > 0 {
+			return nil, preconditionFailed("app was modified concurrently")
+		}
+		return nil, conflict(CodeAppModified, "app was modified concurrently, retry the request")
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to update app: %w", err)
+		return nil, dbError(err, "failed to update app")
 	}
 
 	// Redeploy if certain fields changed
 	needsRedeploy := input.Image != nil || input.Port != nil || input.Replicas != nil ||
-		input.CPULimit != nil || input.MemoryLimit != nil || input.HealthCheckPath != nil ||
-		input.Domain != nil
+		input.CPULimit != nil || input.MemoryLimit != nil || input.CPURequest != nil ||
+		input.MemoryRequest != nil || input.EphemeralStorageRequest != nil ||
+		input.EphemeralStorageLimit != nil || input.Size != nil || input.HealthCheckPath != nil ||
+		input.Domain != nil || input.Env != nil || input.HealthChecks != nil ||
+		input.Ports != nil || input.ServiceType != nil || input.Visibility != nil ||
+		input.Aliases != nil || input.AllowFrom != nil || input.Egress != nil ||
+		input.EgressCIDRs != nil || input.Namespace != nil || input.Cluster != nil
+
+	s.log(ctx, &app).Info("app updated", "redeploy", needsRedeploy)
 
 	if needsRedeploy {
-		go func() {
-			deployCtx := context.Background()
-			_ = s.deployApp(deployCtx, &app)
-		}()
+		s.startDeploy(ctx, app)
 	}
 
 	return &app, nil
 }
 
+// validateUpdatedNetwork validates the network config an update leaves the
+// app with, since its fields are checked against each other
+func validateUpdatedNetwork(app *db.App, input UpdateAppInput) error {
+	ports, err := decodePorts(app.Ports)
+	if err != nil {
+		return err
+	}
+	n := networkConfig{
+		Port:        app.Port,
+		Ports:       ports,
+		Domain:      app.Domain,
+		ServiceType: app.ServiceType,
+		Visibility:  app.Visibility,
+		Aliases:     app.Aliases,
+		AllowFrom:   app.AllowFrom,
+		Egress:      app.Egress,
+		EgressCIDRs: app.EgressCidrs,
+	}
+	if input.Port != nil {
+		n.Port = *input.Port
+	}
+	if input.Ports != nil {
+		n.Ports = input.Ports
+	}
+	if input.Domain != nil {
+		n.Domain = *input.Domain
+	}
+	if input.ServiceType != nil {
+		n.ServiceType = *input.ServiceType
+	}
+	if input.Visibility != nil {
+		n.Visibility = *input.Visibility
+	}
+	if input.Aliases != nil {
+		n.Aliases = input.Aliases
+	}
+	if input.AllowFrom != nil {
+		n.AllowFrom = input.AllowFrom
+	}
+	if input.Egress != nil {
+		n.Egress = *input.Egress
+	}
+	if input.EgressCIDRs != nil {
+		n.EgressCIDRs = input.EgressCIDRs
+	}
+	return validateNetwork(app.Slug, n)
+}
+
 // DeleteApp deletes an app and its Kubernetes resources
 func (s *AppService) DeleteApp(ctx context.Context, id uuid.UUID) error {
 	// Get app
 	app, err := s.queries.GetApp(ctx, id)
 	if err != nil {
-		return fmt.Errorf("failed to get app: %w", err)
+		return dbError(err, "failed to get app")
 	}
 
-	// Delete Kubernetes resources
-	_ = s.k8sClient.DeleteIngress(ctx, app.Slug)
-	_ = s.k8sClient.DeleteService(ctx, app.Slug)
-	_ = s.k8sClient.DeleteDeployment(ctx, app.Slug)
+	logger := s.log(ctx, &app)
+
+	// Delete Kubernetes resources. An app whose cluster was removed from
+	// the registry has none left that can be reached.
+	if client, ok := s.clusters.Get(app.Cluster); ok {
+		s.deleteResources(ctx, client, &app, app.Namespace)
+	} else {
+		logger.Warn("cluster is not configured, skipping resource cleanup", "cluster", app.Cluster)
+	}
 
 	// Delete from database
 	if err := s.queries.DeleteApp(ctx, id); err != nil {
-		return fmt.Errorf("failed to delete app: %w", err)
+		return dbError(err, "failed to delete app")
 	}
 
+	logger.Info("app deleted")
+
 	return nil
 }
 
@@ -294,14 +828,21 @@ func (s *AppService) RestartApp(ctx context.Context, id uuid.UUID) error {
 	// Get app
 	app, err := s.queries.GetApp(ctx, id)
 	if err != nil {
-		return fmt.Errorf("failed to get app: %w", err)
+		return dbError(err, "failed to get app")
+	}
+
+	client, err := s.client(app.Cluster)
+	if err != nil {
+		return err
 	}
 
 	// Restart deployment
-	if err := s.k8sClient.RestartDeployment(ctx, app.Slug); err != nil {
-		return fmt.Errorf("failed to restart deployment: %w", err)
+	if err := client.RestartDeployment(ctx, app.Namespace, app.Slug); err != nil {
+		return k8sError(err, "failed to restart deployment")
 	}
 
+	s.log(ctx, &app).Info("app restart initiated")
+
 	return nil
 }
 
@@ -329,20 +870,50 @@ func slugify(name string) string {
 	return slug
 }
 
+// appStatuses are the valid values of apps.status
+var appStatuses = map[string]bool{
+	"pending":   true,
+	"deploying": true,
+	"running":   true,
+	"failed":    true,
+}
+
+// validateLabels validates label keys and values according to Kubernetes label rules
+func validateLabels(labels map[string]string) error {
+	var fields []FieldError
+	for key, value := range labels {
+		field := "labels." + key
+		for _, msg := range validation.IsQualifiedName(key) {
+			fields = append(fields, FieldError{Field: field, Message: "invalid key: " + msg})
+		}
+		for _, msg := range validation.IsValidLabelValue(value) {
+			fields = append(fields, FieldError{Field: field, Message: "invalid value: " + msg})
+		}
+	}
+	if len(fields) > 0 {
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+		return validationFailed(fields...)
+	}
+	return nil
+}
+
 // validateSlug validates a slug according to Kubernetes naming rules
 func validateSlug(slug string) error {
 	if slug == "" {
-		return fmt.Errorf("slug cannot be empty")
+		return validationFailed(FieldError{Field: "slug", Message: "cannot be empty"})
 	}
 
 	if len(slug) > 63 {
-		return fmt.Errorf("slug cannot be longer than 63 characters")
+		return validationFailed(FieldError{Field: "slug", Message: "cannot be longer than 63 characters"})
 	}
 
 	// Must start and end with alphanumeric
 	reg := regexp.MustCompile("^[a-z0-9]([-a-z0-9]*[a-z0-9])?$")
 	if !reg.MatchString(slug) {
-		return fmt.Errorf("slug must consist of lowercase alphanumeric characters or '-', and must start and end with an alphanumeric character")
+		return validationFailed(FieldError{
+			Field:   "slug",
+			Message: "must consist of lowercase alphanumeric characters or '-', and must start and end with an alphanumeric character",
+		})
 	}
 
 	return nil
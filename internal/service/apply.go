@@ -0,0 +1,844 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/k8s"
+	"github.com/superfly/superfly/internal/manifest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Plan actions
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanNone   = "none"
+)
+
+// Change sources
+const (
+	// SourceApp marks a difference between the manifest and the stored app
+	SourceApp = "app"
+	// SourceCluster marks a difference between the manifest and the live
+	// Kubernetes objects, e.g. after a manual kubectl edit
+	SourceCluster = "cluster"
+)
+
+// Change is a single field that applying a manifest would change
+type Change struct {
+	Field   string `json:"field"`
+	Source  string `json:"source"`
+	Current any    `json:"current"`
+	Desired any    `json:"desired"`
+}
+
+// Plan is what applying a manifest would do
+type Plan struct {
+	Action   string   `json:"action"`
+	Slug     string   `json:"slug"`
+	Changes  []Change `json:"changes"`
+	Redeploy bool     `json:"redeploy"`
+}
+
+// ApplyResult is the outcome of Apply. App is nil for dry runs.
+type ApplyResult struct {
+	Plan    Plan    `json:"plan"`
+	Applied bool    `json:"applied"`
+	App     *db.App `json:"app,omitempty"`
+}
+
+// appConfig is the declarative part of an app: everything a manifest sets,
+// with defaults applied
+type appConfig struct {
+	Name            string
+	Image           string
+	Port            int32
+	Replicas        int32
+	CPULimit        string
+	MemoryLimit     string
+	Domain          string
+	HealthCheckPath string
+	Owner           string
+	Labels          map[string]string
+	Env             []EnvVar
+	HealthChecks    HealthChecks
+	Ports           []Port
+	ServiceType     string
+	Visibility      string
+	Aliases         []string
+	AllowFrom       []string
+	Egress          string
+	EgressCIDRs     []string
+	Namespace       string
+	Cluster         string
+	AutoRollback    bool
+
+	CPURequest              string
+	MemoryRequest           string
+	EphemeralStorageRequest string
+	EphemeralStorageLimit   string
+	Size                    string
+}
+
+// configField is a field of appConfig as it is named in the manifest
+type configField struct {
+	name    string
+	runtime bool // changing it requires a redeploy
+	value   func(c *appConfig) any
+}
+
+var configFields = []configField{
+	{"name", false, func(c *appConfig) any { return c.Name }},
+	{"image", true, func(c *appConfig) any { return c.Image }},
+	{"port", true, func(c *appConfig) any { return c.Port }},
+	{"ports", true, func(c *appConfig) any { return c.Ports }},
+	{"service_type", true, func(c *appConfig) any { return c.ServiceType }},
+	{"visibility", true, func(c *appConfig) any { return c.Visibility }},
+	{"aliases", true, func(c *appConfig) any { return c.Aliases }},
+	{"allow_from", true, func(c *appConfig) any { return c.AllowFrom }},
+	{"egress", true, func(c *appConfig) any { return c.Egress }},
+	{"egress_cidrs", true, func(c *appConfig) any { return c.EgressCIDRs }},
+	{"namespace", true, func(c *appConfig) any { return c.Namespace }},
+	{"cluster", true, func(c *appConfig) any { return c.Cluster }},
+	{"replicas", true, func(c *appConfig) any { return c.Replicas }},
+	{"domain", true, func(c *appConfig) any { return c.Domain }},
+	{"owner", false, func(c *appConfig) any { return c.Owner }},
+	{"labels", false, func(c *appConfig) any { return c.Labels }},
+	{"size", true, func(c *appConfig) any { return c.Size }},
+	{"resources.cpu", true, func(c *appConfig) any { return c.CPULimit }},
+	{"resources.memory", true, func(c *appConfig) any { return c.MemoryLimit }},
+	{"resources.cpu_request", true, func(c *appConfig) any { return c.CPURequest }},
+	{"resources.memory_request", true, func(c *appConfig) any { return c.MemoryRequest }},
+	{"resources.ephemeral_storage", true, func(c *appConfig) any { return c.EphemeralStorageLimit }},
+	{"resources.ephemeral_storage_request", true, func(c *appConfig) any { return c.EphemeralStorageRequest }},
+	{"health_check.path", true, func(c *appConfig) any { return c.HealthCheckPath }},
+	{"health_check.liveness", true, func(c *appConfig) any { return c.HealthChecks.Liveness }},
+	{"health_check.readiness", true, func(c *appConfig) any { return c.HealthChecks.Readiness }},
+	{"health_check.startup", true, func(c *appConfig) any { return c.HealthChecks.Startup }},
+	{"env", true, func(c *appConfig) any { return c.Env }},
+	{"auto_rollback", false, func(c *appConfig) any { return c.AutoRollback }},
+}
+
+// Apply makes the app described by a manifest match it, creating the app if
+// no app has its slug. The plan compares the manifest with the stored app
+// and with the live Kubernetes objects, so drift in the cluster is
+// redeployed too. With dryRun only the plan is returned.
+//
+// The stored app is written in a single statement that only applies if the
+// app did not change since it was planned, so a manifest is either applied
+// whole or not at all.
+func (s *AppService) Apply(ctx context.Context, m *manifest.Manifest, dryRun bool) (*ApplyResult, error) {
+	desired, err := configFromManifest(m)
+	if err != nil {
+		return nil, err
+	}
+	if desired.Namespace == "" {
+		desired.Namespace = s.namespaces.forOwner(desired.Owner)
+	}
+	if desired.Cluster == "" {
+		desired.Cluster = s.clusters.Default()
+	}
+	if err := s.validateCluster(desired.Cluster); err != nil {
+		return nil, err
+	}
+	custom := m.Resources != nil && *m.Resources != (manifest.Resources{})
+	if desired.Size == "" && !custom {
+		desired.Size = s.sizes.Default()
+	}
+
+	current, err := s.queries.GetAppBySlug(ctx, m.Slug)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if err := s.checkSize(ctx, desired.Size, custom); err != nil {
+			return nil, err
+		}
+		return s.applyCreate(ctx, m.Slug, desired, dryRun)
+	}
+	if err != nil {
+		return nil, dbError(err, "failed to get app")
+	}
+
+	plan, err := s.plan(ctx, &current, desired)
+	if err != nil {
+		return nil, err
+	}
+	// Resources an admin set may be applied again by anyone, but only
+	// admins may change them
+	if err := s.checkSize(ctx, desired.Size, desired.Size == "" && resourcesChanged(plan)); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return &ApplyResult{Plan: *plan}, nil
+	}
+	if plan.Action == PlanNone {
+		return &ApplyResult{Plan: *plan, Applied: true, App: &current}, nil
+	}
+	if err := s.checkAliases(ctx, current.ID, desired.Aliases); err != nil {
+		return nil, err
+	}
+
+	labels, err := json.Marshal(desired.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode labels: %w", err)
+	}
+	env, err := encodeEnv(desired.Env)
+	if err != nil {
+		return nil, err
+	}
+	healthChecks, err := encodeHealthChecks(desired.HealthChecks)
+	if err != nil {
+		return nil, err
+	}
+	ports, err := encodePorts(desired.Ports)
+	if err != nil {
+		return nil, err
+	}
+
+	app, err := s.queries.ReplaceApp(ctx, db.ReplaceAppParams{
+		ID:                      current.ID,
+		Name:                    desired.Name,
+		Image:                   desired.Image,
+		Port:                    desired.Port,
+		Replicas:                desired.Replicas,
+		CpuLimit:                desired.CPULimit,
+		MemoryLimit:             desired.MemoryLimit,
+		Domain:                  desired.Domain,
+		HealthCheckPath:         desired.HealthCheckPath,
+		Owner:                   desired.Owner,
+		Labels:                  labels,
+		Env:                     env,
+		HealthChecks:            healthChecks,
+		Ports:                   ports,
+		ServiceType:             desired.ServiceType,
+		Visibility:              desired.Visibility,
+		Aliases:                 desired.Aliases,
+		AllowFrom:               desired.AllowFrom,
+		Egress:                  desired.Egress,
+		EgressCidrs:             desired.EgressCIDRs,
+		Namespace:               desired.Namespace,
+		Cluster:                 desired.Cluster,
+		AutoRollback:            desired.AutoRollback,
+		CpuRequest:              desired.CPURequest,
+		MemoryRequest:           desired.MemoryRequest,
+		EphemeralStorageRequest: desired.EphemeralStorageRequest,
+		EphemeralStorageLimit:   desired.EphemeralStorageLimit,
+		Size:                    desired.Size,
+		Version:                 current.Version,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, conflict(CodeAppModified, "app changed while the manifest was being applied, plan again")
+	}
+	if err != nil {
+		return nil, dbError(err, "failed to apply manifest")
+	}
+
+	s.log(ctx, &app).Info("manifest applied", "changes", len(plan.Changes), "redeploy", plan.Redeploy)
+
+	if plan.Redeploy {
+		s.startDeploy(ctx, app)
+	}
+
+	return &ApplyResult{Plan: *plan, Applied: true, App: &app}, nil
+}
+
+// applyCreate plans and, unless dryRun, creates a new app from a manifest
+func (s *AppService) applyCreate(ctx context.Context, slug string, desired appConfig, dryRun bool) (*ApplyResult, error) {
+	plan := Plan{Action: PlanCreate, Slug: slug, Redeploy: true}
+	for _, field := range configFields {
+		plan.Changes = append(plan.Changes, Change{
+			Field:   field.name,
+			Source:  SourceApp,
+			Desired: field.value(&desired),
+		})
+	}
+	if dryRun {
+		return &ApplyResult{Plan: plan}, nil
+	}
+
+	// The insert only enforces unique slugs; aliases share their names
+	exists, err := s.queries.CheckSlugExists(ctx, slug)
+	if err != nil {
+		return nil, dbError(err, "failed to check slug")
+	}
+	if exists {
+		return nil, conflict(CodeSlugTaken, "app with slug '%s' already exists", slug)
+	}
+	if err := s.checkAliases(ctx, uuid.Nil, desired.Aliases); err != nil {
+		return nil, err
+	}
+
+	labels, err := json.Marshal(desired.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode labels: %w", err)
+	}
+	env, err := encodeEnv(desired.Env)
+	if err != nil {
+		return nil, err
+	}
+	healthChecks, err := encodeHealthChecks(desired.HealthChecks)
+	if err != nil {
+		return nil, err
+	}
+	ports, err := encodePorts(desired.Ports)
+	if err != nil {
+		return nil, err
+	}
+
+	// Domain uniqueness is enforced by the insert itself
+	app, err := s.queries.CreateApp(ctx, db.CreateAppParams{
+		Slug:                    slug,
+		Name:                    desired.Name,
+		Image:                   desired.Image,
+		Port:                    desired.Port,
+		Replicas:                desired.Replicas,
+		CpuLimit:                desired.CPULimit,
+		MemoryLimit:             desired.MemoryLimit,
+		Domain:                  desired.Domain,
+		HealthCheckPath:         desired.HealthCheckPath,
+		Status:                  "pending",
+		Owner:                   desired.Owner,
+		Labels:                  labels,
+		Env:                     env,
+		HealthChecks:            healthChecks,
+		Ports:                   ports,
+		ServiceType:             desired.ServiceType,
+		Visibility:              desired.Visibility,
+		Aliases:                 desired.Aliases,
+		AllowFrom:               desired.AllowFrom,
+		Egress:                  desired.Egress,
+		EgressCidrs:             desired.EgressCIDRs,
+		Namespace:               desired.Namespace,
+		Cluster:                 desired.Cluster,
+		AutoRollback:            desired.AutoRollback,
+		CpuRequest:              desired.CPURequest,
+		MemoryRequest:           desired.MemoryRequest,
+		EphemeralStorageRequest: desired.EphemeralStorageRequest,
+		EphemeralStorageLimit:   desired.EphemeralStorageLimit,
+		Size:                    desired.Size,
+	})
+	if err != nil {
+		return nil, dbError(err, "failed to create app")
+	}
+
+	s.log(ctx, &app).Info("app created from manifest", "image", app.Image)
+
+	s.startDeploy(ctx, app)
+
+	return &ApplyResult{Plan: plan, Applied: true, App: &app}, nil
+}
+
+// resourcesChanged is whether a plan changes the app's size or its own
+// resources
+func resourcesChanged(plan *Plan) bool {
+	for _, change := range plan.Changes {
+		if change.Source == SourceApp && (change.Field == "size" || strings.HasPrefix(change.Field, "resources.")) {
+			return true
+		}
+	}
+	return false
+}
+
+// plan diffs the desired config against the stored app and its live objects
+func (s *AppService) plan(ctx context.Context, app *db.App, desired appConfig) (*Plan, error) {
+	current, err := configFromApp(app)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Action: PlanNone, Slug: app.Slug}
+	for _, field := range configFields {
+		from, to := field.value(&current), field.value(&desired)
+		if reflect.DeepEqual(from, to) {
+			continue
+		}
+		plan.Changes = append(plan.Changes, Change{Field: field.name, Source: SourceApp, Current: from, Desired: to})
+		plan.Redeploy = plan.Redeploy || field.runtime
+	}
+
+	drift, err := s.liveChanges(ctx, app, desired)
+	if err != nil {
+		return nil, err
+	}
+	if len(drift) > 0 {
+		plan.Changes = append(plan.Changes, drift...)
+		plan.Redeploy = true
+	}
+
+	if len(plan.Changes) > 0 {
+		plan.Action = PlanUpdate
+	}
+	return plan, nil
+}
+
+// liveChanges compares the app's Deployment and Ingress in its cluster and
+// namespace with the objects a deploy of the desired config would apply
+func (s *AppService) liveChanges(ctx context.Context, app *db.App, desired appConfig) ([]Change, error) {
+	namespace, slug := app.Namespace, app.Slug
+	client, ok := s.clusters.Get(app.Cluster)
+	if !ok {
+		// The app's cluster was removed from the registry, so nothing of it
+		// runs anywhere a deploy would look
+		return []Change{{Field: "deployment", Source: SourceCluster, Desired: slug}}, nil
+	}
+	spec, err := s.spec(&desired, slug)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+
+	live, err := client.GetDeployment(ctx, namespace, slug)
+	switch {
+	case apierrors.IsNotFound(err):
+		changes = append(changes, Change{Field: "deployment", Source: SourceCluster, Desired: slug})
+	case err != nil:
+		return nil, k8sError(err, "failed to get deployment")
+	default:
+		want, err := k8s.BuildDeployment(spec)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, deploymentChanges(live, want)...)
+	}
+
+	liveDomains := []string{}
+	ingress, err := client.GetIngress(ctx, namespace, slug)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return nil, k8sError(err, "failed to get ingress")
+	default:
+		for _, rule := range ingress.Spec.Rules {
+			liveDomains = append(liveDomains, rule.Host)
+		}
+	}
+	if want := domainList(desired.Domain); !reflect.DeepEqual(liveDomains, want) {
+		changes = append(changes, Change{Field: "domain", Source: SourceCluster, Current: liveDomains, Desired: want})
+	}
+
+	return changes, nil
+}
+
+// deploymentChanges compares a live Deployment and its app container with
+// the ones a deploy would apply. Replicas an autoscaler manages are not
+// drift, since deploys leave them to it.
+func deploymentChanges(liveDeployment, wantDeployment *appsv1.Deployment) []Change {
+	var changes []Change
+	add := func(field string, current, desired any) {
+		changes = append(changes, Change{Field: field, Source: SourceCluster, Current: current, Desired: desired})
+	}
+
+	liveReplicas, wantReplicas := liveDeployment.Spec.Replicas, wantDeployment.Spec.Replicas
+	if !k8s.ReplicasAutoscaled(liveDeployment) && (liveReplicas == nil || *liveReplicas != *wantReplicas) {
+		var current any
+		if liveReplicas != nil {
+			current = *liveReplicas
+		}
+		add("replicas", current, *wantReplicas)
+	}
+
+	want := wantDeployment.Spec.Template.Spec.Containers[0]
+	liveContainers := liveDeployment.Spec.Template.Spec.Containers
+	var live *corev1.Container
+	for i := range liveContainers {
+		if liveContainers[i].Name == want.Name {
+			live = &liveContainers[i]
+		}
+	}
+	if live == nil {
+		add("deployment", nil, want.Name)
+		return changes
+	}
+
+	if live.Image != want.Image {
+		add("image", live.Image, want.Image)
+	}
+	if !equality.Semantic.DeepEqual(live.Ports, want.Ports) {
+		add("ports", live.Ports, want.Ports)
+	}
+	for _, res := range []struct {
+		name                     corev1.ResourceName
+		requestField, limitField string
+	}{
+		{corev1.ResourceCPU, "resources.cpu_request", "resources.cpu"},
+		{corev1.ResourceMemory, "resources.memory_request", "resources.memory"},
+		{corev1.ResourceEphemeralStorage, "resources.ephemeral_storage_request", "resources.ephemeral_storage"},
+	} {
+		quantityChange(add, res.requestField, live.Resources.Requests, want.Resources.Requests, res.name)
+		quantityChange(add, res.limitField, live.Resources.Limits, want.Resources.Limits, res.name)
+	}
+	probes := []struct {
+		field      string
+		live, want *corev1.Probe
+	}{
+		{"health_check.liveness", live.LivenessProbe, want.LivenessProbe},
+		{"health_check.readiness", live.ReadinessProbe, want.ReadinessProbe},
+		{"health_check.startup", live.StartupProbe, want.StartupProbe},
+	}
+	for _, probe := range probes {
+		if !equality.Semantic.DeepEqual(probe.live, probe.want) {
+			add(probe.field, probe.live, probe.want)
+		}
+	}
+	if !equality.Semantic.DeepEqual(live.Env, want.Env) {
+		add("env", live.Env, want.Env)
+	}
+
+	return changes
+}
+
+// quantityChange adds a change when a resource differs between two lists,
+// including when only one of them has it
+func quantityChange(add func(field string, current, desired any), field string, live, want corev1.ResourceList, name corev1.ResourceName) {
+	current, hasCurrent := live[name]
+	desired, hasDesired := want[name]
+	if hasCurrent == hasDesired && current.Cmp(desired) == 0 {
+		return
+	}
+	var currentValue, desiredValue any
+	if hasCurrent {
+		currentValue = current.String()
+	}
+	if hasDesired {
+		desiredValue = desired.String()
+	}
+	add(field, currentValue, desiredValue)
+}
+
+// ExportManifest describes an app as a manifest that applies cleanly to it
+func (s *AppService) ExportManifest(app *db.App) (*manifest.Manifest, error) {
+	config, err := configFromApp(app)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest.Manifest{
+		Version:      manifest.Version,
+		Slug:         app.Slug,
+		Name:         config.Name,
+		Image:        config.Image,
+		Port:         config.Port,
+		ServiceType:  config.ServiceType,
+		Visibility:   config.Visibility,
+		Aliases:      config.Aliases,
+		AllowFrom:    config.AllowFrom,
+		Egress:       config.Egress,
+		EgressCIDRs:  config.EgressCIDRs,
+		Namespace:    config.Namespace,
+		Cluster:      config.Cluster,
+		Replicas:     &config.Replicas,
+		Domain:       config.Domain,
+		Owner:        config.Owner,
+		AutoRollback: config.AutoRollback,
+		Size:         config.Size,
+		HealthCheck: &manifest.HealthCheck{
+			Path:      config.HealthCheckPath,
+			Liveness:  manifestProbe(config.HealthChecks.Liveness),
+			Readiness: manifestProbe(config.HealthChecks.Readiness),
+			Startup:   manifestProbe(config.HealthChecks.Startup),
+		},
+	}
+	// A size decides the resources, which are left at their defaults
+	if config.Size == "" {
+		m.Resources = &manifest.Resources{
+			CPU:                     config.CPULimit,
+			CPURequest:              config.CPURequest,
+			Memory:                  config.MemoryLimit,
+			MemoryRequest:           config.MemoryRequest,
+			EphemeralStorage:        config.EphemeralStorageLimit,
+			EphemeralStorageRequest: config.EphemeralStorageRequest,
+		}
+	}
+	if len(config.Labels) > 0 {
+		m.Labels = config.Labels
+	}
+	for _, p := range config.Ports {
+		m.Ports = append(m.Ports, manifest.Port(p))
+	}
+	for _, e := range config.Env {
+		v := manifest.EnvVar{Name: e.Name, Value: e.Value}
+		if e.Secret != nil {
+			v.Secret = &manifest.SecretRef{Name: e.Secret.Name, Key: e.Secret.Key}
+		}
+		m.Env = append(m.Env, v)
+	}
+	return m, nil
+}
+
+// configFromManifest validates a manifest and applies the defaults CreateApp uses
+func configFromManifest(m *manifest.Manifest) (appConfig, error) {
+	config := appConfig{
+		Name:            m.Name,
+		Image:           m.Image,
+		Domain:          m.Domain,
+		Port:            m.Port,
+		Replicas:        1,
+		CPULimit:        defaultCPULimit,
+		MemoryLimit:     defaultMemoryLimit,
+		HealthCheckPath: "/",
+		Owner:           m.Owner,
+		Labels:          m.Labels,
+		Env:             []EnvVar{},
+		Ports:           []Port{},
+		ServiceType:     m.ServiceType,
+		Visibility:      m.Visibility,
+		Aliases:         m.Aliases,
+		AllowFrom:       m.AllowFrom,
+		Egress:          m.Egress,
+		EgressCIDRs:     m.EgressCIDRs,
+		Namespace:       m.Namespace,
+		Cluster:         m.Cluster,
+		AutoRollback:    m.AutoRollback,
+		Size:            m.Size,
+	}
+	if config.Port == 0 {
+		config.Port = 8080
+	}
+	if config.ServiceType == "" {
+		config.ServiceType = ServiceTypeClusterIP
+	}
+	if config.Visibility == "" {
+		config.Visibility = VisibilityPublic
+	}
+	if config.Aliases == nil {
+		config.Aliases = []string{}
+	}
+	if config.AllowFrom == nil {
+		config.AllowFrom = []string{}
+	}
+	if config.Egress == "" {
+		config.Egress = EgressAllowAll
+	}
+	if config.EgressCIDRs == nil {
+		config.EgressCIDRs = []string{}
+	}
+	for _, p := range m.Ports {
+		config.Ports = append(config.Ports, Port(p))
+	}
+	if m.Replicas != nil {
+		config.Replicas = *m.Replicas
+	}
+	if r := m.Resources; r != nil {
+		if r.CPU != "" {
+			config.CPULimit = r.CPU
+		}
+		if r.Memory != "" {
+			config.MemoryLimit = r.Memory
+		}
+		config.CPURequest = r.CPURequest
+		config.MemoryRequest = r.MemoryRequest
+		config.EphemeralStorageRequest = r.EphemeralStorageRequest
+		config.EphemeralStorageLimit = r.EphemeralStorage
+	}
+	if m.HealthCheck != nil {
+		if m.HealthCheck.Path != "" {
+			config.HealthCheckPath = m.HealthCheck.Path
+		}
+		config.HealthChecks = HealthChecks{
+			Liveness:  probeFromManifest(m.HealthCheck.Liveness),
+			Readiness: probeFromManifest(m.HealthCheck.Readiness),
+			Startup:   probeFromManifest(m.HealthCheck.Startup),
+		}
+	}
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	for _, e := range m.Env {
+		v := EnvVar{Name: e.Name, Value: e.Value}
+		if e.Secret != nil {
+			v.Secret = &SecretRef{Name: e.Secret.Name, Key: e.Secret.Key}
+		}
+		config.Env = append(config.Env, v)
+	}
+
+	var fields []FieldError
+	fields = append(fields, fieldErrors(validateSlug(m.Slug))...)
+	if config.Name == "" {
+		fields = append(fields, FieldError{Field: "name", Message: "is required"})
+	}
+	if config.Image == "" {
+		fields = append(fields, FieldError{Field: "image", Message: "is required"})
+	}
+	if config.Port < 1 || config.Port > 65535 {
+		fields = append(fields, FieldError{Field: "port", Message: "must be between 1 and 65535"})
+	}
+	if config.Replicas < 0 {
+		fields = append(fields, FieldError{Field: "replicas", Message: "must not be negative"})
+	}
+	fields = append(fields, resourceErrors(config.resources(), manifestResourceFields)...)
+	if config.Size != "" && m.Resources != nil && *m.Resources != (manifest.Resources{}) {
+		fields = append(fields, FieldError{Field: "size", Message: "can't be set along with resources"})
+	}
+	if !strings.HasPrefix(config.HealthCheckPath, "/") {
+		fields = append(fields, FieldError{Field: "health_check.path", Message: "must start with /"})
+	}
+	fields = append(fields, healthCheckErrors("health_check", &config.HealthChecks)...)
+	fields = append(fields, networkErrors(m.Slug, config.network())...)
+	if config.Namespace != "" {
+		fields = append(fields, namespaceErrors(config.Namespace)...)
+	}
+	fields = append(fields, fieldErrors(validateLabels(config.Labels))...)
+	fields = append(fields, fieldErrors(validateEnv(config.Env))...)
+
+	if len(fields) > 0 {
+		return appConfig{}, validationFailed(fields...)
+	}
+	return config, nil
+}
+
+// configFromApp extracts the declarative config of a stored app
+func configFromApp(app *db.App) (appConfig, error) {
+	config := appConfig{
+		Name:                    app.Name,
+		Image:                   app.Image,
+		Port:                    app.Port,
+		Replicas:                app.Replicas,
+		CPULimit:                app.CpuLimit,
+		MemoryLimit:             app.MemoryLimit,
+		Domain:                  app.Domain,
+		HealthCheckPath:         app.HealthCheckPath,
+		Owner:                   app.Owner,
+		Labels:                  map[string]string{},
+		Env:                     []EnvVar{},
+		ServiceType:             app.ServiceType,
+		Visibility:              app.Visibility,
+		Aliases:                 app.Aliases,
+		AllowFrom:               app.AllowFrom,
+		Egress:                  app.Egress,
+		EgressCIDRs:             app.EgressCidrs,
+		Namespace:               app.Namespace,
+		Cluster:                 app.Cluster,
+		AutoRollback:            app.AutoRollback,
+		CPURequest:              app.CpuRequest,
+		MemoryRequest:           app.MemoryRequest,
+		EphemeralStorageRequest: app.EphemeralStorageRequest,
+		EphemeralStorageLimit:   app.EphemeralStorageLimit,
+		Size:                    app.Size,
+	}
+	if config.Aliases == nil {
+		config.Aliases = []string{}
+	}
+	if config.AllowFrom == nil {
+		config.AllowFrom = []string{}
+	}
+	if config.EgressCIDRs == nil {
+		config.EgressCIDRs = []string{}
+	}
+	if len(app.Labels) > 0 {
+		if err := json.Unmarshal(app.Labels, &config.Labels); err != nil {
+			return appConfig{}, fmt.Errorf("failed to decode labels: %w", err)
+		}
+	}
+	env, err := decodeEnv(app.Env)
+	if err != nil {
+		return appConfig{}, err
+	}
+	if env != nil {
+		config.Env = env
+	}
+	config.HealthChecks, err = decodeHealthChecks(app.HealthChecks)
+	if err != nil {
+		return appConfig{}, err
+	}
+	config.Ports, err = decodePorts(app.Ports)
+	if err != nil {
+		return appConfig{}, err
+	}
+	return config, nil
+}
+
+// spec is the Kubernetes spec a deploy of the config would apply
+func (c *appConfig) spec(slug string) k8s.AppSpec {
+	liveness, readiness, startup := k8sProbes(c.HealthChecks, c.Port, c.HealthCheckPath)
+	return k8s.AppSpec{
+		Name:           c.Name,
+		Slug:           slug,
+		Namespace:      c.Namespace,
+		Image:          c.Image,
+		Port:           c.Port,
+		Replicas:       c.Replicas,
+		Resources:      k8s.Resources(c.resources()),
+		Domain:         c.Domain,
+		Env:            k8sEnv(c.Env),
+		Ports:          resolvePorts(c.Ports, c.Port),
+		ServiceType:    c.ServiceType,
+		Visibility:     c.Visibility,
+		Aliases:        c.Aliases,
+		AllowFrom:      c.AllowFrom,
+		Egress:         c.Egress,
+		EgressCIDRs:    c.EgressCIDRs,
+		LivenessProbe:  liveness,
+		ReadinessProbe: readiness,
+		StartupProbe:   startup,
+	}
+}
+
+// resources returns the compute resources of the config
+func (c *appConfig) resources() resourceConfig {
+	return resourceConfig{
+		CPURequest:              c.CPURequest,
+		CPULimit:                c.CPULimit,
+		MemoryRequest:           c.MemoryRequest,
+		MemoryLimit:             c.MemoryLimit,
+		EphemeralStorageRequest: c.EphemeralStorageRequest,
+		EphemeralStorageLimit:   c.EphemeralStorageLimit,
+	}
+}
+
+// network returns the fields of the config that are validated together
+func (c *appConfig) network() networkConfig {
+	return networkConfig{
+		Port:        c.Port,
+		Ports:       c.Ports,
+		Domain:      c.Domain,
+		ServiceType: c.ServiceType,
+		Visibility:  c.Visibility,
+		Aliases:     c.Aliases,
+		AllowFrom:   c.AllowFrom,
+		Egress:      c.Egress,
+		EgressCIDRs: c.EgressCIDRs,
+	}
+}
+
+// probeFromManifest and manifestProbe convert between the identical probe
+// types of the API and the manifest
+func probeFromManifest(p *manifest.Probe) *Probe {
+	if p == nil {
+		return nil
+	}
+	probe := Probe(*p)
+	return &probe
+}
+
+func manifestProbe(p *Probe) *manifest.Probe {
+	if p == nil {
+		return nil
+	}
+	probe := manifest.Probe(*p)
+	return &probe
+}
+
+func domainList(domain string) []string {
+	if domain == "" {
+		return []string{}
+	}
+	return []string{domain}
+}
+
+// fieldErrors returns the field errors of a validation error
+func fieldErrors(err error) []FieldError {
+	var svcErr *Error
+	if errors.As(err, &svcErr) {
+		return svcErr.Fields
+	}
+	return nil
+}
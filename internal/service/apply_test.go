@@ -0,0 +1,262 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/manifest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConfigFromManifestDefaults(t *testing.T) {
+	config, err := configFromManifest(&manifest.Manifest{
+		Version: manifest.Version,
+		Slug:    "web",
+		Name:    "Web",
+		Image:   "nginx:alpine",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := appConfig{
+		Name:            "Web",
+		Image:           "nginx:alpine",
+		Port:            8080,
+		Replicas:        1,
+		CPULimit:        defaultCPULimit,
+		MemoryLimit:     defaultMemoryLimit,
+		HealthCheckPath: "/",
+		Labels:          map[string]string{},
+		Env:             []EnvVar{},
+		Ports:           []Port{},
+		ServiceType:     ServiceTypeClusterIP,
+		Visibility:      VisibilityPublic,
+		Aliases:         []string{},
+		AllowFrom:       []string{},
+		Egress:          EgressAllowAll,
+		EgressCIDRs:     []string{},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("got config\n%+v\nwant\n%+v", config, want)
+	}
+}
+
+func TestConfigFromManifestErrors(t *testing.T) {
+	replicas := int32(-1)
+
+	tests := []struct {
+		name      string
+		edit      func(m *manifest.Manifest)
+		wantField string
+	}{
+		{"bad slug", func(m *manifest.Manifest) { m.Slug = "Web_App" }, "slug"},
+		{"no name", func(m *manifest.Manifest) { m.Name = "" }, "name"},
+		{"no image", func(m *manifest.Manifest) { m.Image = "" }, "image"},
+		{"port", func(m *manifest.Manifest) { m.Port = 70000 }, "port"},
+		{"negative replicas", func(m *manifest.Manifest) { m.Replicas = &replicas }, "replicas"},
+		{"domain of a private app", func(m *manifest.Manifest) {
+			m.Visibility = "none"
+			m.Domain = "web.example.com"
+		}, "domain"},
+		{"bad cpu", func(m *manifest.Manifest) { m.Resources = &manifest.Resources{CPU: "lots"} }, "resources.cpu"},
+		{"size and resources", func(m *manifest.Manifest) {
+			m.Size = "small"
+			m.Resources = &manifest.Resources{Memory: "1Gi"}
+		}, "size"},
+		{"health check path", func(m *manifest.Manifest) { m.HealthCheck = &manifest.HealthCheck{Path: "healthz"} }, "health_check.path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &manifest.Manifest{Version: manifest.Version, Slug: "web", Name: "Web", Image: "nginx:alpine"}
+			tt.edit(m)
+			_, err := configFromManifest(m)
+			if fields := fieldNames(err); !slices.Contains(fields, tt.wantField) {
+				t.Errorf("got errors for %v, want one for %s", fields, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestExportedManifestAppliesCleanly(t *testing.T) {
+	replicas := int32(3)
+	original, err := configFromManifest(&manifest.Manifest{
+		Version:   manifest.Version,
+		Slug:      "web",
+		Name:      "Web",
+		Image:     "nginx:alpine",
+		Port:      80,
+		Replicas:  &replicas,
+		Labels:    map[string]string{"team": "web"},
+		Resources: &manifest.Resources{CPU: "1", Memory: "512Mi", MemoryRequest: "256Mi"},
+		Env:       []manifest.EnvVar{{Name: "MODE", Value: "prod"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := storedApp(t, "web", original)
+	exported, err := (&AppService{}).ExportManifest(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := manifest.Encode(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := manifest.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reapplied, err := configFromManifest(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := configFromApp(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range configFields {
+		if from, to := field.value(&current), field.value(&reapplied); !reflect.DeepEqual(from, to) {
+			t.Errorf("applying the exported manifest changes %s from %v to %v", field.name, from, to)
+		}
+	}
+}
+
+func TestDeploymentChanges(t *testing.T) {
+	one, two := int32(1), int32(2)
+	want := corev1.Container{
+		Name:  "app",
+		Image: "nginx:1.27",
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+		},
+	}
+	scaledBy := func(manager string) []metav1.ManagedFieldsEntry {
+		return []metav1.ManagedFieldsEntry{{
+			Manager:     manager,
+			Operation:   metav1.ManagedFieldsOperationUpdate,
+			Subresource: "scale",
+			FieldsType:  "FieldsV1",
+			FieldsV1:    &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:replicas":{}}}`)},
+		}}
+	}
+
+	tests := []struct {
+		name          string
+		liveReplicas  *int32
+		managedFields []metav1.ManagedFieldsEntry
+		live          func(c *corev1.Container)
+		wantFields    []string
+	}{
+		{"in sync", &one, nil, func(c *corev1.Container) {}, nil},
+		{"scaled by hand", &two, scaledBy("kubectl"), func(c *corev1.Container) {}, []string{"replicas"}},
+		{"scaled by an autoscaler", &two, scaledBy("kube-controller-manager"), func(c *corev1.Container) {}, nil},
+		{"image edited", &one, nil, func(c *corev1.Container) { c.Image = "nginx:1.26" }, []string{"image"}},
+		{"memory edited", &one, nil, func(c *corev1.Container) {
+			c.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")}
+		}, []string{"resources.memory"}},
+		{"same quantity written differently", &one, nil, func(c *corev1.Container) {
+			c.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("262144Ki")}
+		}, nil},
+		{"container renamed", &one, nil, func(c *corev1.Container) { c.Name = "web" }, []string{"deployment"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			liveContainer := *want.DeepCopy()
+			tt.live(&liveContainer)
+			live := deploymentOf(tt.liveReplicas, liveContainer)
+			live.ManagedFields = tt.managedFields
+			var fields []string
+			for _, change := range deploymentChanges(live, deploymentOf(&one, want)) {
+				if change.Source != SourceCluster {
+					t.Errorf("change of %s has source %s", change.Field, change.Source)
+				}
+				fields = append(fields, change.Field)
+			}
+			if !slices.Equal(fields, tt.wantFields) {
+				t.Errorf("got changes of %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+// deploymentOf returns a Deployment running container
+func deploymentOf(replicas *int32, container corev1.Container) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
+			Replicas: replicas,
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{container}}},
+		},
+	}
+}
+
+// storedApp returns the app applyCreate would store for a config
+func storedApp(t *testing.T, slug string, c appConfig) *db.App {
+	t.Helper()
+	labels, err := json.Marshal(c.Labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := encodeEnv(c.Env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthChecks, err := encodeHealthChecks(c.HealthChecks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports, err := encodePorts(c.Ports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &db.App{
+		Slug:                    slug,
+		Name:                    c.Name,
+		Image:                   c.Image,
+		Port:                    c.Port,
+		Replicas:                c.Replicas,
+		CpuLimit:                c.CPULimit,
+		MemoryLimit:             c.MemoryLimit,
+		Domain:                  c.Domain,
+		HealthCheckPath:         c.HealthCheckPath,
+		Status:                  "running",
+		Owner:                   c.Owner,
+		Labels:                  labels,
+		Env:                     env,
+		HealthChecks:            healthChecks,
+		Ports:                   ports,
+		ServiceType:             c.ServiceType,
+		Visibility:              c.Visibility,
+		Aliases:                 c.Aliases,
+		AllowFrom:               c.AllowFrom,
+		Egress:                  c.Egress,
+		EgressCidrs:             c.EgressCIDRs,
+		Namespace:               c.Namespace,
+		Cluster:                 c.Cluster,
+		AutoRollback:            c.AutoRollback,
+		CpuRequest:              c.CPURequest,
+		MemoryRequest:           c.MemoryRequest,
+		EphemeralStorageRequest: c.EphemeralStorageRequest,
+		EphemeralStorageLimit:   c.EphemeralStorageLimit,
+		Size:                    c.Size,
+	}
+}
+
+// fieldNames returns the fields of a validation error
+func fieldNames(err error) []string {
+	var names []string
+	for _, field := range fieldErrors(err) {
+		names = append(names, field.Field)
+	}
+	return names
+}
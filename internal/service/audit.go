@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/superfly/superfly/internal/auth"
+	"github.com/superfly/superfly/internal/db"
+)
+
+// Audit log actions
+const (
+	AuditExecStart = "exec.start" // data is an ExecAudit
+	AuditExecEnd   = "exec.end"   // data is an ExecAudit with the outcome
+
+	AuditPortForwardStart = "port_forward.start" // data is a PortForwardAudit
+	AuditPortForwardEnd   = "port_forward.end"   // data is a PortForwardAudit with the outcome
+)
+
+// audit records in the audit log that the caller of ctx did something to an
+// app. Unlike events, entries must not be lost, so failing to record one is
+// an error.
+func (s *AppService) audit(ctx context.Context, app *db.App, action string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	err = s.queries.CreateAuditLogEntry(ctx, db.CreateAuditLogEntryParams{
+		AppID:   app.ID,
+		AppSlug: app.Slug,
+		Actor:   auth.FromContext(ctx).Name,
+		Action:  action,
+		Data:    encoded,
+	})
+	if err != nil {
+		return dbError(err, "failed to record audit log entry")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/k8s"
+)
+
+// client returns the Kubernetes client of a cluster, failing with
+// ErrNotFound if the cluster is not in the registry
+func (s *AppService) client(cluster string) (*k8s.Client, error) {
+	client, ok := s.clusters.Get(cluster)
+	if !ok {
+		return nil, notFound(CodeClusterNotFound, "cluster '%s' is not configured", cluster)
+	}
+	return client, nil
+}
+
+// clusterErrors checks the cluster an app is deployed to
+func (s *AppService) clusterErrors(cluster string) []FieldError {
+	if _, ok := s.clusters.Get(cluster); !ok {
+		return []FieldError{{Field: "cluster", Message: "is not a configured cluster"}}
+	}
+	return nil
+}
+
+// validateCluster checks the cluster an app is deployed to
+func (s *AppService) validateCluster(cluster string) error {
+	if fields := s.clusterErrors(cluster); len(fields) > 0 {
+		return validationFailed(fields...)
+	}
+	return nil
+}
+
+// pruneOtherCopies deletes the resources an app left in the clusters and
+// namespaces it was moved out of. Every cluster is searched, so a move
+// that was interrupted is finished by the next deploy. Clusters that can't
+// be reached are skipped and reported in the returned error.
+func (s *AppService) pruneOtherCopies(ctx context.Context, app *db.App) error {
+	var errs []error
+	for _, cluster := range s.clusters.Clusters() {
+		client, _ := s.clusters.Get(cluster.Name)
+		namespaces, err := client.DeploymentNamespaces(ctx, app.Slug)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, namespace := range namespaces {
+			if cluster.Name != app.Cluster || namespace != app.Namespace {
+				s.deleteResources(ctx, client, app, namespace)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
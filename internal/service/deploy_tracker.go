@@ -0,0 +1,80 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// deployStallTimeout is how long a deploy may go without making progress
+// before it is considered deadlocked. It must comfortably exceed the longest
+// single step, which is waiting up to 5 minutes for the rollout.
+const deployStallTimeout = 10 * time.Minute
+
+// StalledDeploy describes an in-flight deploy that stopped making progress
+type StalledDeploy struct {
+	DeploymentID string    `json:"deployment_id"`
+	AppSlug      string    `json:"app_slug"`
+	Step         string    `json:"step"`
+	StartedAt    time.Time `json:"started_at"`
+	LastProgress time.Time `json:"last_progress"`
+}
+
+// deployTracker records the progress of background deploy goroutines so
+// liveness checks can detect ones that are stuck
+type deployTracker struct {
+	mu     sync.Mutex
+	active map[string]*StalledDeploy
+}
+
+func newDeployTracker() *deployTracker {
+	return &deployTracker{active: make(map[string]*StalledDeploy)}
+}
+
+func (t *deployTracker) start(deploymentID, appSlug string) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active[deploymentID] = &StalledDeploy{
+		DeploymentID: deploymentID,
+		AppSlug:      appSlug,
+		Step:         "starting",
+		StartedAt:    now,
+		LastProgress: now,
+	}
+}
+
+func (t *deployTracker) progress(deploymentID, step string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if d, ok := t.active[deploymentID]; ok {
+		d.Step = step
+		d.LastProgress = time.Now()
+	}
+}
+
+func (t *deployTracker) finish(deploymentID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.active, deploymentID)
+}
+
+// stalled returns deploys without progress for longer than timeout, oldest first
+func (t *deployTracker) stalled(timeout time.Duration) []StalledDeploy {
+	cutoff := time.Now().Add(-timeout)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stalled []StalledDeploy
+	for _, d := range t.active {
+		if d.LastProgress.Before(cutoff) {
+			stalled = append(stalled, *d)
+		}
+	}
+	sort.Slice(stalled, func(i, j int) bool {
+		return stalled[i].StartedAt.Before(stalled[j].StartedAt)
+	})
+	return stalled
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/superfly/superfly/internal/k8s"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// EnvVar is an environment variable of an app's container. Exactly one of
+// Value and Secret is set. Secret references a key of a Kubernetes Secret in
+// the apps namespace, so secret values never pass through superfly.
+type EnvVar struct {
+	Name   string     `json:"name" openapi:"minLength=1"`
+	Value  string     `json:"value,omitempty"`
+	Secret *SecretRef `json:"secret,omitempty"`
+}
+
+// SecretRef names a key of a Kubernetes Secret
+type SecretRef struct {
+	Name string `json:"name" openapi:"minLength=1"`
+	Key  string `json:"key" openapi:"minLength=1"`
+}
+
+// validateEnv checks env var names, that each name is set once and that each
+// var has either a value or a secret reference
+func validateEnv(env []EnvVar) error {
+	var fields []FieldError
+	seen := make(map[string]bool, len(env))
+	for i, e := range env {
+		field := fmt.Sprintf("env[%d]", i)
+		for _, msg := range validation.IsEnvVarName(e.Name) {
+			fields = append(fields, FieldError{Field: field + ".name", Message: msg})
+		}
+		if seen[e.Name] {
+			fields = append(fields, FieldError{Field: field + ".name", Message: "duplicate name " + e.Name})
+		}
+		seen[e.Name] = true
+
+		if e.Secret == nil {
+			continue
+		}
+		if e.Value != "" {
+			fields = append(fields, FieldError{Field: field, Message: "must set either value or secret, not both"})
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(e.Secret.Name) {
+			fields = append(fields, FieldError{Field: field + ".secret.name", Message: msg})
+		}
+		for _, msg := range validation.IsConfigMapKey(e.Secret.Key) {
+			fields = append(fields, FieldError{Field: field + ".secret.key", Message: msg})
+		}
+	}
+	if len(fields) > 0 {
+		return validationFailed(fields...)
+	}
+	return nil
+}
+
+// encodeEnv encodes env for the apps.env column, storing nil as []
+func encodeEnv(env []EnvVar) (json.RawMessage, error) {
+	if env == nil {
+		env = []EnvVar{}
+	}
+	raw, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode env: %w", err)
+	}
+	return raw, nil
+}
+
+// decodeEnv decodes the apps.env column
+func decodeEnv(raw json.RawMessage) ([]EnvVar, error) {
+	var env []EnvVar
+	if len(raw) == 0 {
+		return env, nil
+	}
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return nil, fmt.Errorf("failed to decode env: %w", err)
+	}
+	return env, nil
+}
+
+// k8sEnv converts env vars to the form the Kubernetes builders take
+func k8sEnv(env []EnvVar) []k8s.EnvVar {
+	vars := make([]k8s.EnvVar, 0, len(env))
+	for _, e := range env {
+		v := k8s.EnvVar{Name: e.Name, Value: e.Value}
+		if e.Secret != nil {
+			v.SecretName = e.Secret.Name
+			v.SecretKey = e.Secret.Key
+		}
+		vars = append(vars, v)
+	}
+	return vars
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Error kinds returned by the service layer. Branch on them with errors.Is,
+// e.g. errors.Is(err, service.ErrNotFound).
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("validation failed")
+	ErrUnavailable = errors.New("dependency unavailable")
+	// ErrForbidden means the caller's role doesn't allow the change
+	ErrForbidden = errors.New("forbidden")
+	// ErrPreconditionFailed means the resource changed since the client read it
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Stable machine-readable error codes exposed to API clients
+const (
+	CodeAppNotFound        = "app_not_found"
+	CodeDeploymentNotFound = "deployment_not_found"
+	CodeClusterNotFound    = "cluster_not_found"
+	CodePodNotFound        = "pod_not_found"
+	CodeSlugTaken          = "slug_taken"
+	CodeDomainTaken        = "domain_taken"
+	CodeAliasTaken         = "alias_taken"
+	CodeAppModified        = "app_modified"
+	CodePodNotRunning      = "pod_not_running"
+	CodeNoRunningPods      = "no_running_pods"
+	CodeCustomResources    = "custom_resources_forbidden"
+	CodePreconditionFailed = "precondition_failed"
+	CodeValidationFailed   = "validation_failed"
+	CodeUnavailable        = "unavailable"
+)
+
+// unique constraint names from db/migrations, used to tell which column
+// caused a unique violation
+const (
+	constraintAppsSlug   = "apps_slug_key"
+	constraintAppsDomain = "apps_domain_key"
+)
+
+// pgUniqueViolation is the SQLSTATE for unique_violation
+const pgUniqueViolation = "23505"
+
+// FieldError describes a validation failure of a single input field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a service error with a kind (one of the Err* sentinels), a stable
+// code and a message that is safe to show to API clients
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// Is reports whether target is the error's kind
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func notFound(code, format string, args ...any) *Error {
+	return &Error{Kind: ErrNotFound, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func forbidden(code, format string, args ...any) *Error {
+	return &Error{Kind: ErrForbidden, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func conflict(code, format string, args ...any) *Error {
+	return &Error{Kind: ErrConflict, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func validationFailed(fields ...FieldError) *Error {
+	message := "validation failed"
+	if len(fields) == 1 {
+		message = fields[0].Field + " " + fields[0].Message
+	}
+	return &Error{Kind: ErrValidation, Code: CodeValidationFailed, Message: message, Fields: fields}
+}
+
+func preconditionFailed(format string, args ...any) *Error {
+	return &Error{Kind: ErrPreconditionFailed, Code: CodePreconditionFailed, Message: fmt.Sprintf(format, args...)}
+}
+
+func unavailable(err error, format string, args ...any) *Error {
+	return &Error{Kind: ErrUnavailable, Code: CodeUnavailable, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// dbError translates a database error about an app into a typed error.
+// Errors that are neither "no rows", a unique violation nor reported by
+// Postgres itself mean the database could not be reached.
+func dbError(err error, action string) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return notFound(CodeAppNotFound, "app not found")
+	case errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation:
+		switch pgErr.ConstraintName {
+		case constraintAppsSlug:
+			return conflict(CodeSlugTaken, "an app with this slug already exists")
+		case constraintAppsDomain:
+			return conflict(CodeDomainTaken, "domain already in use")
+		}
+		return &Error{Kind: ErrConflict, Code: "conflict", Message: action, Err: err}
+	case errors.As(err, &pgErr), errors.Is(err, context.Canceled):
+		return fmt.Errorf("%s: %w", action, err)
+	default:
+		return unavailable(err, "database unavailable")
+	}
+}
+
+// k8sError translates a Kubernetes API error into a typed error
+func k8sError(err error, action string) error {
+	var netErr net.Error
+	switch {
+	case apierrors.IsNotFound(err):
+		return notFound(CodeDeploymentNotFound, "deployment not found in cluster")
+	case apierrors.IsServerTimeout(err), apierrors.IsTimeout(err),
+		apierrors.IsServiceUnavailable(err), apierrors.IsTooManyRequests(err),
+		errors.As(err, &netErr):
+		return unavailable(err, "kubernetes API unavailable")
+	default:
+		return fmt.Errorf("%s: %w", action, err)
+	}
+}
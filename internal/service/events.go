@@ -0,0 +1,405 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/k8s"
+	"github.com/superfly/superfly/internal/logging"
+)
+
+// Event types
+const (
+	EventStatus = "status" // data is a StatusEvent
+	EventDeploy = "deploy" // data is a DeployEvent
+	EventPod    = "pod"    // data is a PodEvent
+)
+
+// eventsChannel is the Postgres channel a trigger announces new events on
+const eventsChannel = "app_events"
+
+// subscriptionBuffer is how far a subscriber may fall behind before it is
+// dropped. Its stream ends, and the client resumes from the event table.
+const subscriptionBuffer = 64
+
+// eventPageSize bounds the events read from the event table at once
+const eventPageSize = 500
+
+// ReplayWindow is how many event IDs before the last one seen a replay
+// re-reads. IDs are handed out when events are inserted, but events only
+// show up once they commit, so an event can show up after one with a
+// higher ID. Whoever replays skips the events it already has by their ID.
+const ReplayWindow = 100
+
+// Pauses before listening for events, or watching a cluster's pod events,
+// again after the connection failed
+const (
+	listenRetryInterval   = 5 * time.Second
+	podEventRetryInterval = 10 * time.Second
+)
+
+// StatusEvent is an app's status changing during a deploy
+type StatusEvent struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// DeployEvent is a deploy moving on to its next step
+type DeployEvent struct {
+	DeploymentID string `json:"deployment_id"`
+	Step         string `json:"step"`
+}
+
+// PodEvent is a lifecycle event of a pod of an app, as Kubernetes reported
+// it: Scheduled, Pulled, Started, Killing or OOMKilled
+type PodEvent struct {
+	Cluster   string    `json:"cluster"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Container string    `json:"container,omitempty"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+}
+
+// EventService records the events of apps and fans them out to
+// subscribers. Events are announced with Postgres NOTIFY, so subscribers of
+// every API replica get the events recorded by any of them.
+type EventService struct {
+	pool      *pgxpool.Pool
+	queries   *db.Queries
+	retention time.Duration
+	logger    *slog.Logger
+
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	// last is the ID of the newest event fanned out, after which the
+	// listener catches up when it reconnects
+	last int64
+	// closed is set once Close ended every subscription
+	closed bool
+}
+
+// NewEventService creates the event service. Events are kept for retention.
+func NewEventService(pool *pgxpool.Pool, retention time.Duration, logger *slog.Logger) *EventService {
+	return &EventService{
+		pool:        pool,
+		queries:     db.New(pool),
+		retention:   retention,
+		logger:      logger,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the events recorded after it was made
+type Subscription struct {
+	// appID limits the subscription to an app's events unless nil
+	appID  *uuid.UUID
+	events chan db.AppEvent
+}
+
+// Events returns the channel events are delivered on. It is closed when the
+// subscriber fell too far behind, and the events it missed have to be read
+// with Replay, or when the service is closed.
+func (s *Subscription) Events() <-chan db.AppEvent {
+	return s.events
+}
+
+// Subscribe subscribes to the events of an app, or of every app if appID is
+// nil. The subscription must be ended with Unsubscribe.
+func (s *EventService) Subscribe(appID *uuid.UUID) *Subscription {
+	sub := &Subscription{appID: appID, events: make(chan db.AppEvent, subscriptionBuffer)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(sub.events)
+		return sub
+	}
+	s.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe ends a subscription
+func (s *EventService) Unsubscribe(sub *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscribers[sub]; ok {
+		delete(s.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Close ends every subscription, and those made from now on, so the
+// streams reading them finish when the server shuts down
+func (s *EventService) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for sub := range s.subscribers {
+		delete(s.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Replay calls send with each event recorded after the last event seen,
+// oldest first, of an app or of every app if appID is nil, starting
+// ReplayWindow events before it. It stops at the first error send returns.
+func (s *EventService) Replay(ctx context.Context, appID *uuid.UUID, last int64, send func(db.AppEvent) error) error {
+	after := max(last-ReplayWindow, 0)
+	for {
+		events, err := s.queries.ListAppEventsAfter(ctx, db.ListAppEventsAfterParams{
+			After:    after,
+			AppID:    appID,
+			PageSize: eventPageSize,
+		})
+		if err != nil {
+			return dbError(err, "failed to list events")
+		}
+		for _, event := range events {
+			if err := send(event); err != nil {
+				return err
+			}
+			after = event.ID
+		}
+		if len(events) < eventPageSize {
+			return nil
+		}
+	}
+}
+
+// record stores an event of an app. An event with the sourceKey of one
+// already recorded is dropped.
+func (s *EventService) record(ctx context.Context, appID uuid.UUID, eventType string, sourceKey *string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = s.queries.CreateAppEvent(ctx, db.CreateAppEventParams{
+		AppID:     appID,
+		Type:      eventType,
+		Data:      encoded,
+		SourceKey: sourceKey,
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return dbError(err, "failed to record event")
+	}
+	return nil
+}
+
+// RunListener fans out the events announced on eventsChannel until ctx is
+// cancelled, reconnecting whenever the connection is lost
+func (s *EventService) RunListener(ctx context.Context) {
+	for {
+		err := s.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Warn("event listener disconnected, reconnecting", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetryInterval):
+		}
+	}
+}
+
+// listen fans out announced events until the connection fails
+func (s *EventService) listen(ctx context.Context) error {
+	pooled, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection keeps listening until it is closed, so it must not go
+	// back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+eventsChannel); err != nil {
+		return err
+	}
+	if err := s.catchUp(ctx); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(notification.Payload, 10, 64)
+		if err != nil {
+			continue
+		}
+		event, err := s.queries.GetAppEvent(ctx, id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Deleted along with its app
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.fanOut(event)
+	}
+}
+
+// catchUp fans out the events recorded while the listener was disconnected
+func (s *EventService) catchUp(ctx context.Context) error {
+	s.mu.Lock()
+	last := s.last
+	s.mu.Unlock()
+	if last == 0 {
+		// Nothing was fanned out yet, so nobody can have missed anything
+		return nil
+	}
+
+	return s.Replay(ctx, nil, last, func(event db.AppEvent) error {
+		s.fanOut(event)
+		return nil
+	})
+}
+
+// fanOut delivers an event to its subscribers, dropping those that fell
+// behind. Subscribers skip the events they already got, as the listener
+// fans out events of the replay window again when it catches up.
+func (s *EventService) fanOut(event db.AppEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.last = max(s.last, event.ID)
+	for sub := range s.subscribers {
+		if sub.appID != nil && *sub.appID != event.AppID {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(s.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// RunJanitor deletes events older than the retention every interval until
+// ctx is cancelled
+func (s *EventService) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.queries.DeleteAppEventsBefore(ctx, time.Now().Add(-s.retention))
+			if err != nil {
+				s.logger.Warn("failed to delete old events", "error", err)
+				continue
+			}
+			if deleted > 0 {
+				s.logger.Debug("deleted old events", "count", deleted)
+			}
+		}
+	}
+}
+
+// publish records an event of an app. Events only report on what happens,
+// so failing to record one is logged rather than failing the caller.
+func (s *AppService) publish(ctx context.Context, app *db.App, eventType string, data any) {
+	if err := s.events.record(ctx, app.ID, eventType, nil, data); err != nil {
+		s.log(ctx, app).Warn("failed to record event", "type", eventType, "error", err)
+	}
+}
+
+// setStatus updates an app's status and records the change as an event
+func (s *AppService) setStatus(ctx context.Context, app *db.App, status, reason string) error {
+	_, err := s.queries.UpdateAppStatus(ctx, db.UpdateAppStatusParams{
+		ID:           app.ID,
+		Status:       status,
+		StatusReason: reason,
+	})
+	if err != nil {
+		return err
+	}
+	s.publish(ctx, app, EventStatus, StatusEvent{Status: status, Reason: reason})
+	return nil
+}
+
+// progress records the step a deploy moved on to, for the deploy tracker
+// and as an event
+func (s *AppService) progress(ctx context.Context, deploymentID string, app *db.App, step string) {
+	s.deploys.progress(deploymentID, step)
+	s.publish(ctx, app, EventDeploy, DeployEvent{DeploymentID: deploymentID, Step: step})
+}
+
+// WatchPodEvents records the lifecycle events of the pods of apps in every
+// cluster until ctx is cancelled. A cluster whose watch fails is watched
+// again a while later.
+func (s *AppService) WatchPodEvents(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, cluster := range s.clusters.Clusters() {
+		client, _ := s.clusters.Get(cluster.Name)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := client.WatchPodEvents(ctx, func(event k8s.PodEvent) {
+					s.recordPodEvent(ctx, cluster.Name, event)
+				})
+				if ctx.Err() != nil {
+					return
+				}
+				s.logger.Warn("pod event watch failed", "cluster", cluster.Name, "error", err)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(podEventRetryInterval):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// recordPodEvent records a pod event of the app it belongs to. Events of
+// copies an app left behind when it moved are ignored.
+func (s *AppService) recordPodEvent(ctx context.Context, cluster string, event k8s.PodEvent) {
+	app, err := s.queries.GetAppBySlug(ctx, event.App)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		s.logger.Warn("failed to get app of pod event", logging.KeyAppSlug, event.App, "error", err)
+		return
+	}
+	if app.Cluster != cluster || app.Namespace != event.Namespace {
+		return
+	}
+
+	// Every replica watches the same pods, so an event is recorded by
+	// whichever gets to it first
+	sourceKey := cluster + "/" + event.Key
+	err = s.events.record(ctx, app.ID, EventPod, &sourceKey, PodEvent{
+		Cluster:   cluster,
+		Namespace: event.Namespace,
+		Pod:       event.Pod,
+		Container: event.Container,
+		Reason:    event.Reason,
+		Message:   event.Message,
+		Time:      event.Time,
+	})
+	if err != nil {
+		s.log(ctx, &app).Warn("failed to record pod event", "reason", event.Reason, "error", err)
+	}
+}
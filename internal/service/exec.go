@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/k8s"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultExecCommand is run when an exec names no command
+var defaultExecCommand = []string{"/bin/sh"}
+
+// ExecInput is a command to run in a container of an app
+type ExecInput struct {
+	// Pod is a pod of the app, or empty for a random running one
+	Pod string
+	// Container may be empty for pods with a single container
+	Container string
+	// Command defaults to /bin/sh
+	Command []string
+	TTY     bool
+}
+
+// ExecStreams connect a command to its caller. Stderr is unused with a TTY.
+type ExecStreams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Resize returns the size of the caller's terminal each time it
+	// changes, and nil once the caller is gone
+	Resize func() *k8s.TerminalSize
+}
+
+// ExecAudit is the audit log entry of an exec session. ExitCode, Duration
+// and Error are only set once it ended.
+type ExecAudit struct {
+	Session   string   `json:"session"`
+	Cluster   string   `json:"cluster"`
+	Namespace string   `json:"namespace"`
+	Pod       string   `json:"pod"`
+	Container string   `json:"container,omitempty"`
+	Command   []string `json:"command"`
+	TTY       bool     `json:"tty"`
+	ExitCode  *int     `json:"exit_code,omitempty"`
+	Duration  string   `json:"duration,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// ExecSession is a command ready to run in a container of an app
+type ExecSession struct {
+	service *AppService
+	client  *k8s.Client
+	app     *db.App
+	input   ExecInput
+	// Pod is the pod the command runs in
+	Pod string
+}
+
+// PrepareExec checks a command and picks the pod to run it in, so a session
+// that can't start is refused before it is opened
+func (s *AppService) PrepareExec(ctx context.Context, app *db.App, input ExecInput) (*ExecSession, error) {
+	if len(input.Command) == 0 {
+		input.Command = defaultExecCommand
+	}
+	for _, arg := range input.Command {
+		if arg == "" {
+			return nil, validationFailed(FieldError{Field: "command", Message: "must not contain empty arguments"})
+		}
+	}
+
+	client, err := s.client(app.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	pod, err := s.runningPod(ctx, client, app, input.Pod)
+	if err != nil {
+		return nil, err
+	}
+	return &ExecSession{service: s, client: client, app: app, input: input, Pod: pod}, nil
+}
+
+// Run runs the command until it exits or ctx is done, returning its exit
+// code. The start and end of the session are recorded in the audit log; a
+// session whose start can't be recorded doesn't start.
+func (e *ExecSession) Run(ctx context.Context, streams ExecStreams) (int, error) {
+	s, app := e.service, e.app
+	entry := ExecAudit{
+		Session:   uuid.NewString(),
+		Cluster:   app.Cluster,
+		Namespace: app.Namespace,
+		Pod:       e.Pod,
+		Container: e.input.Container,
+		Command:   e.input.Command,
+		TTY:       e.input.TTY,
+	}
+	if err := s.audit(ctx, app, AuditExecStart, entry); err != nil {
+		return 0, err
+	}
+	logger := s.log(ctx, app).With("session", entry.Session, "pod", e.Pod)
+	logger.Info("exec session started", "command", e.input.Command)
+
+	start := time.Now()
+	exitCode, err := e.client.Exec(ctx, app.Namespace, k8s.ExecOptions{
+		Pod:       e.Pod,
+		Container: e.input.Container,
+		Command:   e.input.Command,
+		TTY:       e.input.TTY,
+		Stdin:     streams.Stdin,
+		Stdout:    streams.Stdout,
+		Stderr:    streams.Stderr,
+		Resize:    streams.Resize,
+	})
+
+	// The session ends when its caller leaves, which must still be recorded
+	entry.Duration = time.Since(start).Round(time.Second).String()
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.ExitCode = &exitCode
+	}
+	if err := s.audit(context.WithoutCancel(ctx), app, AuditExecEnd, entry); err != nil {
+		logger.Error("failed to record end of exec session", "error", err)
+	}
+	logger.Info("exec session ended", "exit_code", exitCode, "duration", entry.Duration, "error", entry.Error)
+
+	if err != nil {
+		return 0, k8sError(err, "failed to exec")
+	}
+	return exitCode, nil
+}
+
+// runningPod returns the named pod of an app if it is running, or a random
+// running pod if name is empty
+func (s *AppService) runningPod(ctx context.Context, client *k8s.Client, app *db.App, name string) (string, error) {
+	pods, err := client.ListPods(ctx, app.Namespace, app.Slug)
+	if err != nil {
+		return "", k8sError(err, "failed to list pods")
+	}
+
+	var running []string
+	for _, pod := range pods {
+		isRunning := pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil
+		if name != "" && pod.Name == name {
+			if !isRunning {
+				return "", conflict(CodePodNotRunning, "pod '%s' is not running", name)
+			}
+			return name, nil
+		}
+		if isRunning {
+			running = append(running, pod.Name)
+		}
+	}
+
+	if name != "" {
+		return "", notFound(CodePodNotFound, "pod '%s' not found", name)
+	}
+	if len(running) == 0 {
+		return "", conflict(CodeNoRunningPods, "app '%s' has no running pods", app.Slug)
+	}
+	return running[rand.IntN(len(running))], nil
+}
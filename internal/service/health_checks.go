@@ -0,0 +1,252 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/superfly/superfly/internal/k8s"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Probe types
+const (
+	ProbeHTTP = k8s.ProbeHTTP
+	ProbeTCP  = k8s.ProbeTCP
+	ProbeGRPC = k8s.ProbeGRPC
+	ProbeExec = k8s.ProbeExec
+)
+
+// HealthChecks overrides an app's container probes. A probe left out takes
+// its default: liveness and readiness probes are HTTP GETs of the app's
+// health check path, and there is no startup probe.
+type HealthChecks struct {
+	Liveness  *Probe `json:"liveness,omitempty"`
+	Readiness *Probe `json:"readiness,omitempty"`
+	Startup   *Probe `json:"startup,omitempty"`
+}
+
+// Probe configures one container probe. Timings left out take the defaults
+// of the kind of probe; Port defaults to the app's port and Path to its
+// health check path.
+type Probe struct {
+	// Disabled removes the probe; only the liveness probe can be disabled
+	Disabled            bool              `json:"disabled,omitempty"`
+	Type                string            `json:"type,omitempty" openapi:"enum=http|tcp|grpc|exec"`
+	Path                string            `json:"path,omitempty" openapi:"maxLength=255,pattern=^/"`
+	Port                int32             `json:"port,omitempty" openapi:"minimum=1,maximum=65535"`
+	Headers             map[string]string `json:"headers,omitempty"`
+	Service             string            `json:"service,omitempty"`
+	Command             []string          `json:"command,omitempty"`
+	InitialDelaySeconds *int32            `json:"initial_delay_seconds,omitempty" openapi:"minimum=0"`
+	PeriodSeconds       *int32            `json:"period_seconds,omitempty" openapi:"minimum=1"`
+	TimeoutSeconds      *int32            `json:"timeout_seconds,omitempty" openapi:"minimum=1"`
+	SuccessThreshold    *int32            `json:"success_threshold,omitempty" openapi:"minimum=1"`
+	FailureThreshold    *int32            `json:"failure_threshold,omitempty" openapi:"minimum=1"`
+}
+
+// probeTimings are the timings of a kind of probe
+type probeTimings struct {
+	initialDelay, period, timeout, success, failure int32
+}
+
+// Default timings. The startup probe allows 150s to boot before the
+// liveness probe takes over.
+var (
+	livenessTimings  = probeTimings{initialDelay: 10, period: 10, timeout: 5, success: 1, failure: 3}
+	readinessTimings = probeTimings{initialDelay: 5, period: 5, timeout: 3, success: 1, failure: 3}
+	startupTimings   = probeTimings{initialDelay: 0, period: 5, timeout: 3, success: 1, failure: 30}
+)
+
+// validateHealthChecks checks each probe of an API request
+func validateHealthChecks(checks *HealthChecks) error {
+	if checks == nil {
+		return nil
+	}
+	if fields := healthCheckErrors("health_checks", checks); len(fields) > 0 {
+		return validationFailed(fields...)
+	}
+	return nil
+}
+
+// healthCheckErrors checks each probe, naming fields under prefix
+func healthCheckErrors(prefix string, checks *HealthChecks) []FieldError {
+	var fields []FieldError
+	fields = append(fields, probeErrors(prefix+".liveness", checks.Liveness, true)...)
+	fields = append(fields, probeErrors(prefix+".readiness", checks.Readiness, false)...)
+	fields = append(fields, probeErrors(prefix+".startup", checks.Startup, false)...)
+
+	// Kubernetes requires a success threshold of 1 for liveness and startup
+	// probes, since they only decide whether to restart the container
+	if p := checks.Liveness; p != nil && p.SuccessThreshold != nil && *p.SuccessThreshold > 1 {
+		fields = append(fields, FieldError{Field: prefix + ".liveness.success_threshold", Message: "must be 1"})
+	}
+	if p := checks.Startup; p != nil && p.SuccessThreshold != nil && *p.SuccessThreshold > 1 {
+		fields = append(fields, FieldError{Field: prefix + ".startup.success_threshold", Message: "must be 1"})
+	}
+	return fields
+}
+
+// probeErrors checks that a probe's type is known, that it only sets the
+// fields of its type and that its timings are in range
+func probeErrors(field string, p *Probe, canDisable bool) []FieldError {
+	if p == nil {
+		return nil
+	}
+
+	var fields []FieldError
+	add := func(name, msg string) {
+		fields = append(fields, FieldError{Field: field + name, Message: msg})
+	}
+
+	if p.Disabled {
+		switch {
+		case !canDisable:
+			add(".disabled", "only the liveness probe can be disabled")
+		case p.Type != "":
+			add(".type", "must not be set on a disabled probe")
+		}
+		return fields
+	}
+
+	switch p.Type {
+	case "":
+		add(".type", "is required")
+	case ProbeHTTP, ProbeTCP, ProbeGRPC, ProbeExec:
+	default:
+		add(".type", "must be one of http, tcp, grpc, exec")
+	}
+
+	if p.Type != ProbeHTTP {
+		if p.Path != "" {
+			add(".path", "is only used by http probes")
+		}
+		if len(p.Headers) > 0 {
+			add(".headers", "are only used by http probes")
+		}
+	}
+	if p.Path != "" && !strings.HasPrefix(p.Path, "/") {
+		add(".path", "must start with /")
+	}
+	names := make([]string, 0, len(p.Headers))
+	for name := range p.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, msg := range validation.IsHTTPHeaderName(name) {
+			add(".headers."+name, msg)
+		}
+	}
+
+	if p.Type == ProbeExec {
+		if p.Port != 0 {
+			add(".port", "is not used by exec probes")
+		}
+		if len(p.Command) == 0 {
+			add(".command", "is required for exec probes")
+		}
+	} else if len(p.Command) > 0 {
+		add(".command", "is only used by exec probes")
+	}
+	if p.Port < 0 || p.Port > 65535 {
+		add(".port", "must be between 1 and 65535")
+	}
+	if p.Service != "" && p.Type != ProbeGRPC {
+		add(".service", "is only used by grpc probes")
+	}
+
+	timings := []struct {
+		name  string
+		value *int32
+		min   int32
+	}{
+		{".initial_delay_seconds", p.InitialDelaySeconds, 0},
+		{".period_seconds", p.PeriodSeconds, 1},
+		{".timeout_seconds", p.TimeoutSeconds, 1},
+		{".success_threshold", p.SuccessThreshold, 1},
+		{".failure_threshold", p.FailureThreshold, 1},
+	}
+	for _, t := range timings {
+		if t.value != nil && *t.value < t.min {
+			add(t.name, fmt.Sprintf("must be at least %d", t.min))
+		}
+	}
+
+	return fields
+}
+
+// encodeHealthChecks encodes checks for the apps.health_checks column
+func encodeHealthChecks(checks HealthChecks) (json.RawMessage, error) {
+	raw, err := json.Marshal(checks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode health checks: %w", err)
+	}
+	return raw, nil
+}
+
+// decodeHealthChecks decodes the apps.health_checks column
+func decodeHealthChecks(raw json.RawMessage) (HealthChecks, error) {
+	var checks HealthChecks
+	if len(raw) == 0 {
+		return checks, nil
+	}
+	if err := json.Unmarshal(raw, &checks); err != nil {
+		return HealthChecks{}, fmt.Errorf("failed to decode health checks: %w", err)
+	}
+	return checks, nil
+}
+
+// k8sProbes resolves the probes a deploy applies, filling in defaults from
+// the app's port and health check path
+func k8sProbes(checks HealthChecks, port int32, healthCheckPath string) (liveness, readiness, startup *k8s.Probe) {
+	defaultHTTP := &Probe{Type: ProbeHTTP}
+	if checks.Liveness == nil {
+		checks.Liveness = defaultHTTP
+	}
+	if checks.Readiness == nil {
+		checks.Readiness = defaultHTTP
+	}
+
+	liveness = k8sProbe(checks.Liveness, livenessTimings, port, healthCheckPath)
+	readiness = k8sProbe(checks.Readiness, readinessTimings, port, healthCheckPath)
+	startup = k8sProbe(checks.Startup, startupTimings, port, healthCheckPath)
+	return liveness, readiness, startup
+}
+
+func k8sProbe(p *Probe, defaults probeTimings, port int32, healthCheckPath string) *k8s.Probe {
+	if p == nil || p.Disabled {
+		return nil
+	}
+
+	probe := &k8s.Probe{
+		Type:                p.Type,
+		Port:                p.Port,
+		Headers:             p.Headers,
+		GRPCService:         p.Service,
+		Command:             p.Command,
+		InitialDelaySeconds: orDefault(p.InitialDelaySeconds, defaults.initialDelay),
+		PeriodSeconds:       orDefault(p.PeriodSeconds, defaults.period),
+		TimeoutSeconds:      orDefault(p.TimeoutSeconds, defaults.timeout),
+		SuccessThreshold:    orDefault(p.SuccessThreshold, defaults.success),
+		FailureThreshold:    orDefault(p.FailureThreshold, defaults.failure),
+	}
+	if probe.Port == 0 && p.Type != ProbeExec {
+		probe.Port = port
+	}
+	if p.Type == ProbeHTTP {
+		probe.Path = p.Path
+		if probe.Path == "" {
+			probe.Path = healthCheckPath
+		}
+	}
+	return probe
+}
+
+func orDefault(value *int32, def int32) int32 {
+	if value == nil {
+		return def
+	}
+	return *value
+}
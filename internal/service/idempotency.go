@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/superfly/superfly/internal/auth"
+	"github.com/superfly/superfly/internal/db"
+)
+
+// idempotencyLockTimeout is how long an in-progress claim is honored before a
+// retry of the same request may take it over, e.g. after a crash. It exceeds
+// the server's 60s request timeout.
+const idempotencyLockTimeout = 2 * time.Minute
+
+// Error codes for idempotency key misuse
+const (
+	CodeIdempotencyKeyReused     = "idempotency_key_reused"
+	CodeIdempotencyKeyInProgress = "idempotency_key_in_progress"
+)
+
+// ErrKeyReused is returned when an idempotency key is reused with a
+// different request
+var ErrKeyReused = errors.New("idempotency key reused")
+
+// StoredResponse is a response recorded for an idempotency key
+type StoredResponse struct {
+	StatusCode int
+	Headers    http.Header
+	Body       []byte
+}
+
+// IdempotencyService records responses to mutating requests so that client
+// retries carrying the same Idempotency-Key replay the original response
+// instead of repeating the operation
+type IdempotencyService struct {
+	queries *db.Queries
+	ttl     time.Duration
+	logger  *slog.Logger
+}
+
+func NewIdempotencyService(pool *pgxpool.Pool, ttl time.Duration, logger *slog.Logger) *IdempotencyService {
+	return &IdempotencyService{
+		queries: db.New(pool),
+		ttl:     ttl,
+		logger:  logger,
+	}
+}
+
+// RequestHash fingerprints a caller's request so a reused key can be told
+// apart from a retry
+func RequestHash(principal, method, path string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", principal, method, path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Begin claims key for a request. Keys are scoped to the caller of ctx, so
+// callers cannot replay each other's responses. It returns (nil, nil) when the caller owns
+// the key and must run the request, then call Complete or Release. It returns
+// the stored response when the request already ran, a Conflict error when
+// the original is still running and a Validation error wrapping ErrKeyReused
+// when the key was used for a different request.
+func (s *IdempotencyService) Begin(ctx context.Context, key, requestHash string) (*StoredResponse, error) {
+	principal := auth.FromContext(ctx).Name
+	now := time.Now()
+	claimed, err := s.queries.ClaimIdempotencyKey(ctx, db.ClaimIdempotencyKeyParams{
+		Principal:   principal,
+		Key:         key,
+		RequestHash: requestHash,
+		ExpiresAt:   now.Add(s.ttl),
+		CreatedAt:   now.Add(-idempotencyLockTimeout),
+	})
+	if err != nil {
+		return nil, dbError(err, "failed to claim idempotency key")
+	}
+	if claimed == 1 {
+		return nil, nil
+	}
+
+	existing, err := s.queries.GetIdempotencyKey(ctx, db.GetIdempotencyKeyParams{Principal: principal, Key: key})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Purged between the claim and the lookup; let the client retry
+		return nil, conflict(CodeIdempotencyKeyInProgress, "idempotency key is being processed, retry shortly")
+	}
+	if err != nil {
+		return nil, dbError(err, "failed to get idempotency key")
+	}
+
+	if existing.RequestHash != requestHash {
+		return nil, &Error{
+			Kind:    ErrValidation,
+			Code:    CodeIdempotencyKeyReused,
+			Message: "idempotency key was already used for a different request",
+			Err:     ErrKeyReused,
+		}
+	}
+	if existing.StatusCode == nil {
+		return nil, conflict(CodeIdempotencyKeyInProgress, "a request with this idempotency key is still in progress")
+	}
+
+	stored := &StoredResponse{
+		StatusCode: int(*existing.StatusCode),
+		Headers:    http.Header{},
+		Body:       existing.ResponseBody,
+	}
+	if len(existing.ResponseHeaders) > 0 {
+		if err := json.Unmarshal(existing.ResponseHeaders, &stored.Headers); err != nil {
+			return nil, fmt.Errorf("failed to decode stored headers: %w", err)
+		}
+	}
+	return stored, nil
+}
+
+// Complete stores the response of a request whose key was claimed with Begin
+func (s *IdempotencyService) Complete(ctx context.Context, key string, response StoredResponse) error {
+	headers, err := json.Marshal(response.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	statusCode := int32(response.StatusCode)
+	err = s.queries.CompleteIdempotencyKey(ctx, db.CompleteIdempotencyKeyParams{
+		Principal:       auth.FromContext(ctx).Name,
+		Key:             key,
+		StatusCode:      &statusCode,
+		ResponseHeaders: headers,
+		ResponseBody:    response.Body,
+	})
+	if err != nil {
+		return dbError(err, "failed to store idempotent response")
+	}
+	return nil
+}
+
+// Release gives up a claimed key without storing a response, so a retry runs
+// the request again. Used when the request failed with a server error.
+func (s *IdempotencyService) Release(ctx context.Context, key string) error {
+	err := s.queries.DeleteIdempotencyKey(ctx, db.DeleteIdempotencyKeyParams{
+		Principal: auth.FromContext(ctx).Name,
+		Key:       key,
+	})
+	if err != nil {
+		return dbError(err, "failed to release idempotency key")
+	}
+	return nil
+}
+
+// RunJanitor deletes expired keys every interval until ctx is cancelled
+func (s *IdempotencyService) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.queries.DeleteExpiredIdempotencyKeys(ctx)
+			if err != nil {
+				s.logger.Warn("failed to delete expired idempotency keys", "error", err)
+				continue
+			}
+			if deleted > 0 {
+				s.logger.Debug("deleted expired idempotency keys", "count", deleted)
+			}
+		}
+	}
+}
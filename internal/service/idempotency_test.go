@@ -0,0 +1,31 @@
+package service
+
+import "testing"
+
+func TestRequestHash(t *testing.T) {
+	base := RequestHash("alice", "POST", "/api/apps", []byte(`{"name":"web"}`))
+
+	tests := []struct {
+		name      string
+		principal string
+		method    string
+		path      string
+		body      string
+		wantSame  bool
+	}{
+		{"retry", "alice", "POST", "/api/apps", `{"name":"web"}`, true},
+		{"other caller", "bob", "POST", "/api/apps", `{"name":"web"}`, false},
+		{"other method", "alice", "PATCH", "/api/apps", `{"name":"web"}`, false},
+		{"other path", "alice", "POST", "/api/apply", `{"name":"web"}`, false},
+		{"other body", "alice", "POST", "/api/apps", `{"name":"api"}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RequestHash(tt.principal, tt.method, tt.path, []byte(tt.body))
+			if (got == base) != tt.wantSame {
+				t.Errorf("hash equal to the original: %v, want %v", got == base, tt.wantSame)
+			}
+		})
+	}
+}
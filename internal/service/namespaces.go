@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/k8s"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Namespaces decides which Kubernetes namespace an app is deployed to. An
+// app's owner is its tenant: apps of the same owner share a namespace,
+// with its own quota, apart from other owners' apps.
+type Namespaces struct {
+	// Default is the namespace of apps without an owner
+	Default string
+	// TenantPrefix is prepended to an owner to name its namespace
+	TenantPrefix string
+}
+
+// forOwner returns the namespace of a new app of owner. The namespace is
+// stored with the app, so changing its owner later doesn't move it.
+func (n Namespaces) forOwner(owner string) string {
+	tenant := slugify(owner)
+	if tenant == "" {
+		return n.Default
+	}
+	name := n.TenantPrefix + tenant
+	if len(name) > validation.DNS1123LabelMaxLength {
+		name = strings.TrimRight(name[:validation.DNS1123LabelMaxLength], "-")
+	}
+	return name
+}
+
+// namespaceErrors checks a namespace an app is deployed to
+func namespaceErrors(namespace string) []FieldError {
+	var fields []FieldError
+	for _, msg := range validation.IsDNS1123Label(namespace) {
+		fields = append(fields, FieldError{Field: "namespace", Message: msg})
+	}
+	if namespace == "default" || strings.HasPrefix(namespace, "kube-") {
+		fields = append(fields, FieldError{Field: "namespace", Message: "must not be a system namespace"})
+	}
+	return fields
+}
+
+// validateNamespace checks a namespace an app is deployed to
+func validateNamespace(namespace string) error {
+	if fields := namespaceErrors(namespace); len(fields) > 0 {
+		return validationFailed(fields...)
+	}
+	return nil
+}
+
+// EnsureNamespaces ensures, in every cluster, each namespace with apps and
+// the default one, along with the NetworkPolicies of their apps. Run at
+// startup, it labels and sets or lifts quotas on namespaces created before they
+// were managed, and isolates apps deployed before network policies were
+// managed without waiting for their next deploy. A cluster that fails
+// doesn't keep the others from being ensured.
+func (s *AppService) EnsureNamespaces(ctx context.Context) error {
+	// Apps are listed a page at a time, so no single query reads them all
+	apps := make(map[string][]db.App)
+	input := ListAppsInput{Sort: SortSlug, Limit: maxPageSize}
+	for {
+		page, err := s.ListApps(ctx, input)
+		if err != nil {
+			return err
+		}
+		for _, app := range page.Apps {
+			apps[app.Cluster] = append(apps[app.Cluster], app)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		input.Cursor = page.NextCursor
+	}
+
+	var errs []error
+	for _, cluster := range s.clusters.Clusters() {
+		client, _ := s.clusters.Get(cluster.Name)
+		if err := s.ensureClusterNamespaces(ctx, client, apps[cluster.Name]); err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", cluster.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ensureClusterNamespaces ensures the namespaces of a cluster's apps. A
+// namespace's default deny policy is left out if any of its apps' policies
+// fails, since it would cut that app off.
+func (s *AppService) ensureClusterNamespaces(ctx context.Context, client *k8s.Client, apps []db.App) error {
+	namespaces := []string{s.namespaces.Default}
+	for _, app := range apps {
+		if !slices.Contains(namespaces, app.Namespace) {
+			namespaces = append(namespaces, app.Namespace)
+		}
+	}
+	for _, namespace := range namespaces {
+		if err := client.EnsureNamespace(ctx, namespace); err != nil {
+			return fmt.Errorf("failed to ensure namespace %s: %w", namespace, err)
+		}
+	}
+
+	for i := range apps {
+		config, err := configFromApp(&apps[i])
+		if err != nil {
+			return err
+		}
+		spec := config.spec(apps[i].Slug)
+		if err := client.ApplyNetworkPolicy(ctx, k8s.BuildNetworkPolicy(spec)); err != nil {
+			return fmt.Errorf("failed to apply network policy of %s: %w", apps[i].Slug, err)
+		}
+	}
+
+	for _, namespace := range namespaces {
+		if err := client.ApplyNetworkPolicy(ctx, k8s.BuildDefaultDenyPolicy(namespace)); err != nil {
+			return fmt.Errorf("failed to apply default deny network policy of %s: %w", namespace, err)
+		}
+	}
+	return nil
+}
+
+// deleteResources deletes an app's Kubernetes resources in a namespace of
+// a cluster. Failures are logged rather than returned, so one stuck
+// resource doesn't keep the others around.
+func (s *AppService) deleteResources(ctx context.Context, client *k8s.Client, app *db.App, namespace string) {
+	logger := s.log(ctx, app).With("cluster", client.Cluster(), "namespace", namespace)
+
+	if err := client.DeleteIngress(ctx, namespace, app.Slug); err != nil {
+		logger.Warn("failed to delete ingress", "error", err)
+	}
+	if err := client.DeleteService(ctx, namespace, app.Slug); err != nil {
+		logger.Warn("failed to delete service", "error", err)
+	}
+	if err := client.PruneAliasServices(ctx, namespace, app.Slug, nil); err != nil {
+		logger.Warn("failed to delete alias services", "error", err)
+	}
+	if err := client.DeleteDeployment(ctx, namespace, app.Slug); err != nil {
+		logger.Warn("failed to delete deployment", "error", err)
+	}
+	if err := client.DeleteNetworkPolicy(ctx, namespace, app.Slug); err != nil {
+		logger.Warn("failed to delete network policy", "error", err)
+	}
+}
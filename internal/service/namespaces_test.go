@@ -0,0 +1,85 @@
+package service
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestNamespacesForOwner(t *testing.T) {
+	namespaces := Namespaces{Default: "apps", TenantPrefix: "tenant-"}
+
+	tests := []struct {
+		name  string
+		owner string
+		want  string
+	}{
+		{name: "no owner", owner: "", want: "apps"},
+		{name: "owner", owner: "Acme Corp", want: "tenant-acme-corp"},
+		{name: "owner without valid characters", owner: "!!!", want: "apps"},
+		{
+			name:  "long owner",
+			owner: strings.Repeat("a", 70),
+			want:  "tenant-" + strings.Repeat("a", 56),
+		},
+		{
+			name:  "truncated at a hyphen",
+			owner: strings.Repeat("a", 55) + "-bcdef",
+			want:  "tenant-" + strings.Repeat("a", 55),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := namespaces.forOwner(tt.owner)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if msgs := validation.IsDNS1123Label(got); len(msgs) > 0 {
+				t.Errorf("%q is not a valid namespace: %v", got, msgs)
+			}
+		})
+	}
+}
+
+func TestNamespaceErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		want      []FieldError
+	}{
+		{name: "tenant namespace", namespace: "tenant-acme"},
+		{name: "apps namespace", namespace: "superfly-apps"},
+		{
+			name:      "default namespace",
+			namespace: "default",
+			want:      []FieldError{{Field: "namespace", Message: "must not be a system namespace"}},
+		},
+		{
+			name:      "kube namespace",
+			namespace: "kube-system",
+			want:      []FieldError{{Field: "namespace", Message: "must not be a system namespace"}},
+		},
+		{
+			name:      "invalid label",
+			namespace: "Apps_1",
+			want:      fieldMessages("namespace", validation.IsDNS1123Label("Apps_1")),
+		},
+		{
+			name:      "too long",
+			namespace: strings.Repeat("a", 64),
+			want:      fieldMessages("namespace", validation.IsDNS1123Label(strings.Repeat("a", 64))),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := namespaceErrors(tt.namespace)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
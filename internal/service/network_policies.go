@@ -0,0 +1,21 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/superfly/superfly/internal/k8s"
+)
+
+// applyNetworkPolicies applies an app's NetworkPolicy and then its
+// namespace's default deny policy, so the app is never left without the
+// rules that let its traffic through
+func (s *AppService) applyNetworkPolicies(ctx context.Context, client *k8s.Client, spec k8s.AppSpec) error {
+	if err := client.ApplyNetworkPolicy(ctx, k8s.BuildNetworkPolicy(spec)); err != nil {
+		return fmt.Errorf("failed to apply network policy: %w", err)
+	}
+	if err := client.ApplyNetworkPolicy(ctx, k8s.BuildDefaultDenyPolicy(spec.Namespace)); err != nil {
+		return fmt.Errorf("failed to apply default deny network policy: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superfly/superfly/internal/db"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// Sort options for ListApps
+const (
+	SortCreatedAt = "created_at"
+	SortUpdatedAt = "updated_at"
+	SortName      = "name"
+	SortSlug      = "slug"
+)
+
+// ListAppsInput holds the filters, sort order and page position for ListApps.
+// Empty filters match everything.
+type ListAppsInput struct {
+	Status string
+	Search string // substring of name or slug
+	Domain string
+	Image  string // image prefix, e.g. "nginx" matches "nginx:alpine"
+	Owner  string
+	Labels map[string]string
+
+	Sort       string // one of the Sort* constants, default SortCreatedAt
+	Descending bool
+	Limit      int32
+	Cursor     string
+}
+
+// ListAppsResult is a page of apps. NextCursor is empty on the last page.
+type ListAppsResult struct {
+	Apps       []db.App
+	NextCursor string
+}
+
+// pageCursor is the decoded form of the opaque cursor handed to clients.
+// It records the sort it was issued for so it cannot be replayed against a
+// different ordering. Time is the sort column of timestamp sorts and Key
+// that of the others.
+type pageCursor struct {
+	Sort       string     `json:"s"`
+	Descending bool       `json:"d"`
+	Key        string     `json:"k,omitempty"`
+	Time       *time.Time `json:"t,omitempty"`
+	ID         uuid.UUID  `json:"id"`
+}
+
+// appFilter holds the filters of ListApps as the ListAppsBy* queries take
+// them; nil matches everything
+type appFilter struct {
+	Status *string
+	Search *string
+	Domain *string
+	Image  *string
+	Owner  *string
+	Labels json.RawMessage
+}
+
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// isTimeSort reports whether sort orders by a timestamp column
+func isTimeSort(sort string) bool {
+	return sort == SortCreatedAt || sort == SortUpdatedAt
+}
+
+// cursorAfter returns the cursor of the page that starts after app
+func cursorAfter(app *db.App, sort string, descending bool) pageCursor {
+	c := pageCursor{Sort: sort, Descending: descending, ID: app.ID}
+	switch sort {
+	case SortName:
+		c.Key = app.Name
+	case SortSlug:
+		c.Key = app.Slug
+	case SortUpdatedAt:
+		c.Time = &app.UpdatedAt
+	default:
+		c.Time = &app.CreatedAt
+	}
+	return c
+}
+
+// listAppsPage runs the ListAppsBy* query of the sort order, starting after
+// cursor unless it is nil. The queries of timestamp columns take identical
+// params, as do those of text columns, so one of each kind is built and
+// converted.
+func listAppsPage(ctx context.Context, queries *db.Queries, sort string, descending bool, filter appFilter, cursor *pageCursor, pageSize int32) ([]db.App, error) {
+	if isTimeSort(sort) {
+		params := db.ListAppsByCreatedAtAscParams{
+			Status:   filter.Status,
+			Search:   filter.Search,
+			Domain:   filter.Domain,
+			Image:    filter.Image,
+			Owner:    filter.Owner,
+			Labels:   filter.Labels,
+			PageSize: pageSize,
+		}
+		if cursor != nil {
+			params.CursorTime = cursor.Time
+			params.CursorID = &cursor.ID
+		}
+		switch {
+		case sort == SortUpdatedAt && descending:
+			return queries.ListAppsByUpdatedAtDesc(ctx, db.ListAppsByUpdatedAtDescParams(params))
+		case sort == SortUpdatedAt:
+			return queries.ListAppsByUpdatedAtAsc(ctx, db.ListAppsByUpdatedAtAscParams(params))
+		case descending:
+			return queries.ListAppsByCreatedAtDesc(ctx, db.ListAppsByCreatedAtDescParams(params))
+		default:
+			return queries.ListAppsByCreatedAtAsc(ctx, params)
+		}
+	}
+
+	params := db.ListAppsByNameAscParams{
+		Status:   filter.Status,
+		Search:   filter.Search,
+		Domain:   filter.Domain,
+		Image:    filter.Image,
+		Owner:    filter.Owner,
+		Labels:   filter.Labels,
+		PageSize: pageSize,
+	}
+	if cursor != nil {
+		params.CursorKey = &cursor.Key
+		params.CursorID = &cursor.ID
+	}
+	switch {
+	case sort == SortSlug && descending:
+		return queries.ListAppsBySlugDesc(ctx, db.ListAppsBySlugDescParams(params))
+	case sort == SortSlug:
+		return queries.ListAppsBySlugAsc(ctx, db.ListAppsBySlugAscParams(params))
+	case descending:
+		return queries.ListAppsByNameDesc(ctx, db.ListAppsByNameDescParams(params))
+	default:
+		return queries.ListAppsByNameAsc(ctx, params)
+	}
+}
+
+// escapeLike escapes LIKE wildcards so user input only matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// optional returns nil for an empty string, i.e. "no filter"
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superfly/superfly/internal/db"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 30, 0, 123456000, time.UTC)
+	updated := created.Add(time.Hour)
+	app := &db.App{ID: uuid.New(), Name: "Web", Slug: "web", CreatedAt: created, UpdatedAt: updated}
+
+	tests := []struct {
+		sort       string
+		descending bool
+		wantKey    string
+		wantTime   *time.Time
+	}{
+		{sort: SortCreatedAt, descending: true, wantTime: &created},
+		{sort: SortUpdatedAt, wantTime: &updated},
+		{sort: SortName, wantKey: "Web"},
+		{sort: SortSlug, descending: true, wantKey: "web"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			got, err := decodeCursor(encodeCursor(cursorAfter(app, tt.sort, tt.descending)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Sort != tt.sort || got.Descending != tt.descending || got.ID != app.ID {
+				t.Errorf("got cursor %+v for sort %s, descending %v", got, tt.sort, tt.descending)
+			}
+			if got.Key != tt.wantKey {
+				t.Errorf("got key %q, want %q", got.Key, tt.wantKey)
+			}
+			switch {
+			case tt.wantTime == nil && got.Time != nil:
+				t.Errorf("got time %v, want none", got.Time)
+			case tt.wantTime != nil && (got.Time == nil || !got.Time.Equal(*tt.wantTime)):
+				t.Errorf("got time %v, want %v", got.Time, tt.wantTime)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, cursor := range []string{"not base64!", "bm90IGpzb24"} {
+		if _, err := decodeCursor(cursor); err == nil {
+			t.Errorf("decodeCursor(%q) succeeded", cursor)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := map[string]string{
+		"nginx":     "nginx",
+		"100%":      `100\%`,
+		"my_app":    `my\_app`,
+		`back\path`: `back\\path`,
+	}
+	for in, want := range tests {
+		if got := escapeLike(in); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/superfly/superfly/internal/db"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// Pod is an instance of an app
+type Pod struct {
+	Name  string `json:"name"`
+	Phase string `json:"phase"`
+	// Node is empty until the pod is scheduled
+	Node     string `json:"node"`
+	Ready    bool   `json:"ready"`
+	Restarts int32  `json:"restarts"`
+	// LastTerminationReason is why a container of the pod last stopped,
+	// such as OOMKilled or Error
+	LastTerminationReason string         `json:"last_termination_reason,omitempty"`
+	Containers            []PodContainer `json:"containers"`
+	CreatedAt             time.Time      `json:"created_at"`
+	Age                   string         `json:"age"`
+}
+
+// PodContainer is a container of a pod
+type PodContainer struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// ImageDigest is the digest of the image the container runs, empty
+	// until it was pulled
+	ImageDigest string `json:"image_digest"`
+	Ready       bool   `json:"ready"`
+	Restarts    int32  `json:"restarts"`
+	// State is waiting, running or terminated, with the reason for the
+	// first and last
+	State                 string `json:"state"`
+	StateReason           string `json:"state_reason,omitempty"`
+	LastTerminationReason string `json:"last_termination_reason,omitempty"`
+	LastExitCode          *int32 `json:"last_exit_code,omitempty"`
+}
+
+// KubernetesEvent is an event Kubernetes recorded about an object of an app
+type KubernetesEvent struct {
+	// Type is Normal or Warning
+	Type    string `json:"type"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	// Object is the kind and name of the object, such as Pod/my-app-7d9c8-x2k4f
+	Object string `json:"object"`
+	// Source is the component that reported it, such as kubelet
+	Source    string    `json:"source"`
+	Count     int32     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// ListPods lists the pods of an app in its cluster, oldest first
+func (s *AppService) ListPods(ctx context.Context, app *db.App) ([]Pod, error) {
+	client, err := s.client(app.Cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := client.ListPods(ctx, app.Namespace, app.Slug)
+	if err != nil {
+		return nil, k8sError(err, "failed to list pods")
+	}
+
+	now := time.Now()
+	result := make([]Pod, len(pods))
+	for i, pod := range pods {
+		result[i] = podFromK8s(&pod, now)
+	}
+	return result, nil
+}
+
+// ListKubernetesEvents lists the events Kubernetes recorded about an app's
+// Deployment, ReplicaSets and pods, most recent last
+func (s *AppService) ListKubernetesEvents(ctx context.Context, app *db.App) ([]KubernetesEvent, error) {
+	client, err := s.client(app.Cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := client.ListEvents(ctx, app.Namespace, app.Slug)
+	if err != nil {
+		return nil, k8sError(err, "failed to list events")
+	}
+
+	result := make([]KubernetesEvent, len(events))
+	for i, event := range events {
+		first, last := event.FirstTimestamp.Time, event.LastTimestamp.Time
+		if first.IsZero() {
+			first = event.EventTime.Time
+		}
+		if last.IsZero() {
+			last = first
+		}
+		source := event.Source.Component
+		if source == "" {
+			source = event.ReportingController
+		}
+		result[i] = KubernetesEvent{
+			Type:      event.Type,
+			Reason:    event.Reason,
+			Message:   event.Message,
+			Object:    event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name,
+			Source:    source,
+			Count:     max(event.Count, 1),
+			FirstSeen: first,
+			LastSeen:  last,
+		}
+	}
+	return result, nil
+}
+
+// DeletePod deletes a pod of an app, such as a stuck one, which Kubernetes
+// replaces with a new one
+func (s *AppService) DeletePod(ctx context.Context, app *db.App, name string) error {
+	client, err := s.client(app.Cluster)
+	if err != nil {
+		return err
+	}
+
+	err = client.DeletePod(ctx, app.Namespace, app.Slug, name)
+	if apierrors.IsNotFound(err) {
+		return notFound(CodePodNotFound, "pod '%s' not found", name)
+	}
+	if err != nil {
+		return k8sError(err, "failed to delete pod")
+	}
+
+	s.log(ctx, app).Info("pod deleted", "pod", name)
+	return nil
+}
+
+// podFromK8s describes a pod as of now
+func podFromK8s(pod *corev1.Pod, now time.Time) Pod {
+	result := Pod{
+		Name:       pod.Name,
+		Phase:      string(pod.Status.Phase),
+		Node:       pod.Spec.NodeName,
+		Containers: make([]PodContainer, 0, len(pod.Status.ContainerStatuses)),
+		CreatedAt:  pod.CreationTimestamp.Time,
+		Age:        duration.HumanDuration(now.Sub(pod.CreationTimestamp.Time)),
+	}
+	if pod.DeletionTimestamp != nil {
+		// As kubectl shows it
+		result.Phase = "Terminating"
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			result.Ready = condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	var lastTerminated time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		container := PodContainer{
+			Name:        status.Name,
+			Image:       status.Image,
+			ImageDigest: imageDigest(status.ImageID),
+			Ready:       status.Ready,
+			Restarts:    status.RestartCount,
+		}
+		switch state := status.State; {
+		case state.Waiting != nil:
+			container.State, container.StateReason = "waiting", state.Waiting.Reason
+		case state.Running != nil:
+			container.State = "running"
+		case state.Terminated != nil:
+			container.State, container.StateReason = "terminated", state.Terminated.Reason
+		}
+		if terminated := status.LastTerminationState.Terminated; terminated != nil {
+			container.LastTerminationReason = terminated.Reason
+			container.LastExitCode = &terminated.ExitCode
+			if result.LastTerminationReason == "" || terminated.FinishedAt.Time.After(lastTerminated) {
+				lastTerminated = terminated.FinishedAt.Time
+				result.LastTerminationReason = terminated.Reason
+			}
+		}
+
+		result.Restarts += status.RestartCount
+		result.Containers = append(result.Containers, container)
+	}
+	return result
+}
+
+// imageDigest returns the digest of a container status's image ID, such as
+// sha256:4c0f... of docker.io/library/nginx@sha256:4c0f... Images that
+// weren't pulled from a registry have none.
+func imageDigest(imageID string) string {
+	if i := strings.LastIndexByte(imageID, '@'); i >= 0 {
+		return imageID[i+1:]
+	}
+	return ""
+}
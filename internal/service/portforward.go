@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/k8s"
+)
+
+// PortForwardInput is a port of an app to forward connections to
+type PortForwardInput struct {
+	// Pod is a pod of the app, or empty for a random running one
+	Pod  string
+	Port int
+}
+
+// PortForwardAudit is the audit log entry of a port-forward session.
+// Duration and Error are only set once it ended.
+type PortForwardAudit struct {
+	Session   string `json:"session"`
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Port      int    `json:"port"`
+	Duration  string `json:"duration,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// PortForwardSession is a port of a pod of an app ready to forward
+// connections to
+type PortForwardSession struct {
+	service *AppService
+	client  *k8s.Client
+	app     *db.App
+	port    int
+	// Pod is the pod connections are forwarded to
+	Pod string
+}
+
+// PreparePortForward checks a port and picks the pod to forward connections
+// to, so a session that can't start is refused before it is opened. Any
+// port of the pod may be forwarded to, whether the app declares it or not.
+func (s *AppService) PreparePortForward(ctx context.Context, app *db.App, input PortForwardInput) (*PortForwardSession, error) {
+	if input.Port < 1 || input.Port > 65535 {
+		return nil, validationFailed(FieldError{Field: "port", Message: "must be between 1 and 65535"})
+	}
+
+	client, err := s.client(app.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	pod, err := s.runningPod(ctx, client, app, input.Pod)
+	if err != nil {
+		return nil, err
+	}
+	return &PortForwardSession{service: s, client: client, app: app, port: input.Port, Pod: pod}, nil
+}
+
+// Run connects to the pod and calls serve to forward connections over the
+// connection until serve returns, which it must once ctx is done or the
+// connection is lost. The start and end of the session are recorded in the
+// audit log; a session whose start can't be recorded doesn't start.
+func (p *PortForwardSession) Run(ctx context.Context, serve func(*k8s.PortForward) error) error {
+	s, app := p.service, p.app
+	entry := PortForwardAudit{
+		Session:   uuid.NewString(),
+		Cluster:   app.Cluster,
+		Namespace: app.Namespace,
+		Pod:       p.Pod,
+		Port:      p.port,
+	}
+	if err := s.audit(ctx, app, AuditPortForwardStart, entry); err != nil {
+		return err
+	}
+	logger := s.log(ctx, app).With("session", entry.Session, "pod", p.Pod)
+	logger.Info("port-forward session started", "port", p.port)
+
+	start := time.Now()
+	forward, err := p.client.PortForward(ctx, app.Namespace, p.Pod, p.port)
+	if err == nil {
+		err = serve(forward)
+		forward.Close()
+	}
+
+	// The session ends when its caller leaves, which must still be recorded
+	entry.Duration = time.Since(start).Round(time.Second).String()
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if err := s.audit(context.WithoutCancel(ctx), app, AuditPortForwardEnd, entry); err != nil {
+		logger.Error("failed to record end of port-forward session", "error", err)
+	}
+	logger.Info("port-forward session ended", "duration", entry.Duration, "error", entry.Error)
+
+	if err != nil {
+		return k8sError(err, "failed to forward port")
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/superfly/superfly/internal/k8s"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Port protocols
+const (
+	ProtocolHTTP  = k8s.ProtocolHTTP
+	ProtocolHTTP2 = k8s.ProtocolHTTP2
+	ProtocolGRPC  = k8s.ProtocolGRPC
+	ProtocolTCP   = k8s.ProtocolTCP
+	ProtocolUDP   = k8s.ProtocolUDP
+)
+
+// Service types
+const (
+	ServiceTypeClusterIP    = k8s.ServiceTypeClusterIP
+	ServiceTypeNodePort     = k8s.ServiceTypeNodePort
+	ServiceTypeLoadBalancer = k8s.ServiceTypeLoadBalancer
+)
+
+// defaultServicePort is the Service port of the http port apps get when
+// they don't list any
+const defaultServicePort = 80
+
+// Port is a named port of an app's container. Protocol defaults to http and
+// ServicePort to Port. HTTP, HTTP/2 and gRPC ports with a Path are routed by
+// the Ingress on the app's domain; when no port has one, the first such
+// port is routed at /.
+type Port struct {
+	Name        string `json:"name" openapi:"minLength=1,maxLength=15"`
+	Port        int32  `json:"port" openapi:"minimum=1,maximum=65535"`
+	Protocol    string `json:"protocol,omitempty" openapi:"enum=http|http2|grpc|tcp|udp"`
+	ServicePort int32  `json:"service_port,omitempty" openapi:"minimum=1,maximum=65535"`
+	NodePort    int32  `json:"node_port,omitempty" openapi:"minimum=30000,maximum=32767"`
+	Path        string `json:"path,omitempty" openapi:"maxLength=255,pattern=^/"`
+}
+
+// portErrors checks an app's ports together with the fields they depend on:
+// the app's port must be one of them, and a public app's domain needs a
+// port the Ingress can route to
+func portErrors(n networkConfig) []FieldError {
+	ports, port, serviceType := n.Ports, n.Port, n.ServiceType
+	var fields []FieldError
+	add := func(field, msg string) {
+		fields = append(fields, FieldError{Field: field, Message: msg})
+	}
+
+	switch serviceType {
+	case ServiceTypeClusterIP, ServiceTypeNodePort, ServiceTypeLoadBalancer:
+	default:
+		add("service_type", "must be one of cluster_ip, node_port, load_balancer")
+	}
+
+	names := make(map[string]bool, len(ports))
+	containerPorts := make(map[string]bool, len(ports))
+	servicePorts := make(map[string]bool, len(ports))
+	paths := make(map[string]bool, len(ports))
+	hasPort := false
+	for i, p := range ports {
+		field := fmt.Sprintf("ports[%d]", i)
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = ProtocolHTTP
+		}
+
+		for _, msg := range validation.IsValidPortName(p.Name) {
+			add(field+".name", msg)
+		}
+		if names[p.Name] {
+			add(field+".name", "duplicate name "+p.Name)
+		}
+		names[p.Name] = true
+
+		switch protocol {
+		case ProtocolHTTP, ProtocolHTTP2, ProtocolGRPC, ProtocolTCP, ProtocolUDP:
+		default:
+			add(field+".protocol", "must be one of http, http2, grpc, tcp, udp")
+		}
+
+		// TCP and UDP ports are separate, so 53/tcp and 53/udp may coexist
+		transport := "tcp"
+		if protocol == ProtocolUDP {
+			transport = "udp"
+		}
+		if p.Port < 1 || p.Port > 65535 {
+			add(field+".port", "must be between 1 and 65535")
+		}
+		if key := fmt.Sprintf("%d/%s", p.Port, transport); containerPorts[key] {
+			add(field+".port", "duplicate container port "+key)
+		} else {
+			containerPorts[key] = true
+		}
+		servicePort := p.ServicePort
+		if servicePort == 0 {
+			servicePort = p.Port
+		}
+		if servicePort < 1 || servicePort > 65535 {
+			add(field+".service_port", "must be between 1 and 65535")
+		}
+		if key := fmt.Sprintf("%d/%s", servicePort, transport); servicePorts[key] {
+			add(field+".service_port", "duplicate service port "+key)
+		} else {
+			servicePorts[key] = true
+		}
+		if p.Port == port && transport == "tcp" {
+			hasPort = true
+		}
+
+		if p.NodePort != 0 {
+			switch {
+			case serviceType == ServiceTypeClusterIP:
+				add(field+".node_port", "requires service_type node_port or load_balancer")
+			case p.NodePort < 30000 || p.NodePort > 32767:
+				add(field+".node_port", "must be between 30000 and 32767")
+			}
+		}
+
+		if p.Path != "" {
+			switch {
+			case !routable(protocol):
+				add(field+".path", "is only used by http, http2 and grpc ports")
+			case !strings.HasPrefix(p.Path, "/"):
+				add(field+".path", "must start with /")
+			case paths[p.Path]:
+				add(field+".path", "duplicate path "+p.Path)
+			}
+			paths[p.Path] = true
+		}
+	}
+
+	if len(ports) > 0 && !hasPort {
+		add("port", "must be one of the TCP container ports in ports")
+	}
+
+	// Traefik sets the backend scheme per Service, so every routed port
+	// has to speak the same protocol
+	routed := routedPorts(resolvePorts(ports, port))
+	for _, p := range routed {
+		if isH2C(p.Protocol) != isH2C(routed[0].Protocol) {
+			add("ports", "routed ports must all be http, or all be http2 or grpc")
+			break
+		}
+	}
+	if n.Domain != "" && n.Visibility == VisibilityPublic && len(routed) == 0 {
+		add("domain", "needs an http, http2 or grpc port to route to")
+	}
+
+	return fields
+}
+
+// resolvePorts fills in the defaults of an app's ports. Without any ports
+// the app exposes port as http on Service port 80.
+func resolvePorts(ports []Port, port int32) []k8s.AppPort {
+	if len(ports) == 0 {
+		return []k8s.AppPort{{
+			Name:          "http",
+			ContainerPort: port,
+			Protocol:      ProtocolHTTP,
+			ServicePort:   defaultServicePort,
+			Path:          "/",
+		}}
+	}
+
+	resolved := make([]k8s.AppPort, 0, len(ports))
+	hasPath := false
+	for _, p := range ports {
+		r := k8s.AppPort{
+			Name:          p.Name,
+			ContainerPort: p.Port,
+			Protocol:      p.Protocol,
+			ServicePort:   p.ServicePort,
+			NodePort:      p.NodePort,
+			Path:          p.Path,
+		}
+		if r.Protocol == "" {
+			r.Protocol = ProtocolHTTP
+		}
+		if r.ServicePort == 0 {
+			r.ServicePort = r.ContainerPort
+		}
+		hasPath = hasPath || r.Path != ""
+		resolved = append(resolved, r)
+	}
+
+	if !hasPath {
+		for i := range resolved {
+			if routable(resolved[i].Protocol) {
+				resolved[i].Path = "/"
+				break
+			}
+		}
+	}
+	return resolved
+}
+
+// routedPorts returns the ports the Ingress routes to
+func routedPorts(ports []k8s.AppPort) []k8s.AppPort {
+	var routed []k8s.AppPort
+	for _, p := range ports {
+		if p.Path != "" && routable(p.Protocol) {
+			routed = append(routed, p)
+		}
+	}
+	return routed
+}
+
+func routable(protocol string) bool {
+	return protocol == ProtocolHTTP || isH2C(protocol)
+}
+
+func isH2C(protocol string) bool {
+	return protocol == ProtocolHTTP2 || protocol == ProtocolGRPC
+}
+
+// encodePorts encodes ports for the apps.ports column, storing nil as []
+func encodePorts(ports []Port) (json.RawMessage, error) {
+	if ports == nil {
+		ports = []Port{}
+	}
+	raw, err := json.Marshal(ports)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode ports: %w", err)
+	}
+	return raw, nil
+}
+
+// decodePorts decodes the apps.ports column
+func decodePorts(raw json.RawMessage) ([]Port, error) {
+	ports := []Port{}
+	if len(raw) == 0 {
+		return ports, nil
+	}
+	if err := json.Unmarshal(raw, &ports); err != nil {
+		return nil, fmt.Errorf("failed to decode ports: %w", err)
+	}
+	return ports, nil
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/superfly/superfly/internal/k8s"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestPortErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		ports       []Port
+		port        int32
+		domain      string
+		serviceType string
+		visibility  string
+		want        []FieldError
+	}{
+		{
+			name:        "default port with a domain",
+			port:        8080,
+			domain:      "web.example.com",
+			serviceType: ServiceTypeClusterIP,
+		},
+		{
+			name: "http, tcp and the same port over udp and tcp",
+			ports: []Port{
+				{Name: "web", Port: 8080},
+				{Name: "postgres", Port: 5432, Protocol: ProtocolTCP},
+				{Name: "dns", Port: 53, Protocol: ProtocolUDP},
+				{Name: "dns-tcp", Port: 53, Protocol: ProtocolTCP},
+			},
+			port:        8080,
+			domain:      "web.example.com",
+			serviceType: ServiceTypeLoadBalancer,
+		},
+		{
+			name:        "unknown service type",
+			port:        8080,
+			serviceType: "external_name",
+			want:        []FieldError{{Field: "service_type", Message: "must be one of cluster_ip, node_port, load_balancer"}},
+		},
+		{
+			name:        "invalid and duplicate names",
+			ports:       []Port{{Name: "Web_UI", Port: 8080}, {Name: "admin", Port: 9000}, {Name: "admin", Port: 9001}},
+			port:        8080,
+			serviceType: ServiceTypeClusterIP,
+			want: append(
+				fieldMessages("ports[0].name", validation.IsValidPortName("Web_UI")),
+				FieldError{Field: "ports[2].name", Message: "duplicate name admin"},
+			),
+		},
+		{
+			name:        "unknown protocol",
+			ports:       []Port{{Name: "web", Port: 8080, Protocol: "sctp"}},
+			port:        8080,
+			serviceType: ServiceTypeClusterIP,
+			want:        []FieldError{{Field: "ports[0].protocol", Message: "must be one of http, http2, grpc, tcp, udp"}},
+		},
+		{
+			name:        "duplicate container and service ports",
+			ports:       []Port{{Name: "web", Port: 8080}, {Name: "admin", Port: 8080}, {Name: "metrics", Port: 9090, ServicePort: 8080}},
+			port:        8080,
+			serviceType: ServiceTypeClusterIP,
+			want: []FieldError{
+				{Field: "ports[1].port", Message: "duplicate container port 8080/tcp"},
+				{Field: "ports[1].service_port", Message: "duplicate service port 8080/tcp"},
+				{Field: "ports[2].service_port", Message: "duplicate service port 8080/tcp"},
+			},
+		},
+		{
+			name:        "port not among the tcp ports",
+			ports:       []Port{{Name: "dns", Port: 53, Protocol: ProtocolUDP}},
+			port:        53,
+			serviceType: ServiceTypeClusterIP,
+			want:        []FieldError{{Field: "port", Message: "must be one of the TCP container ports in ports"}},
+		},
+		{
+			name:        "node port on a cluster ip service",
+			ports:       []Port{{Name: "web", Port: 8080, NodePort: 30080}},
+			port:        8080,
+			serviceType: ServiceTypeClusterIP,
+			want:        []FieldError{{Field: "ports[0].node_port", Message: "requires service_type node_port or load_balancer"}},
+		},
+		{
+			name:        "node port out of range",
+			ports:       []Port{{Name: "web", Port: 8080, NodePort: 8080}},
+			port:        8080,
+			serviceType: ServiceTypeNodePort,
+			want:        []FieldError{{Field: "ports[0].node_port", Message: "must be between 30000 and 32767"}},
+		},
+		{
+			name: "invalid paths",
+			ports: []Port{
+				{Name: "web", Port: 8080, Path: "/"},
+				{Name: "admin", Port: 9000, Path: "/"},
+				{Name: "api", Port: 9001, Path: "api"},
+				{Name: "postgres", Port: 5432, Protocol: ProtocolTCP, Path: "/db"},
+			},
+			port:        8080,
+			serviceType: ServiceTypeClusterIP,
+			want: []FieldError{
+				{Field: "ports[1].path", Message: "duplicate path /"},
+				{Field: "ports[2].path", Message: "must start with /"},
+				{Field: "ports[3].path", Message: "is only used by http, http2 and grpc ports"},
+			},
+		},
+		{
+			name:        "routed http and grpc ports",
+			ports:       []Port{{Name: "web", Port: 8080, Path: "/"}, {Name: "api", Port: 9090, Protocol: ProtocolGRPC, Path: "/api"}},
+			port:        8080,
+			serviceType: ServiceTypeClusterIP,
+			want:        []FieldError{{Field: "ports", Message: "routed ports must all be http, or all be http2 or grpc"}},
+		},
+		{
+			name:        "domain without a routable port",
+			ports:       []Port{{Name: "postgres", Port: 5432, Protocol: ProtocolTCP}},
+			port:        5432,
+			domain:      "db.example.com",
+			serviceType: ServiceTypeLoadBalancer,
+			want:        []FieldError{{Field: "domain", Message: "needs an http, http2 or grpc port to route to"}},
+		},
+		{
+			name:        "internal app with a domain",
+			ports:       []Port{{Name: "postgres", Port: 5432, Protocol: ProtocolTCP}},
+			port:        5432,
+			domain:      "db.example.com",
+			serviceType: ServiceTypeClusterIP,
+			visibility:  VisibilityInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := networkConfig{
+				Port:        tt.port,
+				Ports:       tt.ports,
+				Domain:      tt.domain,
+				ServiceType: tt.serviceType,
+				Visibility:  tt.visibility,
+			}
+			if n.Visibility == "" {
+				n.Visibility = VisibilityPublic
+			}
+			got := portErrors(n)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePorts(t *testing.T) {
+	tests := []struct {
+		name  string
+		ports []Port
+		port  int32
+		want  []k8s.AppPort
+	}{
+		{
+			name: "no ports",
+			port: 3000,
+			want: []k8s.AppPort{{Name: "http", ContainerPort: 3000, Protocol: ProtocolHTTP, ServicePort: 80, Path: "/"}},
+		},
+		{
+			name:  "first routable port is routed at /",
+			ports: []Port{{Name: "postgres", Port: 5432, Protocol: ProtocolTCP}, {Name: "web", Port: 8080}, {Name: "admin", Port: 9000}},
+			port:  8080,
+			want: []k8s.AppPort{
+				{Name: "postgres", ContainerPort: 5432, Protocol: ProtocolTCP, ServicePort: 5432},
+				{Name: "web", ContainerPort: 8080, Protocol: ProtocolHTTP, ServicePort: 8080, Path: "/"},
+				{Name: "admin", ContainerPort: 9000, Protocol: ProtocolHTTP, ServicePort: 9000},
+			},
+		},
+		{
+			name:  "explicit paths and service ports",
+			ports: []Port{{Name: "web", Port: 8080, ServicePort: 80}, {Name: "api", Port: 9090, Protocol: ProtocolHTTP2, Path: "/api", NodePort: 30090}},
+			port:  8080,
+			want: []k8s.AppPort{
+				{Name: "web", ContainerPort: 8080, Protocol: ProtocolHTTP, ServicePort: 80},
+				{Name: "api", ContainerPort: 9090, Protocol: ProtocolHTTP2, ServicePort: 9090, NodePort: 30090, Path: "/api"},
+			},
+		},
+		{
+			name:  "no routable port",
+			ports: []Port{{Name: "dns", Port: 53, Protocol: ProtocolUDP}},
+			port:  53,
+			want:  []k8s.AppPort{{Name: "dns", ContainerPort: 53, Protocol: ProtocolUDP, ServicePort: 53}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolvePorts(tt.ports, tt.port)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func fieldMessages(field string, msgs []string) []FieldError {
+	fields := make([]FieldError, len(msgs))
+	for i, msg := range msgs {
+		fields[i] = FieldError{Field: field, Message: msg}
+	}
+	return fields
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/k8s"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// resourceConfig is the compute resources of an app's container: quantities
+// such as 500m or 256Mi. Requests are checked against their limits, so the
+// fields are validated together.
+type resourceConfig k8s.Resources
+
+// defaultCPULimit and defaultMemoryLimit are the limits of apps that set
+// none, and of apps with a size
+const (
+	defaultCPULimit    = "500m"
+	defaultMemoryLimit = "256Mi"
+)
+
+// apiResourceFields and manifestResourceFields name the fields of a
+// resourceConfig in validation errors, as the API and manifests call them
+var (
+	apiResourceFields = resourceConfig{
+		CPURequest:              "cpu_request",
+		CPULimit:                "cpu_limit",
+		MemoryRequest:           "memory_request",
+		MemoryLimit:             "memory_limit",
+		EphemeralStorageRequest: "ephemeral_storage_request",
+		EphemeralStorageLimit:   "ephemeral_storage_limit",
+	}
+	manifestResourceFields = resourceConfig{
+		CPURequest:              "resources.cpu_request",
+		CPULimit:                "resources.cpu",
+		MemoryRequest:           "resources.memory_request",
+		MemoryLimit:             "resources.memory",
+		EphemeralStorageRequest: "resources.ephemeral_storage_request",
+		EphemeralStorageLimit:   "resources.ephemeral_storage",
+	}
+)
+
+// resourcesFromApp returns the resources of a stored app
+func resourcesFromApp(app *db.App) resourceConfig {
+	return resourceConfig{
+		CPURequest:              app.CpuRequest,
+		CPULimit:                app.CpuLimit,
+		MemoryRequest:           app.MemoryRequest,
+		MemoryLimit:             app.MemoryLimit,
+		EphemeralStorageRequest: app.EphemeralStorageRequest,
+		EphemeralStorageLimit:   app.EphemeralStorageLimit,
+	}
+}
+
+// validateResources checks an app's requests and limits, naming fields as
+// the API does
+func validateResources(r resourceConfig) error {
+	if fields := resourceErrors(r, apiResourceFields); len(fields) > 0 {
+		return validationFailed(fields...)
+	}
+	return nil
+}
+
+// validateUpdatedResources validates the resources an update leaves the app
+// with, since requests are checked against limits
+func validateUpdatedResources(app *db.App, input UpdateAppInput) error {
+	r := resourcesFromApp(app)
+	for _, field := range []struct {
+		value  *string
+		target *string
+	}{
+		{input.CPURequest, &r.CPURequest},
+		{input.CPULimit, &r.CPULimit},
+		{input.MemoryRequest, &r.MemoryRequest},
+		{input.MemoryLimit, &r.MemoryLimit},
+		{input.EphemeralStorageRequest, &r.EphemeralStorageRequest},
+		{input.EphemeralStorageLimit, &r.EphemeralStorageLimit},
+	} {
+		if field.value != nil {
+			*field.target = *field.value
+		}
+	}
+	return validateResources(r)
+}
+
+// resourceErrors checks that every quantity parses, that CPU and memory
+// have limits, that limits are positive and that no request exceeds its
+// limit. Fields are named after names.
+func resourceErrors(r, names resourceConfig) []FieldError {
+	var fields []FieldError
+	add := func(field, msg string) {
+		fields = append(fields, FieldError{Field: field, Message: msg})
+	}
+
+	for _, res := range []struct {
+		request, limit           string
+		requestField, limitField string
+		example                  string
+		limitRequired            bool
+	}{
+		{r.CPURequest, r.CPULimit, names.CPURequest, names.CPULimit, "250m or 1.5", true},
+		{r.MemoryRequest, r.MemoryLimit, names.MemoryRequest, names.MemoryLimit, "256Mi or 1Gi", true},
+		{r.EphemeralStorageRequest, r.EphemeralStorageLimit, names.EphemeralStorageRequest, names.EphemeralStorageLimit, "512Mi or 2Gi", false},
+	} {
+		invalid := "must be a quantity such as " + res.example
+
+		var limit *resource.Quantity
+		switch q, err := resource.ParseQuantity(res.limit); {
+		case res.limit == "":
+			if res.limitRequired {
+				add(res.limitField, "is required")
+			}
+		case err != nil:
+			add(res.limitField, invalid)
+		case q.Sign() <= 0:
+			add(res.limitField, "must be greater than zero")
+		default:
+			limit = &q
+		}
+
+		if res.request == "" {
+			continue
+		}
+		switch q, err := resource.ParseQuantity(res.request); {
+		case err != nil:
+			add(res.requestField, invalid)
+		case q.Sign() < 0:
+			add(res.requestField, "must not be negative")
+		case limit != nil && q.Cmp(*limit) > 0:
+			add(res.requestField, "must not exceed "+res.limitField+" ("+limit.String()+")")
+		}
+	}
+	return fields
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResourceErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources resourceConfig
+		want      []FieldError
+	}{
+		{
+			name:      "limits only",
+			resources: resourceConfig{CPULimit: "500m", MemoryLimit: "256Mi"},
+		},
+		{
+			name: "requests up to the limits",
+			resources: resourceConfig{
+				CPURequest: "500m", CPULimit: "0.5",
+				MemoryRequest: "128Mi", MemoryLimit: "1Gi",
+				EphemeralStorageRequest: "1Gi", EphemeralStorageLimit: "2Gi",
+			},
+		},
+		{
+			name:      "missing limits",
+			resources: resourceConfig{},
+			want: []FieldError{
+				{Field: "cpu_limit", Message: "is required"},
+				{Field: "memory_limit", Message: "is required"},
+			},
+		},
+		{
+			name:      "invalid quantities",
+			resources: resourceConfig{CPULimit: "fast", MemoryLimit: "256MB", EphemeralStorageRequest: "some"},
+			want: []FieldError{
+				{Field: "cpu_limit", Message: "must be a quantity such as 250m or 1.5"},
+				{Field: "memory_limit", Message: "must be a quantity such as 256Mi or 1Gi"},
+				{Field: "ephemeral_storage_request", Message: "must be a quantity such as 512Mi or 2Gi"},
+			},
+		},
+		{
+			name:      "zero and negative",
+			resources: resourceConfig{CPULimit: "0", MemoryRequest: "-1Mi", MemoryLimit: "256Mi"},
+			want: []FieldError{
+				{Field: "cpu_limit", Message: "must be greater than zero"},
+				{Field: "memory_request", Message: "must not be negative"},
+			},
+		},
+		{
+			name:      "requests over the limits",
+			resources: resourceConfig{CPURequest: "1", CPULimit: "500m", MemoryRequest: "2Gi", MemoryLimit: "1Gi"},
+			want: []FieldError{
+				{Field: "cpu_request", Message: "must not exceed cpu_limit (500m)"},
+				{Field: "memory_request", Message: "must not exceed memory_limit (1Gi)"},
+			},
+		},
+		{
+			name:      "storage request without a limit",
+			resources: resourceConfig{CPULimit: "1", MemoryLimit: "1Gi", EphemeralStorageRequest: "10Gi"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resourceErrors(tt.resources, apiResourceFields)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceErrorsNamesManifestFields(t *testing.T) {
+	got := resourceErrors(resourceConfig{MemoryRequest: "1Gi", MemoryLimit: "512Mi"}, manifestResourceFields)
+	want := []FieldError{
+		{Field: "resources.cpu", Message: "is required"},
+		{Field: "resources.memory_request", Message: "must not exceed resources.memory (512Mi)"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
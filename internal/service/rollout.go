@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/k8s"
+	"github.com/superfly/superfly/internal/logging"
+)
+
+// rolloutTimeout bounds how long a deploy waits for its rollout
+const rolloutTimeout = 5 * time.Minute
+
+// waitForRollout watches the rollout of an app's Deployment, reporting its
+// progress to the deploy tracker. If the rollout fails and the app has
+// auto rollback on, the Deployment is rolled back to its previous
+// revision; the deploy still fails, since the app no longer runs what it
+// describes.
+func (s *AppService) waitForRollout(ctx context.Context, client *k8s.Client, deploymentID string, app *db.App) error {
+	logger := logging.FromContext(ctx, s.logger)
+
+	err := client.WatchRollout(ctx, app.Namespace, app.Slug, rolloutTimeout, func(status k8s.RolloutStatus) {
+		s.progress(ctx, deploymentID, app, "waiting for rollout: "+status.String())
+	})
+	var rolloutErr *k8s.RolloutError
+	if !errors.As(err, &rolloutErr) {
+		return err
+	}
+	logger.Warn("rollout failed", "reason", rolloutErr.Reason, "message", rolloutErr.Message)
+
+	if !app.AutoRollback {
+		return err
+	}
+	s.progress(ctx, deploymentID, app, "rolling back")
+	revision, rollbackErr := client.RollbackDeployment(ctx, app.Namespace, app.Slug)
+	if rollbackErr != nil {
+		logger.Error("rollback failed", "error", rollbackErr)
+		return fmt.Errorf("%w; rollback failed: %v", err, rollbackErr)
+	}
+	return fmt.Errorf("%w; rolled back to revision %d", err, revision)
+}
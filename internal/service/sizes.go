@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/superfly/superfly/internal/auth"
+	"github.com/superfly/superfly/internal/k8s"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Sizes is the catalog of machine sizes apps pick from. With a catalog,
+// apps get the default size unless they pick another, and only admins may
+// set resources of their own. A nil catalog has no sizes, and anyone may
+// set resources, as before sizes existed.
+type Sizes struct {
+	sizes       []k8s.Size
+	byName      map[string]*k8s.Size
+	defaultSize string
+}
+
+// NewSizes checks a catalog. defaultSize is the size of apps that don't
+// pick one, the first of the catalog when empty.
+func NewSizes(sizes []k8s.Size, defaultSize string) (*Sizes, error) {
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("no sizes configured")
+	}
+
+	catalog := &Sizes{sizes: sizes, byName: make(map[string]*k8s.Size, len(sizes)), defaultSize: defaultSize}
+	for i := range sizes {
+		size := &sizes[i]
+		if msgs := validation.IsDNS1123Label(size.Name); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid size name %q: %s", size.Name, strings.Join(msgs, ", "))
+		}
+		if _, ok := catalog.byName[size.Name]; ok {
+			return nil, fmt.Errorf("duplicate size %s", size.Name)
+		}
+		if fields := resourceErrors(resourceConfig(size.Resources()), sizeResourceFields); len(fields) > 0 {
+			return nil, fmt.Errorf("size %s: %s %s", size.Name, fields[0].Field, fields[0].Message)
+		}
+		for key, value := range size.NodeSelector {
+			msgs := append(validation.IsQualifiedName(key), validation.IsValidLabelValue(value)...)
+			if len(msgs) > 0 {
+				return nil, fmt.Errorf("size %s: invalid node selector %s=%s: %s", size.Name, key, value, strings.Join(msgs, ", "))
+			}
+		}
+		if size.PricePerHour < 0 {
+			return nil, fmt.Errorf("size %s: price_per_hour must not be negative", size.Name)
+		}
+		catalog.byName[size.Name] = size
+	}
+
+	if catalog.defaultSize == "" {
+		catalog.defaultSize = sizes[0].Name
+	}
+	if _, ok := catalog.byName[catalog.defaultSize]; !ok {
+		return nil, fmt.Errorf("default size %s is not in the catalog", catalog.defaultSize)
+	}
+	return catalog, nil
+}
+
+// sizeResourceFields names the resources of a size as the sizes file does
+var sizeResourceFields = resourceConfig{
+	CPURequest:              "cpu_request",
+	CPULimit:                "cpu_limit",
+	MemoryRequest:           "memory_request",
+	MemoryLimit:             "memory_limit",
+	EphemeralStorageRequest: "ephemeral_storage_request",
+	EphemeralStorageLimit:   "ephemeral_storage_limit",
+}
+
+// List returns the sizes in the order of the catalog
+func (c *Sizes) List() []k8s.Size {
+	if c == nil {
+		return nil
+	}
+	return c.sizes
+}
+
+// Get returns the named size
+func (c *Sizes) Get(name string) (*k8s.Size, bool) {
+	if c == nil {
+		return nil, false
+	}
+	size, ok := c.byName[name]
+	return size, ok
+}
+
+// Default returns the size of apps that don't pick one, or "" without a
+// catalog
+func (c *Sizes) Default() string {
+	if c == nil {
+		return ""
+	}
+	return c.defaultSize
+}
+
+// checkSize validates the size an app is left with. custom is whether the
+// caller sets resources of the app's own, which only admins may do when
+// there is a catalog, and which can't be combined with a size.
+func (s *AppService) checkSize(ctx context.Context, size string, custom bool) error {
+	if size != "" {
+		if _, ok := s.sizes.Get(size); !ok {
+			return validationFailed(FieldError{Field: "size", Message: "is not a size of the catalog, see GET /api/sizes"})
+		}
+		if custom {
+			return validationFailed(FieldError{Field: "size", Message: "can't be set along with resources; set size to \"\" to use resources of the app's own"})
+		}
+		return nil
+	}
+
+	if custom && s.sizes != nil && !auth.FromContext(ctx).Role.Allows(auth.RoleAdmin) {
+		return forbidden(CodeCustomResources, "only admins may set resources; pick a size from GET /api/sizes instead")
+	}
+	return nil
+}
+
+// spec is the Kubernetes spec a deploy of the config would apply, with its
+// size resolved from the catalog
+func (s *AppService) spec(c *appConfig, slug string) (k8s.AppSpec, error) {
+	spec := c.spec(slug)
+	if c.Size != "" {
+		size, ok := s.sizes.Get(c.Size)
+		if !ok {
+			return k8s.AppSpec{}, fmt.Errorf("size %s is not in the catalog anymore", c.Size)
+		}
+		spec.Size = size
+	}
+	return spec, nil
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/superfly/superfly/internal/auth"
+	"github.com/superfly/superfly/internal/k8s"
+)
+
+func TestNewSizes(t *testing.T) {
+	small := k8s.Size{Name: "small", CPULimit: "500m", MemoryLimit: "256Mi"}
+	large := k8s.Size{Name: "large", CPULimit: "2", MemoryLimit: "4Gi", NodeSelector: map[string]string{"superfly.dev/pool": "performance"}}
+
+	tests := []struct {
+		name        string
+		sizes       []k8s.Size
+		defaultSize string
+		wantDefault string
+		wantErr     string
+	}{
+		{name: "first is the default", sizes: []k8s.Size{small, large}, wantDefault: "small"},
+		{name: "named default", sizes: []k8s.Size{small, large}, defaultSize: "large", wantDefault: "large"},
+		{name: "empty", wantErr: "no sizes configured"},
+		{name: "unknown default", sizes: []k8s.Size{small}, defaultSize: "huge", wantErr: "default size huge is not in the catalog"},
+		{name: "invalid name", sizes: []k8s.Size{{Name: "Small", CPULimit: "1", MemoryLimit: "1Gi"}}, wantErr: `invalid size name "Small"`},
+		{name: "duplicate", sizes: []k8s.Size{small, small}, wantErr: "duplicate size small"},
+		{name: "without limits", sizes: []k8s.Size{{Name: "tiny", CPULimit: "100m"}}, wantErr: "size tiny: memory_limit is required"},
+		{
+			name:    "request over the limit",
+			sizes:   []k8s.Size{{Name: "tiny", CPURequest: "1", CPULimit: "100m", MemoryLimit: "64Mi"}},
+			wantErr: "size tiny: cpu_request must not exceed cpu_limit (100m)",
+		},
+		{
+			name:    "invalid node selector",
+			sizes:   []k8s.Size{{Name: "tiny", CPULimit: "100m", MemoryLimit: "64Mi", NodeSelector: map[string]string{"pool": "not valid"}}},
+			wantErr: "size tiny: invalid node selector pool=not valid",
+		},
+		{
+			name:    "negative price",
+			sizes:   []k8s.Size{{Name: "tiny", CPULimit: "100m", MemoryLimit: "64Mi", PricePerHour: -1}},
+			wantErr: "size tiny: price_per_hour must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog, err := NewSizes(tt.sizes, tt.defaultSize)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if catalog.Default() != tt.wantDefault {
+				t.Errorf("got default %q, want %q", catalog.Default(), tt.wantDefault)
+			}
+			if _, ok := catalog.Get("large"); !ok {
+				t.Error("large is not in the catalog")
+			}
+		})
+	}
+}
+
+func TestNilSizes(t *testing.T) {
+	var catalog *Sizes
+	if catalog.List() != nil || catalog.Default() != "" {
+		t.Errorf("a nil catalog has sizes %v, default %q", catalog.List(), catalog.Default())
+	}
+	if _, ok := catalog.Get("small"); ok {
+		t.Error("a nil catalog has a size")
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	catalog, err := NewSizes([]k8s.Size{{Name: "small", CPULimit: "500m", MemoryLimit: "256Mi"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	developer := auth.WithPrincipal(context.Background(), auth.Principal{Name: "dev", Role: auth.RoleDeveloper})
+	admin := auth.WithPrincipal(context.Background(), auth.Principal{Name: "ada", Role: auth.RoleAdmin})
+
+	tests := []struct {
+		name       string
+		sizes      *Sizes
+		ctx        context.Context
+		size       string
+		custom     bool
+		wantKind   error
+		wantFields []string
+	}{
+		{name: "size of the catalog", sizes: catalog, ctx: developer, size: "small"},
+		{name: "default size", sizes: catalog, ctx: developer},
+		{name: "unknown size", sizes: catalog, ctx: developer, size: "huge", wantKind: ErrValidation, wantFields: []string{"size"}},
+		{name: "size and resources", sizes: catalog, ctx: admin, size: "small", custom: true, wantKind: ErrValidation, wantFields: []string{"size"}},
+		{name: "developer with resources", sizes: catalog, ctx: developer, custom: true, wantKind: ErrForbidden},
+		{name: "admin with resources", sizes: catalog, ctx: admin, custom: true},
+		{name: "resources without a catalog", ctx: developer, custom: true},
+		{name: "size without a catalog", ctx: developer, size: "small", wantKind: ErrValidation, wantFields: []string{"size"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &AppService{sizes: tt.sizes}
+			err := s.checkSize(tt.ctx, tt.size, tt.custom)
+			if tt.wantKind == nil {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantKind) {
+				t.Fatalf("got error %v, want %v", err, tt.wantKind)
+			}
+			if got := fieldNames(err); !slices.Equal(got, tt.wantFields) {
+				t.Errorf("got errors for %v, want %v", got, tt.wantFields)
+			}
+		})
+	}
+}
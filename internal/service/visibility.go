@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/google/uuid"
+	"github.com/superfly/superfly/internal/db"
+	"github.com/superfly/superfly/internal/k8s"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// App visibilities
+const (
+	VisibilityPublic   = k8s.VisibilityPublic
+	VisibilityInternal = k8s.VisibilityInternal
+	VisibilityNone     = k8s.VisibilityNone
+)
+
+// Egress policies
+const (
+	EgressAllowAll     = k8s.EgressAllowAll
+	EgressDenyInternet = k8s.EgressDenyInternet
+	EgressAllowlist    = k8s.EgressAllowlist
+)
+
+// clusterDomain is the DNS suffix of Services in the cluster
+const clusterDomain = "svc.cluster.local"
+
+// InternalDNS lists the names other apps in the cluster reach an app by
+type InternalDNS struct {
+	Hostname string   `json:"hostname"`
+	Aliases  []string `json:"aliases"`
+}
+
+// AppInternalDNS returns the cluster DNS names of an app, or nil when its
+// visibility is none and it has no Service
+func AppInternalDNS(app *db.App) *InternalDNS {
+	if app.Visibility == VisibilityNone {
+		return nil
+	}
+
+	dns := &InternalDNS{
+		Hostname: serviceHostname(app.Slug, app.Namespace),
+		Aliases:  make([]string, 0, len(app.Aliases)),
+	}
+	for _, alias := range app.Aliases {
+		dns.Aliases = append(dns.Aliases, serviceHostname(alias, app.Namespace))
+	}
+	return dns
+}
+
+func serviceHostname(name, namespace string) string {
+	return name + "." + namespace + "." + clusterDomain
+}
+
+// networkConfig is the part of an app that decides how it is reached. Its
+// fields depend on each other, so they are validated together.
+type networkConfig struct {
+	Port        int32
+	Ports       []Port
+	Domain      string
+	ServiceType string
+	Visibility  string
+	Aliases     []string
+	AllowFrom   []string
+	Egress      string
+	EgressCIDRs []string
+}
+
+// validateNetwork checks an app's ports, visibility, aliases, callers and
+// egress
+func validateNetwork(slug string, n networkConfig) error {
+	if fields := networkErrors(slug, n); len(fields) > 0 {
+		return validationFailed(fields...)
+	}
+	return nil
+}
+
+func networkErrors(slug string, n networkConfig) []FieldError {
+	fields := portErrors(n)
+	add := func(field, msg string) {
+		fields = append(fields, FieldError{Field: field, Message: msg})
+	}
+
+	switch n.Visibility {
+	case VisibilityPublic:
+	case VisibilityInternal, VisibilityNone:
+		if n.Domain != "" {
+			add("domain", "requires public visibility")
+		}
+		if n.ServiceType != ServiceTypeClusterIP {
+			add("service_type", "requires public visibility")
+		}
+	default:
+		add("visibility", "must be one of public, internal, none")
+	}
+
+	if n.Visibility == VisibilityNone && len(n.Aliases) > 0 {
+		add("aliases", "are not available with visibility none, which has no Service")
+	}
+	seen := make(map[string]bool, len(n.Aliases))
+	for i, alias := range n.Aliases {
+		field := fmt.Sprintf("aliases[%d]", i)
+		for _, msg := range validation.IsDNS1035Label(alias) {
+			add(field, msg)
+		}
+		switch {
+		case alias == slug:
+			add(field, "must differ from the app's slug")
+		case seen[alias]:
+			add(field, "duplicate alias "+alias)
+		}
+		seen[alias] = true
+	}
+
+	if n.Visibility == VisibilityNone && len(n.AllowFrom) > 0 {
+		add("allow_from", "is not available with visibility none, which accepts no traffic")
+	}
+	for i, caller := range n.AllowFrom {
+		for _, msg := range validation.IsDNS1123Label(caller) {
+			add(fmt.Sprintf("allow_from[%d]", i), msg)
+		}
+	}
+
+	switch n.Egress {
+	case EgressAllowAll, EgressDenyInternet:
+		if len(n.EgressCIDRs) > 0 {
+			add("egress_cidrs", "only apply to egress allowlist")
+		}
+	case EgressAllowlist:
+		if len(n.EgressCIDRs) == 0 {
+			add("egress_cidrs", "are required for egress allowlist")
+		}
+	default:
+		add("egress", "must be one of allow_all, deny_internet, allowlist")
+	}
+	for i, cidr := range n.EgressCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			add(fmt.Sprintf("egress_cidrs[%d]", i), "must be a CIDR such as 203.0.113.0/24")
+		}
+	}
+
+	return fields
+}
+
+// checkAliases fails with a conflict if an alias is another app's slug or
+// alias. id is the app the aliases are for, or uuid.Nil for a new app.
+func (s *AppService) checkAliases(ctx context.Context, id uuid.UUID, aliases []string) error {
+	if len(aliases) == 0 {
+		return nil
+	}
+	taken, err := s.queries.CheckAliasesTaken(ctx, db.CheckAliasesTakenParams{ID: id, Names: aliases})
+	if err != nil {
+		return dbError(err, "failed to check aliases")
+	}
+	if taken {
+		return conflict(CodeAliasTaken, "an alias is already the slug or alias of another app")
+	}
+	return nil
+}
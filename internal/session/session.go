@@ -0,0 +1,207 @@
+// Package session is the protocol of the interactive sessions the API
+// serves over WebSocket, shared by the API and the superfly CLI.
+//
+// Every message is a binary WebSocket message whose first byte is the
+// channel it belongs to, followed by its payload. In an exec session the
+// client writes ChannelStdin and ChannelResize, and the server writes
+// ChannelStdout and ChannelStderr and lastly ChannelStatus. In a
+// port-forward session both write ForwardData and ForwardClose, the client
+// ForwardOpen and the server lastly ChannelStatus.
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Channels of an exec session
+const (
+	// ChannelStdin carries the command's input; an empty payload closes it
+	ChannelStdin byte = 0
+	// ChannelStdout and ChannelStderr carry the command's output. With a
+	// TTY everything goes to ChannelStdout.
+	ChannelStdout byte = 1
+	ChannelStderr byte = 2
+	// ChannelStatus carries a Status as JSON and ends the session
+	ChannelStatus byte = 3
+	// ChannelResize carries a Resize as JSON
+	ChannelResize byte = 4
+)
+
+// Channels of a port-forward session, which carries any number of
+// connections to the port. Their payload starts with the ID of the
+// connection, a big-endian uint32 the client picks.
+const (
+	// ForwardOpen opens a connection
+	ForwardOpen byte = 5
+	// ForwardData carries data of a connection
+	ForwardData byte = 6
+	// ForwardClose from the client means no more data follows, and from
+	// the server that the connection is closed, with the reason it failed
+	// as the rest of the payload, if it did
+	ForwardClose byte = 7
+)
+
+// Keepalive of the WebSocket: the server pings every PingInterval, and
+// gives up on a client that didn't answer within PongWait
+const (
+	PingInterval = 30 * time.Second
+	PongWait     = 2 * PingInterval
+	writeWait    = 10 * time.Second
+)
+
+// Resize is the size of the client's terminal, in characters
+type Resize struct {
+	Width  uint16 `json:"width"`
+	Height uint16 `json:"height"`
+}
+
+// Status is how a session ended: the exit code of the command, or the
+// error that kept it from running to its end
+type Status struct {
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	// Code is the API error code of Error, if it has one
+	Code string `json:"code,omitempty"`
+}
+
+// Conn sends and receives the messages of a session. Send, SendJSON and
+// Close may be called concurrently, Receive from one goroutine at a time.
+type Conn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+// NewConn wraps a WebSocket connection
+func NewConn(ws *websocket.Conn) *Conn {
+	return &Conn{ws: ws}
+}
+
+// Send sends a message on a channel
+func (c *Conn) Send(channel byte, payload []byte) error {
+	message := make([]byte, 1+len(payload))
+	message[0] = channel
+	copy(message[1:], payload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteMessage(websocket.BinaryMessage, message)
+}
+
+// SendJSON sends v as JSON on a channel
+func (c *Conn) SendJSON(channel byte, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(channel, payload)
+}
+
+// Writer returns a writer whose writes are sent on a channel
+func (c *Conn) Writer(channel byte) io.Writer {
+	return channelWriter{conn: c, channel: channel}
+}
+
+// SendForward sends a message of a forwarded connection
+func (c *Conn) SendForward(channel byte, id uint32, payload []byte) error {
+	message := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(message, id)
+	copy(message[4:], payload)
+	return c.Send(channel, message)
+}
+
+// ForwardWriter returns a writer whose writes are sent as the data of a
+// forwarded connection
+func (c *Conn) ForwardWriter(id uint32) io.Writer {
+	return forwardWriter{conn: c, id: id}
+}
+
+// ParseForward splits the payload of a port-forward message into the ID of
+// its connection and the rest
+func ParseForward(payload []byte) (uint32, []byte, error) {
+	if len(payload) < 4 {
+		return 0, nil, fmt.Errorf("session: forward message without a connection ID")
+	}
+	return binary.BigEndian.Uint32(payload), payload[4:], nil
+}
+
+// Receive returns the next message and the channel it was sent on
+func (c *Conn) Receive() (byte, []byte, error) {
+	for {
+		messageType, message, err := c.ws.ReadMessage()
+		if err != nil {
+			return 0, nil, err
+		}
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+		if len(message) == 0 {
+			return 0, nil, fmt.Errorf("session: message without a channel")
+		}
+		return message[0], message[1:], nil
+	}
+}
+
+// Close closes the connection, telling the other side it is done
+func (c *Conn) Close() error {
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	c.ws.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+	return c.ws.Close()
+}
+
+// KeepAlive pings the other side in the background until ctx is done, and
+// makes Receive fail once it stops answering. It must be called before
+// Receive.
+func (c *Conn) KeepAlive(ctx context.Context) {
+	c.ws.SetReadDeadline(time.Now().Add(PongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(PongWait))
+	})
+
+	go func() {
+		ticker := time.NewTicker(PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+type channelWriter struct {
+	conn    *Conn
+	channel byte
+}
+
+func (w channelWriter) Write(p []byte) (int, error) {
+	if err := w.conn.Send(w.channel, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type forwardWriter struct {
+	conn *Conn
+	id   uint32
+}
+
+func (w forwardWriter) Write(p []byte) (int, error) {
+	if err := w.conn.SendForward(ForwardData, w.id, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,11 @@
+package version
+
+// Build information, injected at build time with:
+//
+//	go build -ldflags "-X github.com/superfly/superfly/internal/version.Version=v1.2.3 \
+//	  -X github.com/superfly/superfly/internal/version.Commit=$(git rev-parse HEAD)"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)